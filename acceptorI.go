@@ -25,5 +25,6 @@ type AcceptorI interface {
 	Close() error
 	IsClosed() bool
 	GetEndPoint() EndPointI
+	Stats() AcceptorStats
 	String() string
 }
@@ -0,0 +1,185 @@
+//go:build unix
+
+package transport
+
+// xlTransport_go/activation.go
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Socket activation: a node may be handed its listening sockets by
+// systemd or some other supervisor rather than opening them itself, so
+// that it can be started on demand, restarted without refusing
+// connections, or bind privileged ports without privilege.
+//
+// The sockets are passed as inherited file descriptors numbered from
+// SD_LISTEN_FDS_START, and described by the environment as systemd
+// describes them: LISTEN_FDS is their number, LISTEN_FDNAMES their
+// names separated by colons, and LISTEN_PID the process they are meant
+// for.  A supervisor which cannot know the child's pid before starting
+// it may leave LISTEN_PID unset; if it is set and is not ours, the
+// sockets are meant for some other process and are ignored.
+
+const (
+	SD_LISTEN_FDS_START = 3
+)
+
+// A socket inherited from the supervisor, with the name it was given.
+// Exactly one of Acceptor and Packet is set.
+type ActivatedSocket struct {
+	Name     string       // from LISTEN_FDNAMES, or "unknown"
+	Acceptor AcceptorI    // a TcpAcceptor or UnixAcceptor
+	Packet   *net.UDPConn // a UDP socket
+}
+
+// Return the files passed by the supervisor, with their names, setting
+// close-on-exec on each so that they are not leaked to our own
+// children.  If unsetEnv is true the LISTEN_ variables are removed from
+// the environment, so that they are not either.  If no sockets were
+// passed, both slices are empty.
+func ListenFiles(unsetEnv bool) (files []*os.File, names []string, err error) {
+	if unsetEnv {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+	}
+	if pid := os.Getenv("LISTEN_PID"); pid != "" {
+		var n int
+		if n, err = strconv.Atoi(pid); err != nil {
+			return nil, nil, NotAListener
+		}
+		if n != os.Getpid() {
+			return
+		}
+	}
+	count := os.Getenv("LISTEN_FDS")
+	if count == "" {
+		return
+	}
+	var n int
+	if n, err = strconv.Atoi(count); err != nil || n < 0 {
+		return nil, nil, NotAListener
+	}
+	var fdNames []string
+	if s := os.Getenv("LISTEN_FDNAMES"); s != "" {
+		fdNames = strings.Split(s, ":")
+	}
+	for i := 0; i < n; i++ {
+		fd := SD_LISTEN_FDS_START + i
+		syscall.CloseOnExec(fd)
+		name := "unknown"
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		files = append(files, os.NewFile(uintptr(fd), name))
+		names = append(names, name)
+	}
+	return
+}
+
+// Build a TcpAcceptor on an inherited listening socket.  The options
+// are applied to each connection accepted; those which must be set
+// before the socket binds are the supervisor's business.  f may be
+// closed once this returns.
+func NewTcpAcceptorFromFile(f *os.File, opts ...*SocketOptions) (
+	*TcpAcceptor, error) {
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, wrapError("listen", "tcp", nil, nil, err)
+	}
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		l.Close()
+		return nil, NotAListener
+	}
+	return newTcpAcceptor(tl, mergeOptions(opts...)), nil
+}
+
+// Build a UnixAcceptor on an inherited listening socket.  Its socket
+// file is left in place when the acceptor is closed.  f may be closed
+// once this returns.
+func NewUnixAcceptorFromFile(f *os.File) (*UnixAcceptor, error) {
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, wrapError("listen", "unix", nil, nil, err)
+	}
+	ul, ok := l.(*net.UnixListener)
+	if !ok {
+		l.Close()
+		return nil, NotAListener
+	}
+	ul.SetUnlinkOnClose(false)
+	return newUnixAcceptor(ul), nil
+}
+
+// Build a UDP socket on an inherited one.  f may be closed once this
+// returns.
+func NewUdpConnFromFile(f *os.File) (*net.UDPConn, error) {
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, NotAListener
+	}
+	uc, ok := pc.(*net.UDPConn)
+	if !ok {
+		pc.Close()
+		return nil, NotAListener
+	}
+	return uc, nil
+}
+
+// Take over every socket passed by the supervisor, building an
+// acceptor for each listening TCP or Unix stream socket and a UDPConn
+// for each UDP socket.  The inherited descriptors themselves are
+// closed.  If any socket is of another kind, all are closed and
+// NotAListener returned.
+func Activate(unsetEnv bool) (socks []*ActivatedSocket, err error) {
+	files, names, err := ListenFiles(unsetEnv)
+	if err != nil {
+		return
+	}
+	for i, f := range files {
+		sock := &ActivatedSocket{Name: names[i]}
+		if err == nil {
+			sock.Acceptor, sock.Packet, err = activate(f)
+			socks = append(socks, sock)
+		}
+		f.Close()
+	}
+	if err != nil {
+		for _, sock := range socks {
+			if sock.Acceptor != nil {
+				sock.Acceptor.Close()
+			} else if sock.Packet != nil {
+				sock.Packet.Close()
+			}
+		}
+		socks = nil
+	}
+	return
+}
+
+func activate(f *os.File) (acc AcceptorI, pc *net.UDPConn, err error) {
+	if l, lErr := net.FileListener(f); lErr == nil {
+		switch l := l.(type) {
+		case *net.TCPListener:
+			acc = newTcpAcceptor(l, nil)
+		case *net.UnixListener:
+			l.SetUnlinkOnClose(false)
+			acc = newUnixAcceptor(l)
+		default:
+			l.Close()
+			err = NotAListener
+		}
+		return
+	}
+	pc, err = NewUdpConnFromFile(f)
+	return
+}
@@ -0,0 +1,135 @@
+//go:build unix
+
+package transport
+
+// xlTransport_go/activation_test.go
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Set in the environment of the child process started by
+// TestSocketActivation.
+const ACTIVATION_CHILD = "XL_TEST_ACTIVATION_CHILD"
+
+// The parent plays supervisor: it opens a TCP listener, a Unix listener
+// and a UDP socket and starts this test binary again as a child, passing
+// the three as inherited file descriptors.  The child, running
+// TestActivationChild, answers on each with the socket's name.
+func (s *XLSuite) TestSocketActivation(c *C) {
+	if os.Getenv(ACTIVATION_CHILD) != "" {
+		return
+	}
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SOCKET_ACTIVATION")
+	}
+	// nothing inherited, nothing activated
+	socks, err := Activate(false)
+	c.Assert(err, IsNil)
+	c.Assert(len(socks), Equals, 0)
+
+	tcpAcc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer tcpAcc.Close()
+	path := filepath.Join(c.MkDir(), "control.sock")
+	unixAcc, err := NewUnixAcceptor(path)
+	c.Assert(err, IsNil)
+	defer unixAcc.Close()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+	defer udpConn.Close()
+
+	tcpFile, err := tcpAcc.File()
+	c.Assert(err, IsNil)
+	unixFile, err := unixAcc.File()
+	c.Assert(err, IsNil)
+	udpFile, err := udpConn.File()
+	c.Assert(err, IsNil)
+
+	child := exec.Command(os.Args[0], "-test.run=^Test$",
+		"-check.f=TestActivationChild$")
+	child.Env = append(os.Environ(), ACTIVATION_CHILD+"=1",
+		"LISTEN_FDS=3", "LISTEN_FDNAMES=web:control:dgram")
+	child.ExtraFiles = []*os.File{tcpFile, unixFile, udpFile}
+	child.Stdout, child.Stderr = os.Stdout, os.Stderr
+	c.Assert(child.Start(), IsNil)
+	tcpFile.Close()
+	unixFile.Close()
+	udpFile.Close()
+
+	// the child, not we, accepts connections on the shared listeners
+	expectName := func(ctor ConnectorI, name string) {
+		cnx, err := ctor.Connect(nil)
+		c.Assert(err, IsNil)
+		defer cnx.Close()
+		buf, err := io.ReadAll(cnx)
+		c.Assert(err, IsNil)
+		c.Assert(string(buf), Equals, name)
+	}
+	tcpCtor, err := NewTcpConnector(tcpAcc.GetEndPoint())
+	c.Assert(err, IsNil)
+	expectName(tcpCtor, "web")
+	unixCtor, err := NewUnixConnector(unixAcc.GetEndPoint())
+	c.Assert(err, IsNil)
+	expectName(unixCtor, "control")
+
+	client, err := net.DialUDP("udp", nil, udpConn.LocalAddr().(*net.UDPAddr))
+	c.Assert(err, IsNil)
+	defer client.Close()
+	_, err = client.Write([]byte("ping"))
+	c.Assert(err, IsNil)
+	client.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 64)
+	n, err := client.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "dgram")
+
+	c.Assert(child.Wait(), IsNil)
+}
+
+// Run only in the child started by TestSocketActivation.
+func (s *XLSuite) TestActivationChild(c *C) {
+	if os.Getenv(ACTIVATION_CHILD) == "" {
+		return
+	}
+	socks, err := Activate(true)
+	c.Assert(err, IsNil)
+	c.Assert(os.Getenv("LISTEN_FDS"), Equals, "")
+	c.Assert(len(socks), Equals, 3)
+	c.Assert(socks[0].Name, Equals, "web")
+	c.Assert(socks[1].Name, Equals, "control")
+	c.Assert(socks[2].Name, Equals, "dgram")
+	_, ok := socks[0].Acceptor.(*TcpAcceptor)
+	c.Assert(ok, Equals, true)
+	_, ok = socks[1].Acceptor.(*UnixAcceptor)
+	c.Assert(ok, Equals, true)
+	c.Assert(socks[2].Packet, NotNil)
+
+	for _, sock := range socks[:2] {
+		cnx, err := sock.Acceptor.Accept()
+		c.Assert(err, IsNil)
+		_, err = cnx.Write([]byte(sock.Name))
+		c.Assert(err, IsNil)
+		cnx.Close()
+		c.Assert(sock.Acceptor.Close(), IsNil)
+	}
+	// the Unix socket file belongs to the supervisor
+	_, err = os.Stat(socks[1].Acceptor.GetEndPoint().Address().String())
+	c.Assert(err, IsNil)
+
+	pc := socks[2].Packet
+	pc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 64)
+	_, from, err := pc.ReadFrom(buf)
+	c.Assert(err, IsNil)
+	_, err = pc.WriteTo([]byte(socks[2].Name), from)
+	c.Assert(err, IsNil)
+	c.Assert(pc.Close(), IsNil)
+}
@@ -0,0 +1,181 @@
+package transport
+
+// xlTransport_go/clock.go
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Everything in this library which depends upon the passage of time
+// asks a ClockI what time it is.  By default this is the RealClock.  In
+// simulation a VirtualClock is used instead: time stands still until
+// the test advances it, so that minutes of timeouts and retries run in
+// milliseconds and always in the same order.
+
+type ClockI interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+
+	// Return a channel on which the time is sent once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+
+	// Call f once d has elapsed.  The RealClock calls f in its own
+	// goroutine; the VirtualClock in the goroutine advancing the clock.
+	AfterFunc(d time.Duration, f func()) TimerI
+}
+
+type TimerI interface {
+	// Prevent the timer from firing, returning false if it has
+	// already fired or been stopped.
+	Stop() bool
+}
+
+// The wall clock, as seen through the time package.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time                         { return time.Now() }
+func (RealClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (RealClock) AfterFunc(d time.Duration, f func()) TimerI {
+	return time.AfterFunc(d, f)
+}
+
+// VIRTUAL CLOCK ////////////////////////////////////////////////////
+
+// A clock which moves only when told to.  Timers fire in order of
+// expiry, ties being broken by order of creation.  Functions passed to
+// AfterFunc run before Advance returns, so their effects are visible to
+// the test as soon as it has advanced the clock; they must not block.
+type VirtualClock struct {
+	mu      sync.Mutex
+	changed *sync.Cond // signalled when a timer is added
+	now     time.Time
+	timers  timerHeap
+	nextSeq uint64
+}
+
+// Create a VirtualClock reading start.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	c := &VirtualClock{now: start}
+	c.changed = sync.NewCond(&c.mu)
+	return c
+}
+
+type virtualTimer struct {
+	clock *VirtualClock
+	at    time.Time
+	seq   uint64
+	fire  func(now time.Time)
+	index int // in the heap; -1 once fired or stopped
+}
+
+func (t *virtualTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&c.timers, t.index)
+	return true
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) addTimer(d time.Duration, fire func(time.Time)) *virtualTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &virtualTimer{clock: c, at: c.now.Add(d), seq: c.nextSeq, fire: fire}
+	c.nextSeq++
+	heap.Push(&c.timers, t)
+	c.changed.Broadcast()
+	return t
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.addTimer(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+// Block until the clock has been advanced by d.
+func (c *VirtualClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) TimerI {
+	return c.addTimer(d, func(time.Time) { f() })
+}
+
+// Move the clock forward by d, firing in order every timer which
+// expires meanwhile.  The clock reads each timer's expiry time while
+// that timer fires.
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	target := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].at.After(target) {
+		t := heap.Pop(&c.timers).(*virtualTimer)
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		now := c.now
+		c.mu.Unlock()
+		t.fire(now)
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+// Return the number of timers waiting to fire.
+func (c *VirtualClock) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// Block until at least n timers are waiting to fire.  Tests use this
+// to be sure that goroutines have gone to sleep before advancing the
+// clock.
+func (c *VirtualClock) WaitForTimers(n int) {
+	c.mu.Lock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+	c.mu.Unlock()
+}
+
+// A min-heap of timers ordered by expiry and then creation.
+type timerHeap []*virtualTimer
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *timerHeap) Push(x interface{}) {
+	t := x.(*virtualTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
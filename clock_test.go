@@ -0,0 +1,182 @@
+package transport
+
+// xlTransport_go/clock_test.go
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+var epoch = time.Date(2014, 5, 14, 0, 0, 0, 0, time.UTC)
+
+func (s *XLSuite) TestVirtualClock(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_VIRTUAL_CLOCK")
+	}
+	clock := NewVirtualClock(epoch)
+	c.Assert(clock.Now().Equal(epoch), Equals, true)
+
+	// timers fire in order of expiry, reading their own expiry times
+	fired := make(chan time.Duration, 3)
+	for _, d := range []time.Duration{3 * time.Second, time.Second, 2 * time.Second} {
+		d := d
+		ch := clock.After(d)
+		go func() {
+			now := <-ch
+			fired <- now.Sub(epoch)
+		}()
+	}
+	stopped := clock.AfterFunc(time.Second, func() { fired <- -1 })
+	c.Assert(stopped.Stop(), Equals, true)
+	c.Assert(stopped.Stop(), Equals, false)
+	c.Assert(clock.Pending(), Equals, 3)
+
+	clock.Advance(1500 * time.Millisecond)
+	c.Assert(<-fired, Equals, time.Second)
+	c.Assert(clock.Now().Sub(epoch), Equals, 1500*time.Millisecond)
+	clock.Advance(time.Hour)
+	first, second := <-fired, <-fired
+	c.Assert(first+second, Equals, 5*time.Second)
+	c.Assert(clock.Pending(), Equals, 0)
+
+	// Sleep returns only once the clock has been advanced
+	woke := make(chan bool)
+	go func() {
+		clock.Sleep(time.Minute)
+		woke <- true
+	}()
+	clock.WaitForTimers(1)
+	select {
+	case <-woke:
+		c.Fatal("Sleep returned before the clock was advanced")
+	default:
+	}
+	clock.Advance(time.Minute)
+	c.Assert(<-woke, Equals, true)
+}
+
+// Minutes of simulated latency and timeouts on a MockNetwork take next
+// to no real time.
+func (s *XLSuite) TestSimulatedNetwork(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SIMULATED_NETWORK")
+	}
+	start := time.Now()
+	clock := NewVirtualClock(epoch)
+	net := NewMockNetwork()
+	net.SetClock(clock)
+	net.SetLatency(30 * time.Second)
+
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	ctor, err := net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+
+	// a ping takes 30s to arrive and the pong another 30s to return
+	client, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	go func() {
+		buf := make([]byte, 4)
+		server.Read(buf)
+		server.Write([]byte("pong"))
+	}()
+	_, err = client.Write([]byte("ping"))
+	c.Assert(err, IsNil)
+	clock.WaitForTimers(1)
+	clock.Advance(30 * time.Second)
+	received := make(chan string)
+	go func() {
+		buf := make([]byte, 4)
+		client.Read(buf)
+		received <- string(buf)
+	}()
+	clock.WaitForTimers(1)
+	clock.Advance(30 * time.Second)
+	c.Assert(<-received, Equals, "pong")
+	c.Assert(clock.Now().Sub(epoch), Equals, time.Minute)
+
+	// a peer which never answers is given up on after two minutes
+	silent, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	silent.(*MockConnection).SetReadTimeout(2 * time.Minute)
+	readErr := make(chan error)
+	go func() {
+		_, err := silent.Read(make([]byte, 4))
+		readErr <- err
+	}()
+	clock.WaitForTimers(1)
+	clock.Advance(119 * time.Second)
+	select {
+	case <-readErr:
+		c.Fatal("read timed out early")
+	default:
+	}
+	clock.Advance(time.Second)
+	c.Assert(<-readErr, Equals, ReadTimeout)
+
+	// three simulated minutes in well under a real second
+	c.Assert(time.Since(start) < time.Second, Equals, true)
+}
+
+func (s *XLSuite) TestSimulatedRekeyAndPartition(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SIMULATED_REKEY_AND_PARTITION")
+	}
+	clock := NewVirtualClock(epoch)
+	net := NewMockNetwork()
+	net.SetClock(clock)
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	mockCtor, err := net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	f := NewFaultInjector(42, nil)
+	f.SetClock(clock)
+	ctor := f.WrapConnector(mockCtor)
+
+	cnx, err := ctor.Connect(NewMockEndPoint("T", "client"))
+	c.Assert(err, IsNil)
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	secret := []byte("shared secret")
+	client, err := NewSecureConnection(cnx, secret, true,
+		&RekeyPolicy{MaxInterval: time.Hour})
+	c.Assert(err, IsNil)
+	client.SetClock(clock)
+	secureServer, err := NewSecureConnection(server, secret, false, nil)
+	c.Assert(err, IsNil)
+	go echo(secureServer)
+
+	roundTrip := func(msg string) {
+		_, err := client.Write([]byte(msg))
+		c.Assert(err, IsNil)
+		buf := make([]byte, len(msg))
+		_, err = client.Read(buf)
+		c.Assert(err, IsNil)
+		c.Assert(string(buf), Equals, msg)
+	}
+
+	// the send key is replaced once an hour of simulated time passes
+	roundTrip("first")
+	clock.Advance(59 * time.Minute)
+	roundTrip("second")
+	sent, _ := client.Rekeys()
+	c.Assert(sent, Equals, 0)
+	clock.Advance(time.Minute)
+	roundTrip("third")
+	sent, _ = client.Rekeys()
+	c.Assert(sent, Equals, 1)
+
+	// a partition scheduled for a simulated day lasts exactly that long
+	f.SchedulePartition("client", "server", time.Hour, 24*time.Hour)
+	clock.Advance(time.Hour)
+	c.Assert(f.IsPartitioned("client", "server"), Equals, true)
+	clock.Advance(24*time.Hour - time.Second)
+	c.Assert(f.IsPartitioned("client", "server"), Equals, true)
+	clock.Advance(time.Second)
+	c.Assert(f.IsPartitioned("client", "server"), Equals, false)
+}
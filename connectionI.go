@@ -98,8 +98,20 @@ type ConnectionI interface {
 
 	GetFarEnd() EndPointI
 
+	//
+	// Read from and write to the connection as a byte stream, with
+	// the semantics of io.Reader and io.Writer.
+	//
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+
 	IsBlocking() bool
 
+	//
+	// Return a snapshot of traffic over the connection so far.
+	//
+	Stats() ConnStats
+
 	// ///////////////////////////////////////////////////////////////////
 	// XXX CONFUSION BETWEEN PACKET vs STREAM
 	// ///////////////////////////////////////////////////////////////////
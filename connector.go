@@ -7,7 +7,14 @@ import (
 )
 
 // Parse a serialized connector such as "TcpConnector: 127.0.0.1:80",
-// returning a pointer to the reconstructed connector"
+// returning a pointer to the reconstructed connector".  A TcpConnector
+// may carry socket options in query form, as in
+// "TcpConnector: 127.0.0.1:80?nodelay=0".  A UnixConnector is
+// serialized with the path of its socket, "UnixConnector: /run/node.sock",
+// a WsConnector with its URL, "WsConnector: wss://example.com:443/xl",
+// a RelayConnector with the relay and node ID it reaches,
+// "RelayConnector: 127.0.0.1:9000/<hex node ID>", and a RudpConnector
+// with the UDP address of its acceptor, "RudpConnector: 127.0.0.1:9000".
 
 func ParseConnector(str string) (ctor ConnectorI, err error) {
 	parts := strings.Split(str, ": ")
@@ -17,9 +24,33 @@ func ParseConnector(str string) (ctor ConnectorI, err error) {
 		if parts[0] == "TcpConnector" {
 			addr := strings.TrimSpace(parts[1])
 			var ep *TcpEndPoint
-			ep, err = NewTcpEndPoint(addr)
+			var opts *SocketOptions
+			addr, opts, err = splitOptions(addr)
 			if err == nil {
-				ctor, err = NewTcpConnector(ep)
+				ep, err = NewTcpEndPoint(addr)
+			}
+			if err == nil {
+				ctor, err = NewTcpConnector(ep, opts)
+			}
+		} else if parts[0] == "UnixConnector" {
+			var ep *UnixEndPoint
+			if ep, err = NewUnixEndPoint(strings.TrimSpace(parts[1])); err == nil {
+				ctor, err = NewUnixConnector(ep)
+			}
+		} else if parts[0] == "RelayConnector" {
+			var ep *RelayEndPoint
+			if ep, err = parseRelayEndPoint(strings.TrimSpace(parts[1])); err == nil {
+				ctor, err = NewRelayConnector(ep)
+			}
+		} else if parts[0] == "RudpConnector" {
+			var ep *UdpEndPoint
+			if ep, err = NewUdpEndPoint(strings.TrimSpace(parts[1])); err == nil {
+				ctor, err = NewRudpConnector(ep)
+			}
+		} else if parts[0] == "WsConnector" {
+			var ep *WsEndPoint
+			if ep, err = NewWsEndPoint(strings.TrimSpace(parts[1])); err == nil {
+				ctor, err = NewWsConnector(ep, nil)
 			}
 		} else {
 			err = NotAKnownConnector
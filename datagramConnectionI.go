@@ -0,0 +1,48 @@
+package transport
+
+// xlTransport_go/datagramConnectionI.go
+
+//
+// A DatagramConnection carries messages rather than a byte stream:
+// each message sent arrives, if it arrives at all, as the same message,
+// never split or run together with another.  Whether messages may be
+// lost, duplicated or reordered depends on the transport underneath.
+// A UdpConnection is one natively; any other connection can be made
+// one by framing its byte stream, as NewDatagramConnection does.
+//
+// The connection remains a ConnectionI, but it should be used through
+// one interface or the other, not both.
+//
+
+type DatagramConnectionI interface {
+	ConnectionI
+
+	//
+	// Send msg as a single message.  A message longer than
+	// MaxMsgSize() is refused.
+	//
+	SendMsg(msg []byte) error
+
+	//
+	// Return the next message from the far end, blocking until one
+	// arrives.
+	//
+	RecvMsg() ([]byte, error)
+
+	//
+	// Return the length of the longest message that can be sent.
+	//
+	MaxMsgSize() int
+}
+
+// Return cnx as a DatagramConnectionI, framing its byte stream if it
+// is not one already.  The far end must do the same.
+func NewDatagramConnection(cnx ConnectionI) (DatagramConnectionI, error) {
+	if cnx == nil {
+		return nil, NilConnection
+	}
+	if dc, ok := cnx.(DatagramConnectionI); ok {
+		return dc, nil
+	}
+	return NewFramedConnection(cnx, nil)
+}
@@ -0,0 +1,86 @@
+package transport
+
+// xlTransport_go/datagram_connection_test.go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"net"
+)
+
+// Send messages of various sizes from a to b, back to back, and check
+// that each arrives whole and on its own.
+func (s *XLSuite) checkMessages(c *C, rng *xr.SimpleRNG,
+	a, b DatagramConnectionI) {
+
+	sizes := []int{0, 1, 100, 4000, a.MaxMsgSize()}
+	msgs := make([][]byte, len(sizes))
+	for i, n := range sizes {
+		msgs[i] = make([]byte, n)
+		rng.NextBytes(msgs[i])
+	}
+	go func() {
+		for _, msg := range msgs {
+			if err := a.SendMsg(msg); err != nil {
+				return
+			}
+		}
+	}()
+	for _, msg := range msgs {
+		got, err := b.RecvMsg()
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(got, msg), Equals, true)
+	}
+}
+
+func (s *XLSuite) TestDatagramConnection(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_DATAGRAM_CONNECTION")
+	}
+	rng := xr.MakeSimpleRNG()
+	_, err := NewDatagramConnection(nil)
+	c.Assert(err, Equals, NilConnection)
+
+	// UDP carries messages natively
+	pcA, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	pcB, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	epA, _ := NewUdpEndPoint(pcA.LocalAddr().String())
+	epB, _ := NewUdpEndPoint(pcB.LocalAddr().String())
+	udpA, err := NewUdpConnection(pcA, epB)
+	c.Assert(err, IsNil)
+	defer udpA.Close()
+	udpB, err := NewUdpConnection(pcB, epA)
+	c.Assert(err, IsNil)
+	defer udpB.Close()
+	dA, err := NewDatagramConnection(udpA)
+	c.Assert(err, IsNil)
+	c.Assert(dA, Equals, DatagramConnectionI(udpA))
+	c.Assert(dA.MaxMsgSize(), Equals, UDP_MAX_DATAGRAM)
+	s.checkMessages(c, rng, udpA, udpB)
+	err = dA.SendMsg(make([]byte, UDP_MAX_DATAGRAM+1))
+	c.Assert(errors.Is(err, DatagramTooLong), Equals, true)
+
+	// a byte stream carries them framed
+	client, server := s.makeTcpPair(c)
+	dClient, err := NewDatagramConnection(client)
+	c.Assert(err, IsNil)
+	defer dClient.Close()
+	dServer, err := NewDatagramConnection(server)
+	c.Assert(err, IsNil)
+	defer dServer.Close()
+	_, ok := dClient.(*FramedConnection)
+	c.Assert(ok, Equals, true)
+	c.Assert(dClient.MaxMsgSize(), Equals, MAX_FRAMED_MSG)
+	again, err := NewDatagramConnection(dClient)
+	c.Assert(err, IsNil)
+	c.Assert(again, Equals, dClient)
+	s.checkMessages(c, rng, dClient, dServer)
+	s.checkMessages(c, rng, dServer, dClient)
+	err = dClient.SendMsg(make([]byte, MAX_FRAMED_MSG+1))
+	c.Assert(err, Equals, FrameTooLong)
+}
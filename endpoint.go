@@ -17,6 +17,16 @@ func ParseEndPoint(str string) (ep EndPointI, err error) {
 		if parts[0] == "TcpEndPoint" {
 			addr := strings.TrimSpace(parts[1])
 			ep, err = NewTcpEndPoint(addr)
+		} else if parts[0] == "HostEndPoint" {
+			ep, err = NewHostEndPoint(strings.TrimSpace(parts[1]))
+		} else if parts[0] == "RelayEndPoint" {
+			ep, err = parseRelayEndPoint(strings.TrimSpace(parts[1]))
+		} else if parts[0] == "UdpEndPoint" {
+			ep, err = NewUdpEndPoint(strings.TrimSpace(parts[1]))
+		} else if parts[0] == "UnixEndPoint" {
+			ep, err = NewUnixEndPoint(strings.TrimSpace(parts[1]))
+		} else if parts[0] == "WsEndPoint" {
+			ep, err = NewWsEndPoint(strings.TrimSpace(parts[1]))
 		} else {
 			err = NotAKnownEndPoint
 		}
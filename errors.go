@@ -8,18 +8,65 @@ import (
 
 // Go won't accept these as constants
 var (
-	AlreadyBound       = errors.New("cnx has already been bound")
-	AlreadyConnected   = errors.New("cnx has already been connected")
-	EmptyAddrString    = errors.New("address string is empty")
-	NotAConnector      = errors.New("Not a connector")
-	NotAKnownConnector = errors.New("Not a known connector type")
-	NotAKnownEndPoint  = errors.New("Not a known endPoint type")
-	NilConnection      = errors.New("nil connection")
-	NilEndPoint        = errors.New("nil endpoint argument")
-	NotBound           = errors.New("connection has not been bound")
-	NotAMockEndPoint   = errors.New("Not a mock endPoint")
-	NotAnEndPoint      = errors.New("Not an endPoint")
-	NotImplemented     = errors.New("not implemented")
-	NotMockEndPoint    = errors.New("not a Mock endpoint")
-	NotTcpEndPoint     = errors.New("not a Tcp endpoint")
+	AcceptorClosed          = errors.New("acceptor has been closed")
+	AddressInUse            = errors.New("address already in use")
+	AlreadyBound            = errors.New("cnx has already been bound")
+	AlreadyConnected        = errors.New("cnx has already been connected")
+	BadHelloMsg             = errors.New("malformed hello message")
+	BadHelloSig             = errors.New("peer's hello signature does not verify")
+	BadPreamble             = errors.New("malformed preamble")
+	BadProxyHeader          = errors.New("missing or malformed PROXY protocol header")
+	BadRecord               = errors.New("record fails to decrypt or is malformed")
+	BadRendezvousSession    = errors.New("malformed rendezvous session name")
+	BadSocketOption         = errors.New("malformed socket option")
+	BadTranscript           = errors.New("malformed transcript")
+	BadVersion              = errors.New("malformed version string")
+	BadWsFrame              = errors.New("malformed WebSocket frame")
+	BadWsHandshake          = errors.New("malformed or refused WebSocket handshake")
+	CannotHandOff           = errors.New("acceptor or connection cannot be handed off")
+	ClosedConnection        = errors.New("connection has been closed")
+	ConnectionRefused       = errors.New("connection refused")
+	ConnectionReset         = errors.New("connection reset")
+	ConnectTimeout          = errors.New("connect timed out")
+	DatagramTooLong         = errors.New("datagram exceeds maximum size")
+	EmptyAddrString         = errors.New("address string is empty")
+	FrameTooLong            = errors.New("frame exceeds maximum length")
+	HelloTimeout            = errors.New("hello exchange timed out")
+	IncompatibleVersion     = errors.New("incompatible protocol versions")
+	MissingCapabilities     = errors.New("required capabilities not supported")
+	NotAConnector           = errors.New("Not a connector")
+	NotAKnownConnector      = errors.New("Not a known connector type")
+	NotAKnownEndPoint       = errors.New("Not a known endPoint type")
+	NotAListener            = errors.New("file is not a listening or datagram socket")
+	NilAcceptor             = errors.New("nil acceptor")
+	NilConnection           = errors.New("nil connection")
+	NilConnector            = errors.New("nil connector")
+	NilEndPoint             = errors.New("nil endpoint argument")
+	NilKey                  = errors.New("nil key argument")
+	NilNodeID               = errors.New("nil or empty node ID")
+	NilSecret               = errors.New("nil or empty secret")
+	NilTlsConfig            = errors.New("nil TLS config")
+	NotBound                = errors.New("connection has not been bound")
+	NotAMockEndPoint        = errors.New("Not a mock endPoint")
+	NotAnEndPoint           = errors.New("Not an endPoint")
+	NotImplemented          = errors.New("not implemented")
+	NotMockEndPoint         = errors.New("not a Mock endpoint")
+	NotRelayEndPoint        = errors.New("not a relay endpoint")
+	NotTcpEndPoint          = errors.New("not a Tcp endpoint")
+	NotUdpEndPoint          = errors.New("not a UDP endpoint")
+	NotUnixEndPoint         = errors.New("not a Unix endpoint")
+	NotWsEndPoint           = errors.New("not a WebSocket endpoint")
+	PeerDead                = errors.New("peer failed to answer heartbeat")
+	PreambleTimeout         = errors.New("preamble exchange timed out")
+	ProxyAuthRequired       = errors.New("proxy requires authentication")
+	ReadTimeout             = errors.New("read timed out")
+	ReflectedHello          = errors.New("peer's hello reflects our own")
+	RelayRefused            = errors.New("relay refused the request")
+	RetransmitLimit         = errors.New("too many retransmissions")
+	SocksAuthRejected       = errors.New("SOCKS proxy rejected authentication")
+	TranscriptMismatch      = errors.New("traffic departs from transcript")
+	UnexpectedPeerID        = errors.New("peer's node ID is not the one expected")
+	UnexpectedPeerKey       = errors.New("peer's public key is not the one expected")
+	UnsupportedKey          = errors.New("key is not an RSA key")
+	UnsupportedSocketOption = errors.New("socket option not supported on this platform")
 )
@@ -0,0 +1,370 @@
+package transport
+
+// xlTransport_go/fault.go
+
+import (
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A FaultInjector wraps connections and connectors so that they behave
+// as they might on a bad network.  All random choices are made by a
+// single seeded RNG, so that a failing test can be rerun with the same
+// faults.  Endpoints are named by their address strings, and scripted
+// partitions cut traffic between pairs of names.
+//
+// Faults on a connection are applied on the way out: a Write is
+// delayed, throttled and possibly corrupted before it reaches the
+// underlying connection.  Reads are subject only to short reads,
+// resets and partitions.  While two ends are partitioned, Reads and
+// Writes between them block until the partition heals, as on a stream
+// whose packets are being retransmitted: nothing is lost.
+//
+// Datagram sockets may be wrapped too.  A datagram sent may be lost,
+// and is otherwise delayed by the latency and jitter, without blocking
+// the sender, so that datagrams can arrive out of order.
+
+// What can go wrong.  Rates are probabilities between 0 and 1; a zero
+// value disables the fault.
+type FaultConfig struct {
+	Latency   time.Duration // added to every write
+	Jitter    time.Duration // latency varies by up to this much either way
+	Bandwidth int           // bytes per second; 0 means unlimited
+
+	CorruptRate    float64 // per byte, chance of a flipped bit
+	ShortReadRate  float64 // per read, chance of returning fewer bytes
+	ShortWriteRate float64 // per write, chance of writing only a prefix
+	ResetRate      float64 // per read or write, chance of an abrupt reset
+
+	DropConnectRate float64       // per connect, chance it times out
+	ConnectDelay    time.Duration // added to every connect
+
+	DropRate float64 // per datagram, chance it is lost
+}
+
+// How often a blocked Read or Write checks whether a partition has healed.
+const PARTITION_POLL = 5 * time.Millisecond
+
+type FaultInjector struct {
+	mu         sync.Mutex
+	rng        *xr.SimpleRNG
+	cfg        FaultConfig
+	partitions map[string]bool // keyed by partitionKey
+	clock      ClockI
+}
+
+// Create a FaultInjector whose random choices are determined by seed.
+// If cfg is nil no faults are injected until partitions are set up.
+func NewFaultInjector(seed int64, cfg *FaultConfig) *FaultInjector {
+	f := &FaultInjector{
+		rng:        xr.NewSimpleRNG(seed),
+		partitions: make(map[string]bool),
+		clock:      RealClock{},
+	}
+	if cfg != nil {
+		f.cfg = *cfg
+	}
+	return f
+}
+
+// Return a copy of the current configuration.
+func (f *FaultInjector) GetConfig() FaultConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cfg
+}
+
+// Change the faults injected from now on.
+func (f *FaultInjector) SetConfig(cfg FaultConfig) {
+	f.mu.Lock()
+	f.cfg = cfg
+	f.mu.Unlock()
+}
+
+// Set the clock by which delays and scheduled partitions are timed.
+func (f *FaultInjector) SetClock(clock ClockI) {
+	f.mu.Lock()
+	f.clock = clock
+	f.mu.Unlock()
+}
+
+func (f *FaultInjector) getClock() ClockI {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.clock
+}
+
+// The key is the same whichever way round the names are given.
+func partitionKey(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "|" + b
+}
+
+// Cut all traffic between the endpoints named a and b.
+func (f *FaultInjector) Partition(a, b string) {
+	f.mu.Lock()
+	f.partitions[partitionKey(a, b)] = true
+	f.mu.Unlock()
+}
+
+// Restore traffic between the endpoints named a and b.
+func (f *FaultInjector) Heal(a, b string) {
+	f.mu.Lock()
+	delete(f.partitions, partitionKey(a, b))
+	f.mu.Unlock()
+}
+
+// Restore all traffic.
+func (f *FaultInjector) HealAll() {
+	f.mu.Lock()
+	f.partitions = make(map[string]bool)
+	f.mu.Unlock()
+}
+
+// Partition a and b after delay, healing the partition after a further
+// duration.  A zero duration leaves the partition in place.
+func (f *FaultInjector) SchedulePartition(a, b string,
+	delay, duration time.Duration) {
+
+	clock := f.getClock()
+	clock.AfterFunc(delay, func() {
+		f.Partition(a, b)
+		if duration > 0 {
+			clock.AfterFunc(duration, func() { f.Heal(a, b) })
+		}
+	})
+}
+
+func (f *FaultInjector) IsPartitioned(a, b string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.partitions[partitionKey(a, b)]
+}
+
+func (f *FaultInjector) isPartitioned(near, far EndPointI) bool {
+	if near == nil || far == nil {
+		return false
+	}
+	return f.IsPartitioned(hostPort(near), hostPort(far))
+}
+
+// Whether an event of probability p happens.  The caller holds f.mu.
+func (f *FaultInjector) chance(p float64) bool {
+	const scale = 1 << 30
+	return p > 0 && float64(f.rng.Intn(scale)) < p*scale
+}
+
+// Return a delay of base varied by up to jitter either way.  The
+// caller holds f.mu.
+func (f *FaultInjector) jittered(base, jitter time.Duration) (d time.Duration) {
+	d = base
+	if jitter > 0 {
+		d += time.Duration(f.rng.Intn(int(2*jitter+1))) - jitter
+	}
+	if d < 0 {
+		d = 0
+	}
+	return
+}
+
+// Wrap a connection so that faults are injected into its traffic.
+func (f *FaultInjector) WrapConnection(cnx ConnectionI) *FaultyConnection {
+	return &FaultyConnection{
+		ConnectionI: cnx,
+		injector:    f,
+		closed:      make(chan struct{}),
+	}
+}
+
+// Wrap a connector so that faults are injected into its connects and
+// into the connections it makes.
+func (f *FaultInjector) WrapConnector(ctor ConnectorI) *FaultyConnector {
+	return &FaultyConnector{ConnectorI: ctor, injector: f}
+}
+
+// Wrap a datagram socket so that faults are injected into the
+// datagrams sent from it.
+func (f *FaultInjector) WrapPacketConn(pc net.PacketConn) *FaultyPacketConn {
+	return &FaultyPacketConn{PacketConn: pc, injector: f}
+}
+
+// FAULTY CONNECTION ////////////////////////////////////////////////
+
+type FaultyConnection struct {
+	ConnectionI
+	injector  *FaultInjector
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Block while the two ends are partitioned, returning false if the
+// connection is closed meanwhile.
+func (c *FaultyConnection) awaitHealing() bool {
+	for c.injector.isPartitioned(c.GetNearEnd(), c.GetFarEnd()) {
+		select {
+		case <-c.closed:
+			return false
+		case <-c.injector.getClock().After(PARTITION_POLL):
+		}
+	}
+	return true
+}
+
+// Abruptly close the underlying connection.
+func (c *FaultyConnection) reset() error {
+	c.Close()
+	return ConnectionReset
+}
+
+func (c *FaultyConnection) Read(b []byte) (count int, err error) {
+	if !c.awaitHealing() {
+		return 0, ClosedConnection
+	}
+	f := c.injector
+	f.mu.Lock()
+	doReset := f.chance(f.cfg.ResetRate)
+	if !doReset && len(b) > 1 && f.chance(f.cfg.ShortReadRate) {
+		b = b[:1+f.rng.Intn(len(b)-1)]
+	}
+	f.mu.Unlock()
+	if doReset {
+		return 0, c.reset()
+	}
+	return c.ConnectionI.Read(b)
+}
+
+func (c *FaultyConnection) Write(b []byte) (count int, err error) {
+	f := c.injector
+	f.mu.Lock()
+	cfg, clock := f.cfg, f.clock
+	doReset := f.chance(cfg.ResetRate)
+	delay := f.jittered(cfg.Latency, cfg.Jitter)
+	out := b
+	if !doReset {
+		if len(b) > 1 && f.chance(cfg.ShortWriteRate) {
+			out = b[:1+f.rng.Intn(len(b)-1)]
+		}
+		if cfg.CorruptRate > 0 {
+			corrupt := make([]byte, len(out))
+			copy(corrupt, out)
+			for i := range corrupt {
+				if f.chance(cfg.CorruptRate) {
+					corrupt[i] ^= 1 << uint(f.rng.Intn(8))
+				}
+			}
+			out = corrupt
+		}
+	}
+	f.mu.Unlock()
+
+	if doReset {
+		return 0, c.reset()
+	}
+	if cfg.Bandwidth > 0 {
+		delay += time.Duration(len(out)) * time.Second /
+			time.Duration(cfg.Bandwidth)
+	}
+	if delay > 0 {
+		clock.Sleep(delay)
+	}
+	if !c.awaitHealing() {
+		return 0, ClosedConnection
+	}
+	count, err = c.ConnectionI.Write(out)
+	if err == nil && count < len(b) {
+		err = io.ErrShortWrite
+	}
+	return
+}
+
+func (c *FaultyConnection) Close() (err error) {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.ConnectionI.Close()
+	})
+	return
+}
+
+func (c *FaultyConnection) String() string {
+	return fmt.Sprintf("Faulty: %s", c.ConnectionI.String())
+}
+
+// FAULTY CONNECTOR /////////////////////////////////////////////////
+
+type FaultyConnector struct {
+	ConnectorI
+	injector *FaultInjector
+}
+
+// Connect after any configured delay.  The connect may be dropped, or
+// may fail because the two ends are partitioned; either way it fails
+// with ConnectTimeout.
+func (c *FaultyConnector) Connect(nearEnd EndPointI) (
+	cnx ConnectionI, err error) {
+
+	f := c.injector
+	f.mu.Lock()
+	delay := f.jittered(f.cfg.ConnectDelay, f.cfg.Jitter)
+	drop := f.chance(f.cfg.DropConnectRate)
+	clock := f.clock
+	f.mu.Unlock()
+
+	if delay > 0 {
+		clock.Sleep(delay)
+	}
+	if drop || f.isPartitioned(nearEnd, c.GetFarEnd()) {
+		return nil, ConnectTimeout
+	}
+	if cnx, err = c.ConnectorI.Connect(nearEnd); err == nil {
+		if f.isPartitioned(cnx.GetNearEnd(), c.GetFarEnd()) {
+			cnx.Close()
+			cnx, err = nil, ConnectTimeout
+		} else {
+			cnx = f.WrapConnection(cnx)
+		}
+	}
+	return
+}
+
+func (c *FaultyConnector) String() string {
+	return fmt.Sprintf("Faulty: %s", c.ConnectorI.String())
+}
+
+// FAULTY PACKET CONN ///////////////////////////////////////////////
+
+type FaultyPacketConn struct {
+	net.PacketConn
+	injector *FaultInjector
+}
+
+// Send b to addr unless it is lost on the way, after any configured
+// delay.  Either way the send appears to succeed at once.
+func (c *FaultyPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	f := c.injector
+	f.mu.Lock()
+	drop := f.chance(f.cfg.DropRate)
+	delay := f.jittered(f.cfg.Latency, f.cfg.Jitter)
+	clock := f.clock
+	f.mu.Unlock()
+
+	if drop || f.IsPartitioned(c.LocalAddr().String(), addr.String()) {
+		return len(b), nil
+	}
+	if delay == 0 {
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	out := make([]byte, len(b))
+	copy(out, b)
+	clock.AfterFunc(delay, func() { c.PacketConn.WriteTo(out, addr) })
+	return len(b), nil
+}
+
+func (c *FaultyPacketConn) String() string {
+	return fmt.Sprintf("Faulty: %s", c.LocalAddr().String())
+}
@@ -0,0 +1,249 @@
+package transport
+
+// xlTransport_go/fault_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"time"
+)
+
+// Connect through a FaultyConnector over a fresh MockNetwork, returning
+// the faulty client end and the plain server end.
+func (s *XLSuite) makeFaultyPair(c *C, f *FaultInjector) (
+	client ConnectionI, server ConnectionI) {
+
+	net := NewMockNetwork()
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	mockCtor, err := net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	ctor := f.WrapConnector(mockCtor)
+	client, err = ctor.Connect(NewMockEndPoint("T", "client"))
+	c.Assert(err, IsNil)
+	server, err = acc.Accept()
+	c.Assert(err, IsNil)
+	return
+}
+
+func (s *XLSuite) TestFaultCorruptionIsReproducible(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FAULT_CORRUPTION_IS_REPRODUCIBLE")
+	}
+	rng := xr.MakeSimpleRNG()
+	msg := make([]byte, 1024)
+	rng.NextBytes(msg)
+	seed := int64(rng.Intn(1 << 30))
+	cfg := &FaultConfig{CorruptRate: 0.05}
+
+	received := make([][]byte, 2)
+	for i := 0; i < 2; i++ {
+		client, server := s.makeFaultyPair(c, NewFaultInjector(seed, cfg))
+		_, err := client.Write(msg)
+		c.Assert(err, IsNil)
+		received[i] = make([]byte, len(msg))
+		_, err = io.ReadFull(server, received[i])
+		c.Assert(err, IsNil)
+	}
+	// the message was damaged, the same way both times
+	c.Assert(bytes.Equal(msg, received[0]), Equals, false)
+	c.Assert(bytes.Equal(received[0], received[1]), Equals, true)
+}
+
+func (s *XLSuite) TestFaultShortReadsAndWrites(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FAULT_SHORT_READS_AND_WRITES")
+	}
+	f := NewFaultInjector(42, &FaultConfig{ShortWriteRate: 1.0})
+	client, server := s.makeFaultyPair(c, f)
+	msg := make([]byte, 64)
+	count, err := client.Write(msg)
+	c.Assert(err, Equals, io.ErrShortWrite)
+	c.Assert(count < len(msg), Equals, true)
+
+	f.SetConfig(FaultConfig{ShortReadRate: 1.0})
+	server.Write(msg)
+	count, err = client.Read(make([]byte, len(msg)))
+	c.Assert(err, IsNil)
+	c.Assert(count < len(msg), Equals, true)
+}
+
+func (s *XLSuite) TestFaultLatencyAndBandwidth(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FAULT_LATENCY_AND_BANDWIDTH")
+	}
+	f := NewFaultInjector(42, &FaultConfig{
+		Latency: 20 * time.Millisecond, Bandwidth: 10 * 1024})
+	client, _ := s.makeFaultyPair(c, f)
+
+	// 20ms latency plus 1 KB at 10 KB/s
+	start := time.Now()
+	_, err := client.Write(make([]byte, 1024))
+	c.Assert(err, IsNil)
+	c.Assert(time.Since(start) >= 120*time.Millisecond, Equals, true)
+}
+
+func (s *XLSuite) TestFaultReset(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FAULT_RESET")
+	}
+	f := NewFaultInjector(42, nil)
+	client, server := s.makeFaultyPair(c, f)
+	_, err := client.Write([]byte("hello"))
+	c.Assert(err, IsNil)
+
+	f.SetConfig(FaultConfig{ResetRate: 1.0})
+	_, err = client.Write([]byte("world"))
+	c.Assert(err, Equals, ConnectionReset)
+
+	// the far end gets what was sent before the reset and then EOF
+	buf := make([]byte, 16)
+	count, err := server.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:count]), Equals, "hello")
+	_, err = server.Read(buf)
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *XLSuite) TestFaultDroppedConnects(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FAULT_DROPPED_CONNECTS")
+	}
+	net := NewMockNetwork()
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	mockCtor, err := net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+
+	f := NewFaultInjector(42, &FaultConfig{
+		DropConnectRate: 1.0, ConnectDelay: 10 * time.Millisecond})
+	ctor := f.WrapConnector(mockCtor)
+	start := time.Now()
+	_, err = ctor.Connect(nil)
+	c.Assert(err, Equals, ConnectTimeout)
+	c.Assert(time.Since(start) >= 10*time.Millisecond, Equals, true)
+
+	f.SetConfig(FaultConfig{DropConnectRate: 0.5})
+	dropped := 0
+	for i := 0; i < 10; i++ {
+		if cnx, err := ctor.Connect(nil); err == nil {
+			cnx.Close()
+		} else {
+			dropped++
+		}
+	}
+	c.Assert(dropped > 0 && dropped < 10, Equals, true)
+}
+
+func (s *XLSuite) TestFaultPartition(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FAULT_PARTITION")
+	}
+	f := NewFaultInjector(42, nil)
+	client, server := s.makeFaultyPair(c, f)
+
+	// while partitioned, reads and writes block; nothing is lost
+	f.Partition("server", "client")
+	c.Assert(f.IsPartitioned("client", "server"), Equals, true)
+	written := make(chan time.Time, 1)
+	go func() {
+		client.Write([]byte("held"))
+		written <- time.Now()
+	}()
+
+	f.SchedulePartition("client", "other", 0, 0) // unrelated
+	var healed time.Time
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.Write([]byte("after"))
+		healed = time.Now()
+		f.Heal("client", "server")
+	}()
+	buf := make([]byte, 16)
+	count, err := client.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:count]), Equals, "after")
+	c.Assert((<-written).After(healed), Equals, true)
+	count, err = io.ReadFull(server, buf[:4])
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:count]), Equals, "held")
+
+	// a write blocked by a partition fails when the connection closes
+	f.Partition("server", "client")
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Close()
+	}()
+	_, err = client.Write([]byte("never"))
+	c.Assert(err, Equals, ClosedConnection)
+	f.HealAll()
+
+	// connects across a partition time out
+	net := NewMockNetwork()
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	mockCtor, err := net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	ctor := f.WrapConnector(mockCtor)
+	f.SchedulePartition("client", "server", 0, 30*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	_, err = ctor.Connect(NewMockEndPoint("T", "client"))
+	c.Assert(err, Equals, ConnectTimeout)
+	time.Sleep(40 * time.Millisecond)
+	cnx, err := ctor.Connect(NewMockEndPoint("T", "client"))
+	c.Assert(err, IsNil)
+	cnx.Close()
+
+	// IPv6 end points are named by host and port
+	near6, err := NewTcpEndPoint("[::1]:1")
+	c.Assert(err, IsNil)
+	far6, err := NewTcpEndPoint("[::1]:2")
+	c.Assert(err, IsNil)
+	c.Assert(f.isPartitioned(near6, far6), Equals, false)
+	f.Partition("[::1]:1", "[::1]:2")
+	c.Assert(f.isPartitioned(near6, far6), Equals, true)
+}
+
+func (s *XLSuite) TestFaultPacketConn(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FAULT_PACKET_CONN")
+	}
+	recv, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer recv.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	f := NewFaultInjector(42, &FaultConfig{DropRate: 1})
+	send := f.WrapPacketConn(pc)
+	defer send.Close()
+	from, to := send.LocalAddr().String(), recv.LocalAddr()
+
+	// nothing gets through while everything is lost or partitioned,
+	// yet every send succeeds
+	_, err = send.WriteTo([]byte("dropped"), to)
+	c.Assert(err, IsNil)
+	f.SetConfig(FaultConfig{Latency: 10 * time.Millisecond})
+	f.Partition(from, to.String())
+	_, err = send.WriteTo([]byte("partitioned"), to)
+	c.Assert(err, IsNil)
+	f.HealAll()
+
+	// a delayed datagram arrives after the send returns
+	start := time.Now()
+	n, err := send.WriteTo([]byte("delayed"), to)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 7)
+	buf := make([]byte, 64)
+	recv.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = recv.ReadFrom(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "delayed")
+	c.Assert(time.Since(start) >= 10*time.Millisecond, Equals, true)
+}
@@ -0,0 +1,64 @@
+package transport
+
+// xlTransport_go/frame.go
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Control messages exchanged over a connection, such as those making
+// up the hello handshake, are sent as frames: a 4-byte big-endian
+// length followed by that many bytes of payload.
+
+const (
+	FRAME_HEADER_LEN = 4
+	MAX_FRAME_LEN    = 1024 * 1024
+)
+
+// Write a single frame carrying payload to w.
+func writeFrame(w io.Writer, payload []byte) (err error) {
+	if len(payload) > MAX_FRAME_LEN {
+		err = FrameTooLong
+	} else {
+		buf := make([]byte, FRAME_HEADER_LEN+len(payload))
+		binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+		copy(buf[FRAME_HEADER_LEN:], payload)
+		_, err = w.Write(buf)
+	}
+	return
+}
+
+// Read a single frame from r, returning its payload.
+func readFrame(r io.Reader) (payload []byte, err error) {
+	var hdr [FRAME_HEADER_LEN]byte
+	if _, err = io.ReadFull(r, hdr[:]); err == nil {
+		frameLen := binary.BigEndian.Uint32(hdr[:])
+		if frameLen > MAX_FRAME_LEN {
+			err = FrameTooLong
+		} else {
+			payload = make([]byte, frameLen)
+			_, err = io.ReadFull(r, payload)
+		}
+	}
+	return
+}
+
+// Send a frame to the far end while reading one from it.  The write
+// runs in its own goroutine so that a symmetric exchange cannot
+// deadlock on an unbuffered transport.  If the read fails, cnx is
+// closed, so that a write stalled on an unresponsive peer does not
+// leave its goroutine behind.
+func exchangeFrames(cnx ConnectionI, out []byte) (in []byte, err error) {
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- writeFrame(cnx, out)
+	}()
+	if in, err = readFrame(cnx); err != nil {
+		cnx.Close()
+		<-writeErr
+	} else {
+		err = <-writeErr
+	}
+	return
+}
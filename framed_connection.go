@@ -0,0 +1,205 @@
+package transport
+
+// xlTransport_go/framed_connection.go
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// A FramedConnection carries messages rather than a byte stream.  Each
+// message is sent as one frame whose payload begins with a type byte:
+// FRAMED_DATA for application messages, FRAMED_PING and FRAMED_PONG for
+// the heartbeat.  Pings are answered automatically; the application
+// sees only data.
+//
+// Without a heartbeat, frames are read as the application asks for
+// messages, so pings are answered only while it is reading.  With one
+// (see heartbeat.go) a goroutine reads continuously, answering pings,
+// timing pongs and queueing messages for ReadMsg.
+//
+// Read and Write present the messages as a byte stream, so that a
+// FramedConnection can be used wherever a ConnectionI is expected.
+// SendMsg and RecvMsg make it a DatagramConnectionI.
+
+const (
+	FRAMED_DATA = 0
+	FRAMED_PING = 1
+	FRAMED_PONG = 2
+
+	// The most data carried by one frame; Write splits longer slices.
+	MAX_FRAMED_MSG = MAX_FRAME_LEN - 1
+
+	// Messages read ahead by the heartbeat's reader and not yet taken
+	// by ReadMsg.
+	FRAMED_QUEUE_LEN = 64
+)
+
+type FramedConnection struct {
+	ConnectionI
+	wMu  sync.Mutex // serializes frames written
+	rMu  sync.Mutex // serializes Read and ReadMsg
+	rBuf []byte     // the unread rest of the message last read by Read
+	hb   *heartbeat // nil if there is no heartbeat
+}
+
+// Carry messages over cnx.  If hb is not nil, the far end is pinged as
+// it specifies and the connection closed with PeerDead if it falls
+// silent.  The far end must also be a FramedConnection.
+func NewFramedConnection(cnx ConnectionI, hb *HeartbeatConfig) (
+	fc *FramedConnection, err error) {
+
+	if cnx == nil {
+		err = NilConnection
+	} else {
+		fc = &FramedConnection{ConnectionI: cnx}
+		if hb != nil {
+			fc.hb = newHeartbeat(fc, hb)
+			go fc.hb.readLoop()
+			fc.hb.start()
+		}
+	}
+	return
+}
+
+// Write one frame of type typ.
+func (fc *FramedConnection) writeTyped(typ byte, payload []byte) error {
+	buf := make([]byte, 1+len(payload))
+	buf[0] = typ
+	copy(buf[1:], payload)
+	fc.wMu.Lock()
+	defer fc.wMu.Unlock()
+	return writeFrame(fc.ConnectionI, buf)
+}
+
+// Read one frame, returning its type and the rest of its payload.
+func (fc *FramedConnection) readTyped() (typ byte, payload []byte, err error) {
+	var frame []byte
+	if frame, err = readFrame(fc.ConnectionI); err == nil {
+		if len(frame) == 0 {
+			err = BadRecord
+		} else {
+			typ, payload = frame[0], frame[1:]
+		}
+	}
+	return
+}
+
+func (fc *FramedConnection) pong(ping []byte) error {
+	return fc.writeTyped(FRAMED_PONG, ping)
+}
+
+// Send msg as a single message.
+func (fc *FramedConnection) WriteMsg(msg []byte) (err error) {
+	if fc.hb != nil {
+		if err = fc.hb.deadErr(); err != nil {
+			return
+		}
+	}
+	if len(msg) > MAX_FRAMED_MSG {
+		return FrameTooLong
+	}
+	return fc.writeTyped(FRAMED_DATA, msg)
+}
+
+// Return the next message from the far end.
+func (fc *FramedConnection) ReadMsg() (msg []byte, err error) {
+	fc.rMu.Lock()
+	defer fc.rMu.Unlock()
+	return fc.readMsg()
+}
+
+func (fc *FramedConnection) readMsg() (msg []byte, err error) {
+	if fc.hb != nil {
+		return fc.hb.next()
+	}
+	for {
+		var typ byte
+		var payload []byte
+		if typ, payload, err = fc.readTyped(); err != nil {
+			return
+		}
+		switch typ {
+		case FRAMED_DATA:
+			return payload, nil
+		case FRAMED_PING:
+			if err = fc.pong(payload); err != nil {
+				return
+			}
+		case FRAMED_PONG:
+			// no heartbeat of ours outstanding
+		default:
+			return nil, BadRecord
+		}
+	}
+}
+
+// Send msg as a single message; the same as WriteMsg.
+func (fc *FramedConnection) SendMsg(msg []byte) error {
+	return fc.WriteMsg(msg)
+}
+
+// Return the next message from the far end; the same as ReadMsg.
+func (fc *FramedConnection) RecvMsg() ([]byte, error) {
+	return fc.ReadMsg()
+}
+
+func (fc *FramedConnection) MaxMsgSize() int {
+	return MAX_FRAMED_MSG
+}
+
+// Read the stream of messages.  A message longer than b is returned
+// over several calls.
+func (fc *FramedConnection) Read(b []byte) (count int, err error) {
+	fc.rMu.Lock()
+	defer fc.rMu.Unlock()
+	for len(fc.rBuf) == 0 {
+		if fc.rBuf, err = fc.readMsg(); err != nil {
+			return
+		}
+	}
+	count = copy(b, fc.rBuf)
+	fc.rBuf = fc.rBuf[count:]
+	return
+}
+
+// Write b as one or more messages.
+func (fc *FramedConnection) Write(b []byte) (count int, err error) {
+	for count < len(b) && err == nil {
+		chunk := b[count:]
+		if len(chunk) > MAX_FRAMED_MSG {
+			chunk = chunk[:MAX_FRAMED_MSG]
+		}
+		if err = fc.WriteMsg(chunk); err == nil {
+			count += len(chunk)
+		}
+	}
+	return
+}
+
+// Stop any heartbeat and close the underlying connection.
+func (fc *FramedConnection) Close() error {
+	if fc.hb != nil {
+		fc.hb.stop()
+	}
+	return fc.ConnectionI.Close()
+}
+
+func (fc *FramedConnection) String() string {
+	return "Framed: " + fc.ConnectionI.String()
+}
+
+func encodeSeq(seq uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, seq)
+	return buf
+}
+
+func decodeSeq(buf []byte) (seq uint64, err error) {
+	if len(buf) != 8 {
+		err = BadRecord
+	} else {
+		seq = binary.BigEndian.Uint64(buf)
+	}
+	return
+}
@@ -0,0 +1,146 @@
+package transport
+
+// xlTransport_go/framed_connection_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"time"
+)
+
+func (s *XLSuite) TestFramedConnection(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_FRAMED_CONNECTION")
+	}
+	rng := xr.MakeSimpleRNG()
+	client, server := s.makeTcpPair(c)
+	fClient, err := NewFramedConnection(client, nil)
+	c.Assert(err, IsNil)
+	fServer, err := NewFramedConnection(server, nil)
+	c.Assert(err, IsNil)
+	defer fClient.Close()
+	defer fServer.Close()
+
+	// message boundaries survive the trip, empty messages included
+	go func() {
+		for {
+			msg, err := fServer.ReadMsg()
+			if err != nil {
+				return
+			}
+			fServer.WriteMsg(msg)
+		}
+	}()
+	for _, n := range []int{0, 1, 100, MAX_FRAMED_MSG} {
+		msg := make([]byte, n)
+		rng.NextBytes(msg)
+		c.Assert(fClient.WriteMsg(msg), IsNil)
+		echoed, err := fClient.ReadMsg()
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(echoed, msg), Equals, true)
+	}
+	c.Assert(fClient.WriteMsg(make([]byte, MAX_FRAMED_MSG+1)), Equals, FrameTooLong)
+
+	// and as a stream, longer writes are split into messages
+	data := make([]byte, 2*MAX_FRAMED_MSG+10)
+	rng.NextBytes(data)
+	go fClient.Write(data)
+	got := make([]byte, len(data))
+	_, err = io.ReadFull(fClient, got)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(got, data), Equals, true)
+}
+
+func (s *XLSuite) TestHeartbeatRTT(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HEARTBEAT_RTT")
+	}
+	client, server := s.makeTcpPair(c)
+	c.Assert(client.(*TcpConnection).SetKeepAlive(time.Minute), IsNil)
+	hb := &HeartbeatConfig{Interval: 10 * time.Millisecond}
+	fClient, err := NewFramedConnection(client, hb)
+	c.Assert(err, IsNil)
+	defer fClient.Close()
+
+	// the far end need not run a heartbeat of its own, only read
+	fServer, err := NewFramedConnection(server, nil)
+	c.Assert(err, IsNil)
+	defer fServer.Close()
+	go func() {
+		for {
+			msg, err := fServer.ReadMsg()
+			if err != nil {
+				return
+			}
+			fServer.WriteMsg(msg)
+		}
+	}()
+	c.Assert(fClient.WriteMsg([]byte("hello")), IsNil)
+	msg, err := fClient.ReadMsg()
+	c.Assert(err, IsNil)
+	c.Assert(string(msg), Equals, "hello")
+
+	deadline := time.Now().Add(5 * time.Second)
+	srtt, rttvar := fClient.RTT()
+	for srtt == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		srtt, rttvar = fClient.RTT()
+	}
+	c.Assert(srtt > 0, Equals, true)
+	c.Assert(rttvar > 0, Equals, true)
+}
+
+// A silent peer is declared dead once the timeout has passed, and not
+// before.
+func (s *XLSuite) TestHeartbeatDeadPeer(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HEARTBEAT_DEAD_PEER")
+	}
+	clock := NewVirtualClock(epoch)
+	near := NewMockEndPoint("T", "near").(*MockEndPoint)
+	far := NewMockEndPoint("T", "far").(*MockEndPoint)
+	cnx, err := newMockConnection(near, far, clock, 0)
+	c.Assert(err, IsNil)
+	silent, _ := NewReverseMockConnection(cnx)
+	fc, err := NewFramedConnection(cnx,
+		&HeartbeatConfig{Interval: time.Second, Timeout: 3 * time.Second,
+			Clock: clock})
+	c.Assert(err, IsNil)
+
+	clock.Advance(2 * time.Second)
+	c.Assert(fc.hb.deadErr(), IsNil)
+	c.Assert(fc.WriteMsg([]byte("anyone there?")), IsNil)
+	clock.Advance(time.Second)
+	c.Assert(fc.hb.deadErr(), Equals, PeerDead)
+
+	_, err = fc.ReadMsg()
+	c.Assert(err, Equals, PeerDead)
+	c.Assert(IsTimeout(err), Equals, true)
+	c.Assert(fc.WriteMsg([]byte("hello?")), Equals, PeerDead)
+
+	// the two pings and the message reached the far end before it
+	// saw the connection close
+	frames := 0
+	for {
+		if _, err = readFrame(silent); err != nil {
+			break
+		}
+		frames++
+	}
+	c.Assert(frames, Equals, 3)
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *XLSuite) TestRTTSmoothing(c *C) {
+	hb := &heartbeat{}
+	hb.sampleRTT(100 * time.Millisecond)
+	c.Assert(hb.srtt, Equals, 100*time.Millisecond)
+	c.Assert(hb.rttvar, Equals, 50*time.Millisecond)
+	hb.sampleRTT(200 * time.Millisecond)
+	// rttvar = 3/4 * 50 + 1/4 * 100; srtt = 7/8 * 100 + 1/8 * 200
+	c.Assert(hb.rttvar, Equals, 62500*time.Microsecond)
+	c.Assert(hb.srtt, Equals, 112500*time.Microsecond)
+}
@@ -0,0 +1,343 @@
+//go:build unix
+
+package transport
+
+// xlTransport_go/handoff.go
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Handing acceptors and connections to a successor process, so that a
+// node's binary can be upgraded without refusing or dropping
+// connections.
+//
+// The running process calls ListenHandOff on a control socket and then
+// HandOff, which waits for the successor to connect.  The successor,
+// once it is ready to serve, calls ReceiveHandOff on the same path.
+// Duplicates of the listening sockets and of any connections named are
+// passed to it as SCM_RIGHTS ancillary data, and once it acknowledges
+// receipt the old process's acceptors and those connections are
+// closed.  The sockets themselves live on in the successor, so a client
+// connecting meanwhile waits in the listen queue rather than being
+// refused, and the successor accepts it.
+//
+// The old process should then drain: finish with the connections it
+// kept and exit.  Connections handed off should be quiet at the time,
+// since anything already read from them into the old process's buffers
+// is lost.  Only TCP and Unix acceptors and connections can be handed
+// off, not those wrapping them.
+//
+// The sockets go in frames of at most HANDOFF_BATCH, each listing what
+// it carries, one per line: TcpAcceptor (with its socket options in
+// query form if any), TcpConnection, UnixAcceptor or UnixConnection.
+// An empty frame ends the list, and the successor replies with
+// HANDOFF_ACK.
+
+const (
+	HANDOFF_BATCH   = 64
+	HANDOFF_ACK     = "ok"
+	HANDOFF_TIMEOUT = 10 * time.Second
+)
+
+type HandOffListener struct {
+	listener *net.UnixListener
+}
+
+// Listen for the successor at path, which must not already exist.
+func ListenHandOff(path string) (*HandOffListener, error) {
+	ep, err := NewUnixEndPoint(path)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.ListenUnix("unix", ep.GetUnixAddr())
+	if err != nil {
+		return nil, wrapError("listen", "unix", ep, nil, err)
+	}
+	return &HandOffListener{l}, nil
+}
+
+// Stop listening for a successor, removing the control socket.
+func (h *HandOffListener) Close() error {
+	return h.listener.Close()
+}
+
+// Wait for the successor to connect and hand it the acceptors and
+// connections.  Once it has them they are closed here.  If anything
+// goes wrong they are left open and an error returned, so that this
+// process can carry on serving.
+func (h *HandOffListener) HandOff(acceptors []AcceptorI,
+	cnxs []ConnectionI) (err error) {
+
+	var files []*os.File
+	var kinds []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, acc := range acceptors {
+		var f *os.File
+		var kind string
+		if f, kind, err = acceptorFile(acc); err != nil {
+			return
+		}
+		files, kinds = append(files, f), append(kinds, kind)
+	}
+	for _, cnx := range cnxs {
+		var f *os.File
+		var kind string
+		if f, kind, err = connectionFile(cnx); err != nil {
+			return
+		}
+		files, kinds = append(files, f), append(kinds, kind)
+	}
+
+	conn, err := h.listener.AcceptUnix()
+	if err != nil {
+		return wrapError("accept", "unix", nil, nil, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(HANDOFF_TIMEOUT))
+	for start := 0; start < len(files); start += HANDOFF_BATCH {
+		end := start + HANDOFF_BATCH
+		if end > len(files) {
+			end = len(files)
+		}
+		if err = sendRights(conn, kinds[start:end], files[start:end]); err != nil {
+			return
+		}
+	}
+	if err = sendRights(conn, nil, nil); err != nil {
+		return
+	}
+	ack, err := readFrame(conn)
+	if err == nil && string(ack) != HANDOFF_ACK {
+		err = BadRecord
+	}
+	if err != nil {
+		return wrapError("handoff", "unix", nil, nil, err)
+	}
+
+	for _, acc := range acceptors {
+		if ua, ok := acc.(*UnixAcceptor); ok {
+			// the socket file now belongs to the successor
+			ua.listener.SetUnlinkOnClose(false)
+		}
+		acc.Close()
+	}
+	for _, cnx := range cnxs {
+		cnx.Close()
+	}
+	return
+}
+
+func acceptorFile(acc AcceptorI) (f *os.File, kind string, err error) {
+	switch a := acc.(type) {
+	case *TcpAcceptor:
+		kind = "TcpAcceptor"
+		if opts := a.opts.String(); opts != "" {
+			kind += "?" + opts
+		}
+		f, err = a.File()
+	case *UnixAcceptor:
+		kind = "UnixAcceptor"
+		f, err = a.File()
+	default:
+		err = CannotHandOff
+	}
+	return
+}
+
+func connectionFile(cnx ConnectionI) (f *os.File, kind string, err error) {
+	switch c := cnx.(type) {
+	case *TcpConnection:
+		kind = "TcpConnection"
+		f, err = c.conn.File()
+	case *UnixConnection:
+		kind = "UnixConnection"
+		f, err = c.conn.File()
+	default:
+		err = CannotHandOff
+	}
+	return
+}
+
+// Send one frame listing kinds, with files attached.
+func sendRights(conn *net.UnixConn, kinds []string, files []*os.File) error {
+	payload := []byte(strings.Join(kinds, "\n"))
+	buf := make([]byte, FRAME_HEADER_LEN+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[FRAME_HEADER_LEN:], payload)
+	var oob []byte
+	if len(files) > 0 {
+		fds := make([]int, len(files))
+		for i, f := range files {
+			fds[i] = int(f.Fd())
+		}
+		oob = syscall.UnixRights(fds...)
+	}
+	_, _, err := conn.WriteMsgUnix(buf, oob, nil)
+	return wrapError("handoff", "unix", nil, nil, err)
+}
+
+// Receive one frame, returning the kinds it lists and the files
+// attached.
+func receiveRights(conn *net.UnixConn) (kinds []string, files []*os.File, err error) {
+	hdr := make([]byte, FRAME_HEADER_LEN)
+	oob := make([]byte, syscall.CmsgSpace(4*HANDOFF_BATCH))
+	n, oobn, _, _, err := conn.ReadMsgUnix(hdr, oob)
+	if err == nil && oobn > 0 {
+		files, err = parseRights(oob[:oobn])
+	}
+	if err == nil && n < FRAME_HEADER_LEN {
+		_, err = io.ReadFull(conn, hdr[n:])
+	}
+	var payload []byte
+	if err == nil {
+		frameLen := binary.BigEndian.Uint32(hdr)
+		if frameLen > MAX_FRAME_LEN {
+			err = FrameTooLong
+		} else {
+			payload = make([]byte, frameLen)
+			_, err = io.ReadFull(conn, payload)
+		}
+	}
+	if err == nil && len(payload) > 0 {
+		kinds = strings.Split(string(payload), "\n")
+	}
+	if err == nil && len(kinds) != len(files) {
+		err = BadRecord
+	}
+	if err != nil {
+		for _, f := range files {
+			f.Close()
+		}
+		return nil, nil, wrapError("handoff", "unix", nil, nil, err)
+	}
+	return
+}
+
+func parseRights(oob []byte) (files []*os.File, err error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		fds, fdErr := syscall.ParseUnixRights(&msg)
+		if fdErr != nil {
+			continue
+		}
+		for _, fd := range fds {
+			syscall.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), "handoff"))
+		}
+	}
+	return
+}
+
+// Connect to the process listening at path and take over its acceptors
+// and connections, in the order it gave them.
+func ReceiveHandOff(path string) (acceptors []AcceptorI,
+	cnxs []ConnectionI, err error) {
+
+	ep, err := NewUnixEndPoint(path)
+	if err != nil {
+		return
+	}
+	conn, err := net.DialUnix("unix", nil, ep.GetUnixAddr())
+	if err != nil {
+		return nil, nil, wrapError("dial", "unix", nil, ep, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(HANDOFF_TIMEOUT))
+
+	var kinds []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+		if err != nil {
+			for _, acc := range acceptors {
+				acc.Close()
+			}
+			for _, cnx := range cnxs {
+				cnx.Close()
+			}
+			acceptors, cnxs = nil, nil
+		}
+	}()
+	for {
+		batchKinds, batchFiles, rErr := receiveRights(conn)
+		if rErr != nil {
+			err = rErr
+			return
+		}
+		if len(batchKinds) == 0 {
+			break
+		}
+		kinds = append(kinds, batchKinds...)
+		files = append(files, batchFiles...)
+	}
+	for i, kind := range kinds {
+		var acc AcceptorI
+		var cnx ConnectionI
+		if acc, cnx, err = fromHandOff(kind, files[i]); err != nil {
+			return
+		}
+		if acc != nil {
+			acceptors = append(acceptors, acc)
+		} else {
+			cnxs = append(cnxs, cnx)
+		}
+	}
+	if err = writeFrame(conn, []byte(HANDOFF_ACK)); err == nil {
+		for _, acc := range acceptors {
+			if ua, ok := acc.(*UnixAcceptor); ok {
+				// the socket file is ours now
+				ua.listener.SetUnlinkOnClose(true)
+			}
+		}
+	}
+	return
+}
+
+// Rebuild the acceptor or connection of the kind given on f.
+func fromHandOff(kind string, f *os.File) (acc AcceptorI, cnx ConnectionI,
+	err error) {
+
+	kind, opts, err := splitOptions(kind)
+	if err != nil {
+		return
+	}
+	switch kind {
+	case "TcpAcceptor":
+		acc, err = NewTcpAcceptorFromFile(f, opts)
+	case "UnixAcceptor":
+		acc, err = NewUnixAcceptorFromFile(f)
+	case "TcpConnection", "UnixConnection":
+		var c net.Conn
+		if c, err = net.FileConn(f); err != nil {
+			return
+		}
+		switch conn := c.(type) {
+		case *net.TCPConn:
+			cnx, err = NewTcpConnection(conn)
+		case *net.UnixConn:
+			cnx, err = NewUnixConnection(conn)
+		default:
+			c.Close()
+			err = CannotHandOff
+		}
+	default:
+		err = BadRecord
+	}
+	return
+}
@@ -0,0 +1,125 @@
+//go:build unix
+
+package transport
+
+// xlTransport_go/handoff_test.go
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The old and new processes are played by goroutines here; SCM_RIGHTS
+// does not care whether the far end is another process.
+func (s *XLSuite) TestHandOff(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HAND_OFF")
+	}
+	dir := c.MkDir()
+	opts := &SocketOptions{Nagle: true}
+	tcpAcc, err := NewTcpAcceptor("127.0.0.1:0", opts)
+	c.Assert(err, IsNil)
+	unixPath := filepath.Join(dir, "node.sock")
+	unixAcc, err := NewUnixAcceptor(unixPath)
+	c.Assert(err, IsNil)
+
+	// a live connection to be handed off, and one to be kept
+	ctor, err := NewTcpConnector(tcpAcc.GetEndPoint())
+	c.Assert(err, IsNil)
+	client, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer client.Close()
+	passed, err := tcpAcc.Accept()
+	c.Assert(err, IsNil)
+	client2, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer client2.Close()
+	kept, err := tcpAcc.Accept()
+	c.Assert(err, IsNil)
+	defer kept.Close()
+
+	// wrapped connections cannot be handed off
+	framed, err := NewFramedConnection(kept, nil)
+	c.Assert(err, IsNil)
+	ho, err := ListenHandOff(filepath.Join(dir, "handoff.sock"))
+	c.Assert(err, IsNil)
+	defer ho.Close()
+	err = ho.HandOff(nil, []ConnectionI{framed})
+	c.Assert(err, Equals, CannotHandOff)
+
+	// a client arriving during the handoff waits in the listen queue
+	client3, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer client3.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ho.HandOff([]AcceptorI{tcpAcc, unixAcc}, []ConnectionI{passed})
+	}()
+	acceptors, cnxs, err := ReceiveHandOff(filepath.Join(dir, "handoff.sock"))
+	c.Assert(err, IsNil)
+	c.Assert(<-done, IsNil)
+
+	// the old process's copies are closed, the sockets live on
+	c.Assert(tcpAcc.IsClosed(), Equals, true)
+	c.Assert(unixAcc.IsClosed(), Equals, true)
+	c.Assert(passed.GetState(), Equals, CNX_DISCONNECTED)
+	_, err = os.Stat(unixPath)
+	c.Assert(err, IsNil)
+
+	c.Assert(len(acceptors), Equals, 2)
+	c.Assert(len(cnxs), Equals, 1)
+	newTcp, ok := acceptors[0].(*TcpAcceptor)
+	c.Assert(ok, Equals, true)
+	c.Assert(newTcp.GetEndPoint().Equal(tcpAcc.GetEndPoint()), Equals, true)
+	c.Assert(newTcp.GetOptions().String(), Equals, "nodelay=0")
+	newUnix, ok := acceptors[1].(*UnixAcceptor)
+	c.Assert(ok, Equals, true)
+	c.Assert(newUnix.GetEndPoint().Equal(unixAcc.GetEndPoint()), Equals, true)
+
+	// the handed-off connection carries on
+	c.Assert(cnxs[0].GetFarEnd().Equal(client.GetNearEnd()), Equals, true)
+	go echo(cnxs[0])
+	roundTrip := func(cnx ConnectionI, msg string) {
+		_, err := cnx.Write([]byte(msg))
+		c.Assert(err, IsNil)
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(cnx, buf)
+		c.Assert(err, IsNil)
+		c.Assert(string(buf), Equals, msg)
+	}
+	roundTrip(client, "after the handoff")
+
+	// the new process accepts the client that was waiting
+	server3, err := newTcp.Accept()
+	c.Assert(err, IsNil)
+	c.Assert(server3.GetFarEnd().Equal(client3.GetNearEnd()), Equals, true)
+	go echo(server3)
+	roundTrip(client3, "queued during the handoff")
+
+	// and new Unix clients
+	unixCtor, err := NewUnixConnector(newUnix.GetEndPoint())
+	c.Assert(err, IsNil)
+	unixClient, err := unixCtor.Connect(nil)
+	c.Assert(err, IsNil)
+	server4, err := newUnix.Accept()
+	c.Assert(err, IsNil)
+	go echo(server4)
+	roundTrip(unixClient, "over the new Unix acceptor")
+	unixClient.Close()
+
+	// the old process drains what it kept
+	go echo(kept)
+	roundTrip(client2, "still served by the old process")
+
+	cnxs[0].Close()
+	server3.Close()
+	server4.Close()
+	c.Assert(newTcp.Close(), IsNil)
+	c.Assert(newUnix.Close(), IsNil)
+	_, err = os.Stat(unixPath)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
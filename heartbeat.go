@@ -0,0 +1,243 @@
+package transport
+
+// xlTransport_go/heartbeat.go
+
+import (
+	"sync"
+	"time"
+)
+
+// An application-level heartbeat over a FramedConnection.  Every
+// Interval the near end sends a PING carrying a sequence number, which
+// the far end echoes in a PONG.  Any frame received is proof that the
+// far end is alive; if nothing at all arrives for Timeout, the far end
+// is declared dead and the connection closed, after which reads and
+// writes return PeerDead.  A dead peer is therefore detected between
+// Timeout and Timeout+Interval after it falls silent.
+//
+// Each PONG answering the latest PING yields a sample of the round trip
+// time, from which a smoothed RTT and its variance are maintained as
+// TCP does (RFC 6298).
+
+const (
+	DEFAULT_HEARTBEAT_INTERVAL = 10 * time.Second
+)
+
+type HeartbeatConfig struct {
+	Interval time.Duration // between pings; zero means the default
+	Timeout  time.Duration // of silence; zero means three intervals
+	Clock    ClockI        // nil means the RealClock
+}
+
+type heartbeat struct {
+	fc       *FramedConnection
+	interval time.Duration
+	timeout  time.Duration
+	clock    ClockI
+	msgs     chan []byte   // read ahead for ReadMsg
+	done     chan struct{} // closed when stopped or dead
+	doneOnce sync.Once
+
+	mu          sync.Mutex
+	lastHeard   time.Time
+	delivering  bool // the reader is blocked queueing a message
+	seq         uint64
+	pingSentAt  time.Time
+	outstanding bool // whether the PING numbered seq is unanswered
+	srtt        time.Duration
+	rttvar      time.Duration
+	samples     int
+	timer       TimerI
+	dead        bool
+	stopped     bool
+	readErr     error
+}
+
+func newHeartbeat(fc *FramedConnection, cfg *HeartbeatConfig) *heartbeat {
+	hb := &heartbeat{
+		fc:       fc,
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+		clock:    cfg.Clock,
+		msgs:     make(chan []byte, FRAMED_QUEUE_LEN),
+		done:     make(chan struct{}),
+	}
+	if hb.interval <= 0 {
+		hb.interval = DEFAULT_HEARTBEAT_INTERVAL
+	}
+	if hb.timeout <= 0 {
+		hb.timeout = 3 * hb.interval
+	}
+	if hb.clock == nil {
+		hb.clock = RealClock{}
+	}
+	return hb
+}
+
+func (hb *heartbeat) start() {
+	hb.mu.Lock()
+	hb.lastHeard = hb.clock.Now()
+	hb.timer = hb.clock.AfterFunc(hb.interval, hb.tick)
+	hb.mu.Unlock()
+}
+
+// Declare the peer dead if it has been silent too long; otherwise ping
+// it and schedule the next tick.
+func (hb *heartbeat) tick() {
+	hb.mu.Lock()
+	if hb.stopped || hb.dead {
+		hb.mu.Unlock()
+		return
+	}
+	now := hb.clock.Now()
+	if !hb.delivering && now.Sub(hb.lastHeard) >= hb.timeout {
+		hb.mu.Unlock()
+		hb.die()
+		return
+	}
+	hb.seq++
+	seq := hb.seq
+	hb.pingSentAt, hb.outstanding = now, true
+	hb.timer = hb.clock.AfterFunc(hb.interval, hb.tick)
+	hb.mu.Unlock()
+
+	// a failure to write will be seen by the reader
+	hb.fc.writeTyped(FRAMED_PING, encodeSeq(seq))
+}
+
+func (hb *heartbeat) closeDone() {
+	hb.doneOnce.Do(func() { close(hb.done) })
+}
+
+func (hb *heartbeat) die() {
+	hb.mu.Lock()
+	if hb.dead || hb.stopped {
+		hb.mu.Unlock()
+		return
+	}
+	hb.dead = true
+	hb.mu.Unlock()
+	hb.closeDone()
+	observeError(hb.fc, "heartbeat", PeerDead)
+	hb.fc.ConnectionI.Close()
+}
+
+func (hb *heartbeat) stop() {
+	hb.mu.Lock()
+	hb.stopped = true
+	if hb.timer != nil {
+		hb.timer.Stop()
+	}
+	hb.mu.Unlock()
+	hb.closeDone()
+}
+
+func (hb *heartbeat) heard() {
+	hb.mu.Lock()
+	hb.lastHeard = hb.clock.Now()
+	hb.mu.Unlock()
+}
+
+func (hb *heartbeat) setDelivering(b bool) {
+	hb.mu.Lock()
+	hb.delivering = b
+	hb.lastHeard = hb.clock.Now()
+	hb.mu.Unlock()
+}
+
+// Take an RTT sample if seq answers the latest PING.
+func (hb *heartbeat) ponged(seq uint64) {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	if hb.outstanding && seq == hb.seq {
+		hb.outstanding = false
+		hb.sampleRTT(hb.clock.Now().Sub(hb.pingSentAt))
+	}
+}
+
+// Fold sample r into the smoothed RTT and variance as RFC 6298 does.
+// The caller holds hb.mu.
+func (hb *heartbeat) sampleRTT(r time.Duration) {
+	if hb.samples == 0 {
+		hb.srtt, hb.rttvar = r, r/2
+	} else {
+		delta := hb.srtt - r
+		if delta < 0 {
+			delta = -delta
+		}
+		hb.rttvar = (3*hb.rttvar + delta) / 4
+		hb.srtt = (7*hb.srtt + r) / 8
+	}
+	hb.samples++
+}
+
+// Read frames until the connection fails, answering PINGs, timing
+// PONGs and queueing messages.
+func (hb *heartbeat) readLoop() {
+	var err error
+	for err == nil {
+		var typ byte
+		var payload []byte
+		if typ, payload, err = hb.fc.readTyped(); err != nil {
+			break
+		}
+		hb.heard()
+		switch typ {
+		case FRAMED_DATA:
+			hb.setDelivering(true)
+			select {
+			case hb.msgs <- payload:
+			case <-hb.done:
+				err = ClosedConnection
+			}
+			hb.setDelivering(false)
+		case FRAMED_PING:
+			err = hb.fc.pong(payload)
+		case FRAMED_PONG:
+			var seq uint64
+			if seq, err = decodeSeq(payload); err == nil {
+				hb.ponged(seq)
+			}
+		default:
+			err = BadRecord
+		}
+	}
+	hb.mu.Lock()
+	hb.readErr = err
+	hb.mu.Unlock()
+	close(hb.msgs)
+}
+
+// Return the next message queued by the reader, or once there are no
+// more, why not.
+func (hb *heartbeat) next() ([]byte, error) {
+	if msg, ok := <-hb.msgs; ok {
+		return msg, nil
+	}
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	if hb.dead {
+		return nil, PeerDead
+	}
+	return nil, hb.readErr
+}
+
+func (hb *heartbeat) deadErr() error {
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+	if hb.dead {
+		return PeerDead
+	}
+	return nil
+}
+
+// Return the smoothed round trip time to the far end and its variance,
+// or zeroes if there is no heartbeat or no PONG has yet arrived.
+func (fc *FramedConnection) RTT() (srtt, rttvar time.Duration) {
+	if hb := fc.hb; hb != nil {
+		hb.mu.Lock()
+		srtt, rttvar = hb.srtt, hb.rttvar
+		hb.mu.Unlock()
+	}
+	return
+}
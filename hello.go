@@ -0,0 +1,280 @@
+package transport
+
+// xlTransport_go/hello.go
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	xc "github.com/jddixon/xlCrypto_go"
+	"time"
+)
+
+// The hello exchange binds a connection to the xLattice nodes at either
+// end.  Each side sends a HELLO carrying its node ID, its RSA public key
+// and a fresh random challenge.  Each side then signs a digest covering
+// its role, both challenges and both node IDs and sends the signature.
+// The side whose challenge sorts first signs as HELLO_ROLE_FIRST, the
+// other as HELLO_ROLE_SECOND, so that a signature cannot be reflected
+// back to the side which made it; a HELLO carrying our own node ID or
+// challenge is refused outright.  A side accepts the far end only if
+// the signature verifies under the public key advertised in the far
+// end's HELLO and, where the caller says who it expects, if the node ID
+// and key are the expected ones.
+//
+// Hello takes keys as xc.KeyI and xc.PublicKeyI.  As elsewhere in
+// xLattice, node keys are RSA keys; any other is refused with
+// UnsupportedKey.
+//
+// HELLO:  version (1 byte)
+//         node ID length (2 bytes), node ID
+//         key length (2 bytes), PKIX DER-encoded public key
+//         challenge (HELLO_CHALLENGE_LEN bytes)
+// SIG:    PKCS #1 v1.5 signature over the SHA-256 hello digest
+
+const (
+	HELLO_VERSION       = 1
+	HELLO_CHALLENGE_LEN = 32
+
+	HELLO_ROLE_FIRST  = 1
+	HELLO_ROLE_SECOND = 2
+
+	// The most time the exchange may take before the connection is
+	// closed.
+	HELLO_TIMEOUT = 10 * time.Second
+)
+
+var helloContext = []byte("xLattice hello v1")
+
+// A connection whose far end has proven possession of the private key
+// corresponding to PeerKey and claimed the node ID PeerID.
+type AuthConnection struct {
+	ConnectionI
+	peerID  []byte
+	peerKey *rsa.PublicKey
+}
+
+// Return the node ID of the verified peer.
+func (c *AuthConnection) GetPeerID() []byte {
+	return c.peerID
+}
+
+// Return the public key of the verified peer.
+func (c *AuthConnection) GetPeerKey() *rsa.PublicKey {
+	return c.peerKey
+}
+
+func (c *AuthConnection) String() string {
+	return "Auth: " + c.ConnectionI.String()
+}
+
+// Carry out the hello exchange over cnx, identifying this node by myID
+// and proving it with myKey.  If expectedID is not nil the far end must
+// claim exactly that node ID; if expectedKey is not nil it must prove
+// possession of exactly that key.  Both sides of the connection must
+// call Hello.  An exchange taking longer than HELLO_TIMEOUT is cut
+// short by closing cnx and fails with HelloTimeout.
+//
+// On success the connection is returned annotated with the peer's
+// verified node ID and public key.  On failure the caller should close
+// cnx; it is left in an undefined state.
+func Hello(cnx ConnectionI, myID []byte, myKey xc.KeyI,
+	expectedID []byte, expectedKey xc.PublicKeyI) (
+	ac *AuthConnection, err error) {
+
+	var expected *rsa.PublicKey
+	signKey, ok := myKey.(*rsa.PrivateKey)
+	if myKey == nil || (ok && signKey == nil) {
+		err = NilKey
+	} else if !ok {
+		err = UnsupportedKey
+	} else if expectedKey != nil {
+		if expected, ok = expectedKey.(*rsa.PublicKey); !ok {
+			err = UnsupportedKey
+		}
+	}
+	if err == nil {
+		ac, err = helloWithin(HELLO_TIMEOUT, RealClock{}, cnx, myID,
+			&signKey.PublicKey, signKey, expectedID, expected)
+	}
+	return
+}
+
+// Run the hello exchange, closing cnx if it takes longer than timeout
+// by clock.
+func helloWithin(timeout time.Duration, clock ClockI, cnx ConnectionI,
+	myID []byte, myPub *rsa.PublicKey, signKey *rsa.PrivateKey,
+	expectedID []byte, expectedKey *rsa.PublicKey) (
+	ac *AuthConnection, err error) {
+
+	if cnx == nil {
+		return nil, NilConnection
+	}
+	timer := clock.AfterFunc(timeout, func() { cnx.Close() })
+	ac, err = hello(cnx, myID, myPub, signKey, expectedID, expectedKey)
+	if !timer.Stop() {
+		ac, err = nil, HelloTimeout
+	}
+	return
+}
+
+// The hello exchange proper.  The public key advertised is separated
+// from the signing key so that tests can play an impostor.
+func hello(cnx ConnectionI, myID []byte, myPub *rsa.PublicKey,
+	signKey *rsa.PrivateKey, expectedID []byte, expectedKey *rsa.PublicKey) (
+	ac *AuthConnection, err error) {
+
+	defer timeHandshake("hello", cnx)(&err)
+	var (
+		myChallenge   []byte
+		myHello       []byte
+		peerHello     []byte
+		peerID        []byte
+		peerKey       *rsa.PublicKey
+		peerChallenge []byte
+		mySig         []byte
+		peerSig       []byte
+	)
+	if cnx == nil {
+		err = NilConnection
+	} else if len(myID) == 0 {
+		err = NilNodeID
+	}
+	if err == nil {
+		myChallenge = make([]byte, HELLO_CHALLENGE_LEN)
+		_, err = rand.Read(myChallenge)
+	}
+	if err == nil {
+		myHello, err = encodeHello(myID, myPub, myChallenge)
+	}
+	if err == nil {
+		peerHello, err = exchangeFrames(cnx, myHello)
+	}
+	if err == nil {
+		peerID, peerKey, peerChallenge, err = decodeHello(peerHello)
+	}
+	if err == nil {
+		if bytes.Equal(peerID, myID) || bytes.Equal(peerChallenge, myChallenge) {
+			err = ReflectedHello
+		} else if expectedID != nil && !bytes.Equal(expectedID, peerID) {
+			err = UnexpectedPeerID
+		} else if expectedKey != nil && !publicKeysEqual(expectedKey, peerKey) {
+			err = UnexpectedPeerKey
+		}
+	}
+	if err == nil {
+		digest := helloDigest(peerChallenge, myChallenge, myID, peerID)
+		mySig, err = rsa.SignPKCS1v15(rand.Reader, signKey,
+			crypto.SHA256, digest)
+	}
+	if err == nil {
+		peerSig, err = exchangeFrames(cnx, mySig)
+	}
+	if err == nil {
+		digest := helloDigest(myChallenge, peerChallenge, peerID, myID)
+		if rsa.VerifyPKCS1v15(peerKey, crypto.SHA256, digest, peerSig) != nil {
+			err = BadHelloSig
+		}
+	}
+	if err == nil {
+		ac = &AuthConnection{
+			ConnectionI: cnx,
+			peerID:      peerID,
+			peerKey:     peerKey,
+		}
+	}
+	return
+}
+
+// The digest signed by a node.  It covers the node's role, the
+// challenge it was sent, the challenge it sent, its own node ID and the
+// peer's, so that a signature cannot be replayed on another connection,
+// by another node or back to the node itself.
+func helloDigest(signerGot, signerSent, signerID, peerID []byte) []byte {
+	role := byte(HELLO_ROLE_SECOND)
+	if bytes.Compare(signerSent, signerGot) < 0 {
+		role = HELLO_ROLE_FIRST
+	}
+	d := sha256.New()
+	d.Write(helloContext)
+	d.Write([]byte{role})
+	for _, field := range [][]byte{signerGot, signerSent, signerID, peerID} {
+		var lenBuf [2]byte
+		binary.BigEndian.PutUint16(lenBuf[:], uint16(len(field)))
+		d.Write(lenBuf[:])
+		d.Write(field)
+	}
+	return d.Sum(nil)
+}
+
+func encodeHello(nodeID []byte, pub *rsa.PublicKey, challenge []byte) (
+	msg []byte, err error) {
+
+	var der []byte
+	if len(nodeID) > 0xffff {
+		err = BadHelloMsg
+	} else {
+		der, err = x509.MarshalPKIXPublicKey(pub)
+	}
+	if err == nil {
+		var buf bytes.Buffer
+		var lenBuf [2]byte
+		buf.WriteByte(HELLO_VERSION)
+		binary.BigEndian.PutUint16(lenBuf[:], uint16(len(nodeID)))
+		buf.Write(lenBuf[:])
+		buf.Write(nodeID)
+		binary.BigEndian.PutUint16(lenBuf[:], uint16(len(der)))
+		buf.Write(lenBuf[:])
+		buf.Write(der)
+		buf.Write(challenge)
+		msg = buf.Bytes()
+	}
+	return
+}
+
+func decodeHello(msg []byte) (nodeID []byte, pub *rsa.PublicKey,
+	challenge []byte, err error) {
+
+	// returns the next length-prefixed field, advancing msg
+	nextField := func() (field []byte) {
+		if len(msg) >= 2 {
+			n := int(binary.BigEndian.Uint16(msg))
+			if len(msg) >= 2+n {
+				field = msg[2 : 2+n]
+				msg = msg[2+n:]
+			}
+		}
+		return
+	}
+	if len(msg) < 1 || msg[0] != HELLO_VERSION {
+		err = BadHelloMsg
+	} else {
+		msg = msg[1:]
+		nodeID = nextField()
+		der := nextField()
+		if len(nodeID) == 0 || der == nil || len(msg) != HELLO_CHALLENGE_LEN {
+			err = BadHelloMsg
+		} else {
+			var key interface{}
+			key, err = x509.ParsePKIXPublicKey(der)
+			if err == nil {
+				var ok bool
+				if pub, ok = key.(*rsa.PublicKey); !ok {
+					err = BadHelloMsg
+				}
+			} else {
+				err = BadHelloMsg
+			}
+			challenge = msg
+		}
+	}
+	return
+}
+
+func publicKeysEqual(a, b *rsa.PublicKey) bool {
+	return a.E == b.E && a.N.Cmp(b.N) == 0
+}
@@ -0,0 +1,250 @@
+package transport
+
+// xlTransport_go/hello_test.go
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"time"
+)
+
+type helloResult struct {
+	ac  *AuthConnection
+	err error
+}
+
+// Open a TCP connection to a fresh acceptor, returning both ends.
+func (s *XLSuite) makeTcpPair(c *C) (client, server ConnectionI) {
+	acc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	accepted := make(chan ConnectionI, 1)
+	go func() {
+		cnx, err := acc.Accept()
+		if err != nil {
+			cnx = nil
+		}
+		accepted <- cnx
+	}()
+	ctor, err := NewTcpConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	client, err = ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	server = <-accepted
+	c.Assert(server, NotNil)
+	return
+}
+
+func (s *XLSuite) makeNodeID(rng *xr.SimpleRNG) []byte {
+	id := make([]byte, 32)
+	rng.NextBytes(id)
+	return id
+}
+
+func (s *XLSuite) TestHello(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HELLO")
+	}
+	rng := xr.MakeSimpleRNG()
+	clientID, serverID := s.makeNodeID(rng), s.makeNodeID(rng)
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+
+	client, server := s.makeTcpPair(c)
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan helloResult, 1)
+	go func() {
+		ac, err := Hello(server, serverID, serverKey, nil, nil)
+		done <- helloResult{ac, err}
+	}()
+	clientAC, err := Hello(client, clientID, clientKey,
+		serverID, &serverKey.PublicKey)
+	c.Assert(err, IsNil)
+	result := <-done
+	c.Assert(result.err, IsNil)
+
+	// each side knows who is on the other end
+	c.Assert(bytes.Equal(clientAC.GetPeerID(), serverID), Equals, true)
+	c.Assert(publicKeysEqual(clientAC.GetPeerKey(), &serverKey.PublicKey),
+		Equals, true)
+	serverAC := result.ac
+	c.Assert(bytes.Equal(serverAC.GetPeerID(), clientID), Equals, true)
+	c.Assert(publicKeysEqual(serverAC.GetPeerKey(), &clientKey.PublicKey),
+		Equals, true)
+
+	// and the connection is still usable
+	msg := make([]byte, 64)
+	rng.NextBytes(msg)
+	count, err := clientAC.Write(msg)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, len(msg))
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(serverAC, buf)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(msg, buf), Equals, true)
+}
+
+func (s *XLSuite) TestHelloUnexpectedPeer(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HELLO_UNEXPECTED_PEER")
+	}
+	rng := xr.MakeSimpleRNG()
+	clientID, serverID := s.makeNodeID(rng), s.makeNodeID(rng)
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+
+	// the client expects a different node ID
+	client, server := s.makeTcpPair(c)
+	go func(server ConnectionI) {
+		Hello(server, serverID, serverKey, nil, nil)
+		server.Close()
+	}(server)
+	_, err = Hello(client, clientID, clientKey, clientID, nil)
+	c.Assert(err, Equals, UnexpectedPeerID)
+	client.Close()
+
+	// the client expects a different key
+	client, server = s.makeTcpPair(c)
+	go func(server ConnectionI) {
+		Hello(server, serverID, serverKey, nil, nil)
+		server.Close()
+	}(server)
+	_, err = Hello(client, clientID, clientKey, serverID, &clientKey.PublicKey)
+	c.Assert(err, Equals, UnexpectedPeerKey)
+	client.Close()
+}
+
+func (s *XLSuite) TestHelloImpostor(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HELLO_IMPOSTOR")
+	}
+	rng := xr.MakeSimpleRNG()
+	clientID, serverID := s.makeNodeID(rng), s.makeNodeID(rng)
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	impostorKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+
+	// the server advertises serverKey but can only sign with impostorKey
+	client, server := s.makeTcpPair(c)
+	defer client.Close()
+	go func() {
+		hello(server, serverID, &serverKey.PublicKey, impostorKey, nil, nil)
+		server.Close()
+	}()
+	_, err = Hello(client, clientID, clientKey, serverID, &serverKey.PublicKey)
+	c.Assert(err, Equals, BadHelloSig)
+}
+
+func (s *XLSuite) TestHelloReflected(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HELLO_REFLECTED")
+	}
+	rng := xr.MakeSimpleRNG()
+	clientID := s.makeNodeID(rng)
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+
+	// the far end echoes every frame it is sent, the client's HELLO
+	// and then, were it to get one, the client's signature
+	client, server := s.makeTcpPair(c)
+	go func() {
+		for {
+			frame, err := readFrame(server)
+			if err != nil {
+				break
+			}
+			writeFrame(server, frame)
+		}
+		server.Close()
+	}()
+	_, err = Hello(client, clientID, clientKey, nil, nil)
+	c.Assert(err, Equals, ReflectedHello)
+	client.Close()
+}
+
+func (s *XLSuite) TestHelloTimeout(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HELLO_TIMEOUT")
+	}
+	rng := xr.MakeSimpleRNG()
+	clientID := s.makeNodeID(rng)
+	clientKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+
+	// keys other than RSA keys are refused
+	client, server := s.makeTcpPair(c)
+	defer server.Close()
+	_, err = Hello(client, clientID, "not a key", nil, nil)
+	c.Assert(err, Equals, UnsupportedKey)
+	_, err = Hello(client, clientID, clientKey, nil, "not a key")
+	c.Assert(err, Equals, UnsupportedKey)
+	_, err = Hello(client, clientID, (*rsa.PrivateKey)(nil), nil, nil)
+	c.Assert(err, Equals, NilKey)
+
+	// the far end never answers
+	clock := NewVirtualClock(time.Unix(0, 0))
+	done := make(chan error, 1)
+	go func() {
+		_, err := helloWithin(HELLO_TIMEOUT, clock, client, clientID,
+			&clientKey.PublicKey, clientKey, nil, nil)
+		done <- err
+	}()
+	clock.WaitForTimers(1)
+	clock.Advance(HELLO_TIMEOUT)
+	c.Assert(<-done, Equals, HelloTimeout)
+	_, err = client.Write([]byte("x"))
+	c.Assert(err, NotNil)
+}
+
+// A connection whose reads fail at once and whose writes stall until
+// it is closed.
+type stalledConnection struct {
+	ConnectionI
+	closed chan struct{}
+	wrote  chan struct{} // closed when Write returns
+}
+
+func (sc *stalledConnection) Read(b []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func (sc *stalledConnection) Write(b []byte) (int, error) {
+	defer close(sc.wrote)
+	<-sc.closed
+	return 0, ClosedConnection
+}
+
+func (sc *stalledConnection) Close() error {
+	close(sc.closed)
+	return nil
+}
+
+// A failed read does not leave the write of an exchange stalled.
+func (s *XLSuite) TestExchangeFramesReadFails(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_EXCHANGE_FRAMES_READ_FAILS")
+	}
+	sc := &stalledConnection{closed: make(chan struct{}),
+		wrote: make(chan struct{})}
+	_, err := exchangeFrames(sc, []byte("hello"))
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+	select {
+	case <-sc.wrote:
+	default:
+		c.Fatal("write still stalled")
+	}
+}
@@ -0,0 +1,235 @@
+package transport
+
+// xlTransport_go/hole_punch_connector.go
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// A HolePunchConnector opens a UDP path to a peer when either or both
+// may be behind NAT.  Both peers name the same session to a
+// RendezvousServer and learn each other's public end point from it.
+// Each then sends probes to the other from the socket it used to reach
+// the server, so that its NAT keeps the same mapping and, seeing
+// traffic go out to the peer, lets the peer's probes in:
+//
+//	XLPUNCH1 SYN <session>       until answered
+//	XLPUNCH1 ACK <session>       in answer to each SYN, and to an ACK
+//
+// An ACK received shows that the path is open both ways, as does data
+// received, the peer having had our ACK; that datagram is returned by
+// the connection's first Read.  An ACK is answered once with an ACK in
+// case the peer has had none.  A peer which has not heard our ACK goes
+// on sending SYNs, which the connection answers as it reads, so the
+// caller should be reading.
+//
+// This works through NATs which map a socket to the same public end
+// point whatever the destination, as most do; it cannot work through
+// those which map each destination separately.
+
+const (
+	PUNCH_MAGIC = "XLPUNCH1"
+	PUNCH_SYN   = "SYN"
+	PUNCH_ACK   = "ACK"
+
+	// How often requests and probes are repeated until answered.
+	PUNCH_INTERVAL = 100 * time.Millisecond
+
+	// The most time the rendezvous and the hole punching may take.
+	PUNCH_TIMEOUT = 10 * time.Second
+)
+
+type HolePunchConnector struct {
+	rendezvous *UdpEndPoint
+	session    string
+	timeout    time.Duration
+}
+
+// Meet the peer naming session at the rendezvous server.
+func NewHolePunchConnector(rendezvous EndPointI, session string) (
+	*HolePunchConnector, error) {
+
+	ep, ok := rendezvous.(*UdpEndPoint)
+	if !ok || ep == nil {
+		return nil, NotUdpEndPoint
+	}
+	if !validSession(session) {
+		return nil, BadRendezvousSession
+	}
+	ep2, _ := ep.Clone()
+	return &HolePunchConnector{rendezvous: ep2.(*UdpEndPoint),
+		session: session, timeout: PUNCH_TIMEOUT}, nil
+}
+
+// Set the most time Connect may take, PUNCH_TIMEOUT by default.
+func (c *HolePunchConnector) SetTimeout(d time.Duration) {
+	c.timeout = d
+}
+
+// Open a UDP socket, bound to nearEnd if it is not nil, and punch a
+// path from it to the peer.
+func (c *HolePunchConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
+	var laddr *net.UDPAddr
+	if nearEnd != nil {
+		ep, ok := nearEnd.(*UdpEndPoint)
+		if !ok {
+			return nil, NotUdpEndPoint
+		}
+		laddr = ep.udpAddr
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, wrapError("dial", "udp", nearEnd, c.rendezvous, err)
+	}
+	cnx, err := c.ConnectOn(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return cnx, nil
+}
+
+// Punch a path to the peer from conn, which becomes the connection's
+// if this succeeds.  conn may be a socket already in use, one handed
+// over by the supervisor say, or something standing in for one.
+func (c *HolePunchConnector) ConnectOn(conn net.PacketConn) (
+	*UdpConnection, error) {
+
+	nearEnd, _ := NewUdpEndPoint(conn.LocalAddr().String())
+	finish := observeDial(nearEnd, c.rendezvous)
+	start := time.Now()
+	deadline := start.Add(c.timeout)
+	far, err := c.meet(conn, deadline)
+	var pending []byte
+	if err == nil {
+		pending, err = c.punch(conn, far, deadline)
+	}
+	if dErr := conn.SetReadDeadline(time.Time{}); err == nil {
+		err = dErr
+	}
+	DefaultMetrics.transport("udp").countDial(err)
+	if err != nil {
+		err = wrapError("dial", "udp", nearEnd, c.rendezvous, err)
+		finish(nil, err)
+		return nil, err
+	}
+	cnx := newUdpConnection(conn, far)
+	cnx.syn, cnx.ack = c.probe(PUNCH_SYN), c.probe(PUNCH_ACK)
+	cnx.pending = pending
+	cnx.stats.setConnectLatency(time.Since(start))
+	finish(cnx, nil)
+	return cnx, nil
+}
+
+func (c *HolePunchConnector) probe(kind string) []byte {
+	return []byte(PUNCH_MAGIC + " " + kind + " " + c.session)
+}
+
+// Whether a and b are the same UDP end point, an unspecified IP in a
+// matching any.
+func sameUdpAddr(a *net.UDPAddr, b net.Addr) bool {
+	u, ok := b.(*net.UDPAddr)
+	return ok && u.Port == a.Port && (a.IP.IsUnspecified() || a.IP.Equal(u.IP))
+}
+
+// Send msg to dst every PUNCH_INTERVAL until handle reports that a
+// datagram received is the answer, or the deadline passes.
+func punchLoop(conn net.PacketConn, dst *net.UDPAddr, msg []byte,
+	deadline time.Time, handle func(from net.Addr, d []byte) bool) error {
+
+	buf := make([]byte, UDP_MAX_DATAGRAM)
+	for {
+		now := time.Now()
+		if !now.Before(deadline) {
+			return ConnectTimeout
+		}
+		if _, err := conn.WriteTo(msg, dst); err != nil {
+			return err
+		}
+		next := now.Add(PUNCH_INTERVAL)
+		if next.After(deadline) {
+			next = deadline
+		}
+		if err := conn.SetReadDeadline(next); err != nil {
+			return err
+		}
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			} else if err != nil {
+				return err
+			}
+			if handle(from, buf[:n]) {
+				return nil
+			}
+		}
+	}
+}
+
+// Ask the rendezvous server for the peer's public end point.
+func (c *HolePunchConnector) meet(conn net.PacketConn, deadline time.Time) (
+	far *net.UDPAddr, err error) {
+
+	req := []byte(RENDEZVOUS_MAGIC + " " + RENDEZVOUS_REGISTER + " " + c.session)
+	rv := c.rendezvous.udpAddr
+	err = punchLoop(conn, rv, req, deadline, func(from net.Addr, d []byte) bool {
+		words := strings.Fields(string(d))
+		if !sameUdpAddr(rv, from) || len(words) != 3 ||
+			words[0] != RENDEZVOUS_MAGIC || words[1] != RENDEZVOUS_PEER {
+			return false
+		}
+		a, aErr := net.ResolveUDPAddr("udp", words[2])
+		if aErr != nil {
+			return false
+		}
+		far = a
+		return true
+	})
+	return
+}
+
+// Probe the peer until the path is open both ways, returning any data
+// received from it meanwhile.
+func (c *HolePunchConnector) punch(conn net.PacketConn, far *net.UDPAddr,
+	deadline time.Time) (pending []byte, err error) {
+
+	syn, ack := c.probe(PUNCH_SYN), c.probe(PUNCH_ACK)
+	err = punchLoop(conn, far, syn, deadline, func(from net.Addr, d []byte) bool {
+		if !sameUdpAddr(far, from) {
+			return false
+		}
+		switch {
+		case bytes.Equal(d, syn):
+			conn.WriteTo(ack, far)
+			return false
+		case bytes.Equal(d, ack):
+			// the peer may have had none of our SYNs, and so have sent
+			// no ACK; it needs one to know its probes arrive
+			conn.WriteTo(ack, far)
+			return true
+		case bytes.HasPrefix(d, []byte(PUNCH_MAGIC+" ")):
+			// another session's probe
+			return false
+		}
+		pending = append([]byte{}, d...)
+		return true
+	})
+	return
+}
+
+// Return the rendezvous server's end point; the peer's is not known
+// until Connect has met it.
+func (c *HolePunchConnector) GetFarEnd() EndPointI {
+	return c.rendezvous
+}
+
+func (c *HolePunchConnector) String() string {
+	return "HolePunchConnector: " + c.session + " via " +
+		c.rendezvous.udpAddr.String()
+}
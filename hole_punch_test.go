@@ -0,0 +1,178 @@
+package transport
+
+// xlTransport_go/hole_punch_test.go
+
+import (
+	"errors"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A NAT in front of a host's UDP socket.  The host's datagrams leave
+// from the NAT's own socket, on another port, and datagrams come in
+// only from end points the host has sent to: a port-restricted cone.
+type natConn struct {
+	outside *net.UDPConn
+	inside  *net.UDPAddr // the address the host believes it has
+	mu      sync.Mutex
+	sentTo  map[string]bool
+	dropped int
+}
+
+func newNatConn(insidePort int) (*natConn, error) {
+	outside, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	return &natConn{
+		outside: outside,
+		inside:  &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: insidePort},
+		sentTo:  make(map[string]bool),
+	}, nil
+}
+
+func (n *natConn) public() *UdpEndPoint {
+	return &UdpEndPoint{n.outside.LocalAddr().(*net.UDPAddr)}
+}
+
+func (n *natConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		count, from, err := n.outside.ReadFromUDP(b)
+		if err != nil {
+			return count, from, err
+		}
+		n.mu.Lock()
+		ok := n.sentTo[from.String()]
+		if !ok {
+			n.dropped++
+		}
+		n.mu.Unlock()
+		if ok {
+			return count, from, nil
+		}
+	}
+}
+
+func (n *natConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n.mu.Lock()
+	n.sentTo[addr.String()] = true
+	n.mu.Unlock()
+	return n.outside.WriteTo(b, addr)
+}
+
+func (n *natConn) Close() error {
+	return n.outside.Close()
+}
+func (n *natConn) LocalAddr() net.Addr {
+	return n.inside
+}
+func (n *natConn) SetDeadline(t time.Time) error {
+	return n.outside.SetDeadline(t)
+}
+func (n *natConn) SetReadDeadline(t time.Time) error {
+	return n.outside.SetReadDeadline(t)
+}
+func (n *natConn) SetWriteDeadline(t time.Time) error {
+	return n.outside.SetWriteDeadline(t)
+}
+
+func (s *XLSuite) TestUdpEndPoint(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_UDP_END_POINT")
+	}
+	ep, err := NewUdpEndPoint("127.0.0.1:9000")
+	c.Assert(err, IsNil)
+	c.Assert(ep.String(), Equals, "127.0.0.1:9000")
+	c.Assert(ep.Serialize(), Equals, "UdpEndPoint: 127.0.0.1:9000")
+	var addr net.Addr = ep // usable wherever a net.UDPAddr is
+	c.Assert(addr.Network()+" "+addr.String(), Equals, "udp 127.0.0.1:9000")
+	ep2, err := ParseEndPoint(ep.Serialize())
+	c.Assert(err, IsNil)
+	c.Assert(ep2.Equal(ep), Equals, true)
+	ep3, err := ep.Clone()
+	c.Assert(err, IsNil)
+	c.Assert(ep3.Equal(ep), Equals, true)
+	other, _ := NewUdpEndPoint("127.0.0.1:9001")
+	c.Assert(other.Equal(ep), Equals, false)
+	tcpEp, _ := NewTcpEndPoint("127.0.0.1:9000")
+	c.Assert(ep.Equal(tcpEp), Equals, false)
+}
+
+func (s *XLSuite) TestHolePunch(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HOLE_PUNCH")
+	}
+	server, err := NewRendezvousServer("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Close()
+	rv := server.GetEndPoint()
+
+	_, err = NewHolePunchConnector(ANY_TCP_END_POINT, "xl")
+	c.Assert(err, Equals, NotUdpEndPoint)
+	_, err = NewHolePunchConnector(rv, "two words")
+	c.Assert(err, Equals, BadRendezvousSession)
+
+	natA, err := newNatConn(4000)
+	c.Assert(err, IsNil)
+	natB, err := newNatConn(4000)
+	c.Assert(err, IsNil)
+
+	// a NATed host cannot be reached by a peer it has not sent to
+	stranger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, IsNil)
+	defer stranger.Close()
+	_, err = stranger.WriteTo([]byte("hello?"), natA.public().GetUdpAddr())
+	c.Assert(err, IsNil)
+	natA.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = natA.ReadFrom(make([]byte, 16))
+	c.Assert(errors.Is(err, os.ErrDeadlineExceeded), Equals, true)
+	c.Assert(natA.dropped, Equals, 1)
+
+	// but two NATed hosts meeting at the rendezvous can reach each other
+	ctor, err := NewHolePunchConnector(rv, "xl-session")
+	c.Assert(err, IsNil)
+	c.Assert(ctor.String(), Equals, "HolePunchConnector: xl-session via "+
+		rv.(*UdpEndPoint).GetUdpAddr().String())
+	var cnxB *UdpConnection
+	var errB error
+	done := make(chan bool)
+	go func() {
+		cnxB, errB = ctor.ConnectOn(natB)
+		done <- true
+	}()
+	cnxA, err := ctor.ConnectOn(natA)
+	c.Assert(err, IsNil)
+	<-done
+	c.Assert(errB, IsNil)
+	defer cnxA.Close()
+	defer cnxB.Close()
+
+	c.Assert(cnxA.GetFarEnd().Equal(natB.public()), Equals, true)
+	c.Assert(cnxB.GetFarEnd().Equal(natA.public()), Equals, true)
+	inside, _ := NewUdpEndPoint("10.0.0.1:4000")
+	c.Assert(cnxA.GetNearEnd().Equal(inside), Equals, true)
+
+	buf := make([]byte, 64)
+	for _, pair := range [][2]*UdpConnection{{cnxA, cnxB}, {cnxB, cnxA}} {
+		msg := []byte("through the hole from " + pair[0].GetNearEnd().String())
+		_, err = pair[0].Write(msg)
+		c.Assert(err, IsNil)
+		n, err := pair[1].Read(buf)
+		c.Assert(err, IsNil)
+		c.Assert(buf[:n], DeepEquals, msg)
+	}
+	_, err = cnxA.Write(make([]byte, UDP_MAX_DATAGRAM+1))
+	c.Assert(errors.Is(err, DatagramTooLong), Equals, true)
+
+	// a peer which never turns up
+	lonely, err := NewHolePunchConnector(rv, "lonely")
+	c.Assert(err, IsNil)
+	lonely.SetTimeout(300 * time.Millisecond)
+	localhost, _ := NewUdpEndPoint("127.0.0.1:0")
+	_, err = lonely.Connect(localhost)
+	c.Assert(IsTimeout(err), Equals, true)
+}
@@ -0,0 +1,100 @@
+package transport
+
+// xlTransport_go/host_endpoint.go
+
+import (
+	"net"
+	"strconv"
+)
+
+// A TCP end point named by host name and port rather than by IP
+// address.  The name is not resolved here but by whatever carries the
+// connection, a SOCKS proxy for example, so that it may name a host
+// which only the far side of the proxy can see.
+
+type HostEndPoint struct {
+	host string
+	port int
+}
+
+// hostPort is a host name or IP address and a port, as in
+// "example.com:80".
+func NewHostEndPoint(hostPort string) (*HostEndPoint, error) {
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		return nil, EmptyAddrString
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, NotAnEndPoint
+	}
+	return &HostEndPoint{host, port}, nil
+}
+
+func (e *HostEndPoint) Address() AddressI {
+	return &HostAddress{net.JoinHostPort(e.host, strconv.Itoa(e.port))}
+}
+
+func (e *HostEndPoint) Clone() (EndPointI, error) {
+	return &HostEndPoint{e.host, e.port}, nil
+}
+
+func (e *HostEndPoint) Equal(any interface{}) bool {
+	other, ok := any.(*HostEndPoint)
+	return ok && other != nil && other.host == e.host && other.port == e.port
+}
+
+func (e *HostEndPoint) String() string {
+	return "HostEndPoint: " + e.Address().String()
+}
+
+func (e *HostEndPoint) Transport() string {
+	return "tcp"
+}
+
+func (e *HostEndPoint) GetHost() string {
+	return e.host
+}
+
+func (e *HostEndPoint) GetPort() int {
+	return e.port
+}
+
+// The host and port of an end point, as in "example.com:80" or
+// "[::1]:80".  TCP and UDP end points are read directly, since their
+// Address() is IPv4-only and nil for IPv6.
+func hostPort(ep EndPointI) string {
+	switch e := ep.(type) {
+	case *TcpEndPoint:
+		return e.GetTcpAddr().String()
+	case *UdpEndPoint:
+		return e.udpAddr.String()
+	}
+	if ep.Address() == nil {
+		return "?"
+	}
+	return ep.Address().String()
+}
+
+// HOST ADDRESS /////////////////////////////////////////////////////
+
+// A host name and port, as in "example.com:80".
+type HostAddress struct {
+	HostPort string
+}
+
+func (a *HostAddress) Clone() (AddressI, error) {
+	return &HostAddress{a.HostPort}, nil
+}
+
+func (a *HostAddress) Equal(any interface{}) bool {
+	other, ok := any.(*HostAddress)
+	return ok && other != nil && other.HostPort == a.HostPort
+}
+
+func (a *HostAddress) String() string {
+	return a.HostPort
+}
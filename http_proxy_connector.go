@@ -0,0 +1,183 @@
+package transport
+
+// xlTransport_go/http_proxy_connector.go
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"time"
+)
+
+// An HttpProxyConnector reaches its far end through a tunnel opened by
+// an HTTP proxy with the CONNECT method (RFC 9110, 9.3.6), for networks
+// where that is the only way out.  It may authenticate to the proxy
+// with Basic authentication and send it further headers.
+//
+// Once the tunnel is open the connection behaves as one made by a
+// TcpConnector, except that it reports the far end asked for rather
+// than the proxy.  A refusal by the proxy is returned as an
+// HttpProxyError, which IsRefused, IsTimeout and errors.Is with
+// ProxyAuthRequired recognize as appropriate.
+
+const (
+	// The most time the proxy may take to open the tunnel.
+	HTTP_PROXY_TIMEOUT = 30 * time.Second
+
+	// The longest response to CONNECT accepted.
+	MAX_HTTP_PROXY_RESPONSE = 16 * 1024
+)
+
+type HttpProxyConfig struct {
+	Username string // for Basic authentication, if not empty
+	Password string
+	Header   http.Header // sent with the CONNECT request
+}
+
+// A response to CONNECT other than 2xx.
+type HttpProxyError struct {
+	StatusCode int
+	Status     string // as in "407 Proxy Authentication Required"
+}
+
+func (e *HttpProxyError) Error() string {
+	return "http proxy: " + e.Status
+}
+
+// 407 unwraps to ProxyAuthRequired; 502 and 503, where the proxy could
+// not reach the far end, to ConnectionRefused; 504 to ConnectTimeout.
+func (e *HttpProxyError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusProxyAuthRequired:
+		return ProxyAuthRequired
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return ConnectionRefused
+	case http.StatusGatewayTimeout:
+		return ConnectTimeout
+	}
+	return nil
+}
+
+type HttpProxyConnector struct {
+	proxy  *TcpConnector
+	farEnd EndPointI // a *TcpEndPoint or *HostEndPoint
+	cfg    HttpProxyConfig
+}
+
+// Connect to farEnd through the HTTP proxy at proxy.  cfg may be nil.
+// Socket options are applied to the connection to the proxy.
+func NewHttpProxyConnector(proxy, farEnd EndPointI, cfg *HttpProxyConfig,
+	opts ...*SocketOptions) (*HttpProxyConnector, error) {
+
+	proxyCtor, err := NewTcpConnector(proxy, opts...)
+	if err != nil {
+		return nil, err
+	}
+	switch farEnd.(type) {
+	case *TcpEndPoint, *HostEndPoint:
+	default:
+		return nil, NotTcpEndPoint
+	}
+	ep2, _ := farEnd.Clone()
+	c := &HttpProxyConnector{proxy: proxyCtor, farEnd: ep2}
+	if cfg != nil {
+		c.cfg = HttpProxyConfig{cfg.Username, cfg.Password, cfg.Header.Clone()}
+	}
+	return c, nil
+}
+
+// Connect to the proxy, binding to nearEnd if it is not nil, and ask
+// it for a tunnel to the far end.  The dial is counted and observed as
+// succeeding only once the proxy has opened the tunnel.
+func (c *HttpProxyConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
+	if _, ok := nearEnd.(*TcpEndPoint); nearEnd != nil && !ok {
+		return nil, NotTcpEndPoint
+	}
+	finish := observeDial(nearEnd, c.farEnd)
+	start := time.Now()
+	conn, err := c.proxy.dial(nearEnd)
+	if err == nil {
+		conn.SetDeadline(time.Now().Add(HTTP_PROXY_TIMEOUT))
+		err = c.handshake(conn)
+		if dErr := conn.SetDeadline(time.Time{}); err == nil {
+			err = dErr
+		}
+		if err != nil {
+			nearEnd, _ = NewTcpEndPoint(conn.LocalAddr().String())
+			conn.Close()
+		}
+	}
+	DefaultMetrics.transport("http-connect").countDial(err)
+	if err != nil {
+		err = wrapError("dial", "http-connect", nearEnd, c.farEnd, err)
+		finish(nil, err)
+		return nil, err
+	}
+	tcpCnx, _ := NewTcpConnection(conn)
+	tcpCnx.farEnd = c.farEnd
+	tcpCnx.stats.setConnectLatency(time.Since(start))
+	finish(tcpCnx, nil)
+	return tcpCnx, nil
+}
+
+func (c *HttpProxyConnector) handshake(rw io.ReadWriter) (err error) {
+	target := hostPort(c.farEnd)
+	var req bytes.Buffer
+	req.WriteString("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n")
+	if c.cfg.Username != "" {
+		creds := base64.StdEncoding.EncodeToString(
+			[]byte(c.cfg.Username + ":" + c.cfg.Password))
+		req.WriteString("Proxy-Authorization: Basic " + creds + "\r\n")
+	}
+	if err = c.cfg.Header.Write(&req); err != nil {
+		return
+	}
+	req.WriteString("\r\n")
+	if _, err = rw.Write(req.Bytes()); err != nil {
+		return
+	}
+
+	// read the response a byte at a time, so as not to consume anything
+	// the far end sends once the tunnel is open
+	var resp []byte
+	var b [1]byte
+	for !bytes.HasSuffix(resp, []byte("\r\n\r\n")) {
+		if len(resp) == MAX_HTTP_PROXY_RESPONSE {
+			return BadRecord
+		}
+		if _, err = io.ReadFull(rw, b[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		resp = append(resp, b[0])
+	}
+	r, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)),
+		&http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return BadRecord
+	}
+	r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return &HttpProxyError{r.StatusCode, r.Status}
+	}
+	return
+}
+
+// Return the end point the proxy is asked to connect to.
+func (c *HttpProxyConnector) GetFarEnd() EndPointI {
+	return c.farEnd
+}
+
+// Return the proxy's end point.
+func (c *HttpProxyConnector) GetProxy() EndPointI {
+	return c.proxy.GetFarEnd()
+}
+
+func (c *HttpProxyConnector) String() string {
+	return "HttpProxyConnector: " + hostPort(c.farEnd) + " via " +
+		hostPort(c.proxy.GetFarEnd())
+}
@@ -0,0 +1,165 @@
+package transport
+
+// xlTransport_go/http_proxy_connector_test.go
+
+import (
+	"errors"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+)
+
+// An HTTP proxy which handles only CONNECT, requiring Basic
+// authentication as node:secret and an X-Node-ID header.  Host names
+// are resolved from hosts alone.
+func newConnectProxy(hosts map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodConnect {
+				http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+				return
+			}
+			user, pass, ok := parseProxyAuth(r)
+			if !ok || user != "node" || pass != "secret" {
+				w.Header().Set("Proxy-Authenticate", `Basic realm="test"`)
+				w.WriteHeader(http.StatusProxyAuthRequired)
+				return
+			}
+			if r.Header.Get("X-Node-ID") == "" {
+				http.Error(w, "who are you?", http.StatusForbidden)
+				return
+			}
+			host, port, _ := net.SplitHostPort(r.Host)
+			if h, ok := hosts[host]; ok {
+				host = h
+			}
+			target, err := net.Dial("tcp", net.JoinHostPort(host, port))
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer target.Close()
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n")
+			rw.Flush()
+			go func() {
+				io.Copy(target, rw)
+				target.(*net.TCPConn).CloseWrite()
+			}()
+			io.Copy(conn, target)
+		}))
+}
+
+func parseProxyAuth(r *http.Request) (user, pass string, ok bool) {
+	// the same form as Authorization, under another name
+	r2 := &http.Request{Header: http.Header{
+		"Authorization": r.Header["Proxy-Authorization"]}}
+	return r2.BasicAuth()
+}
+
+func (s *XLSuite) TestHttpProxyConnector(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_HTTP_PROXY_CONNECTOR")
+	}
+	// the far end greets each client and then echoes
+	acc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	go func() {
+		for {
+			cnx, err := acc.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				cnx.Write([]byte("hello"))
+				echo(cnx)
+				cnx.Close()
+			}()
+		}
+	}()
+	target := acc.GetEndPoint()
+	port := strconv.Itoa(target.(*TcpEndPoint).GetTcpAddr().Port)
+
+	srv := newConnectProxy(map[string]string{"echo.internal": "127.0.0.1"})
+	defer srv.Close()
+	proxy, err := NewTcpEndPoint(srv.Listener.Addr().String())
+	c.Assert(err, IsNil)
+	cfg := &HttpProxyConfig{Username: "node", Password: "secret",
+		Header: http.Header{"X-Node-Id": {"42"}}}
+
+	named, _ := NewHostEndPoint("echo.internal:" + port)
+	for _, farEnd := range []EndPointI{target, named} {
+		ctor, err := NewHttpProxyConnector(proxy, farEnd, cfg)
+		c.Assert(err, IsNil)
+		c.Assert(ctor.String(), Equals, "HttpProxyConnector: "+
+			hostPort(farEnd)+" via "+srv.Listener.Addr().String())
+		cnx, err := ctor.Connect(nil)
+		c.Assert(err, IsNil)
+		c.Assert(cnx.GetFarEnd().Equal(farEnd), Equals, true)
+		// the greeting, sent as soon as the tunnel opened, is not lost
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(cnx, buf)
+		c.Assert(err, IsNil)
+		c.Assert(string(buf), Equals, "hello")
+		msg := "through the tunnel"
+		_, err = cnx.Write([]byte(msg))
+		c.Assert(err, IsNil)
+		buf = make([]byte, len(msg))
+		_, err = io.ReadFull(cnx, buf)
+		c.Assert(err, IsNil)
+		c.Assert(string(buf), Equals, msg)
+		cnx.Close()
+	}
+
+	// the proxy's refusals are mapped to transport errors
+	expectStatus := func(farEnd EndPointI, cfg *HttpProxyConfig, status int) error {
+		ctor, err := NewHttpProxyConnector(proxy, farEnd, cfg)
+		c.Assert(err, IsNil)
+		_, err = ctor.Connect(nil)
+		var pe *HttpProxyError
+		c.Assert(errors.As(err, &pe), Equals, true)
+		c.Assert(pe.StatusCode, Equals, status)
+		var te *TransportError
+		c.Assert(errors.As(err, &te), Equals, true)
+		c.Assert(te.Transport, Equals, "http-connect")
+		return err
+	}
+	// each counted and observed as a failed dial and nothing else
+	log := &eventLog{}
+	prev := SetObserver(log)
+	tcpOK, _ := dialCounts("tcp")
+	httpOK, httpFailed := dialCounts("http-connect")
+	err = expectStatus(target, nil, http.StatusProxyAuthRequired)
+	c.Assert(errors.Is(err, ProxyAuthRequired), Equals, true)
+	err = expectStatus(target, &HttpProxyConfig{Username: "node",
+		Password: "wrong"}, http.StatusProxyAuthRequired)
+	c.Assert(errors.Is(err, ProxyAuthRequired), Equals, true)
+	SetObserver(prev)
+	c.Assert(dialFinishErrs(log), DeepEquals, []bool{true, true})
+	ok, _ := dialCounts("tcp")
+	c.Assert(ok, Equals, tcpOK)
+	ok, failed := dialCounts("http-connect")
+	c.Assert(ok, Equals, httpOK)
+	c.Assert(failed, Equals, httpFailed+2)
+	err = expectStatus(target, &HttpProxyConfig{Username: "node",
+		Password: "secret"}, http.StatusForbidden)
+	c.Assert(IsTemporary(err), Equals, false)
+
+	closed, _ := NewTcpEndPoint(target.(*TcpEndPoint).GetTcpAddr().String())
+	acc.Close()
+	err = expectStatus(closed, cfg, http.StatusBadGateway)
+	c.Assert(IsRefused(err), Equals, true)
+
+	unixEp, _ := NewUnixEndPoint("/run/node.sock")
+	_, err = NewHttpProxyConnector(proxy, unixEp, cfg)
+	c.Assert(err, Equals, NotTcpEndPoint)
+}
@@ -0,0 +1,235 @@
+package transport
+
+// xlTransport_go/metrics.go
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Library-wide metrics, rendered in the Prometheus text exposition
+// format.  Every transport reports to DefaultMetrics, which an
+// application exposes by registering it as an http.Handler:
+//
+//     http.Handle("/metrics", transport.DefaultMetrics)
+//
+// The metrics exported are
+//     xlattice_transport_active_connections{transport}      gauge
+//     xlattice_transport_dials_total{transport,result}      counter
+//     xlattice_transport_accepts_total{transport}           counter
+//     xlattice_transport_bytes_total{transport,direction}   counter
+//     xlattice_transport_handshake_duration_seconds{handshake}  histogram
+
+const (
+	METRICS_PREFIX       = "xlattice_transport_"
+	METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Upper bounds, in seconds, of the handshake duration histogram buckets.
+var HANDSHAKE_BUCKETS = []float64{
+	.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var DefaultMetrics = NewMetrics()
+
+type Metrics struct {
+	mu         sync.Mutex
+	transports map[string]*transportMetrics
+	handshakes map[string]*histogram
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		transports: make(map[string]*transportMetrics),
+		handshakes: make(map[string]*histogram),
+	}
+}
+
+// Counters for one transport, updated atomically.
+type transportMetrics struct {
+	active                      int64
+	dialSuccesses, dialFailures uint64
+	accepts                     uint64
+	bytesRead, bytesWritten     uint64
+}
+
+// Return the counters for the named transport, creating them if need be.
+func (m *Metrics) transport(name string) *transportMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tm, ok := m.transports[name]
+	if !ok {
+		tm = &transportMetrics{}
+		m.transports[name] = tm
+	}
+	return tm
+}
+
+func (tm *transportMetrics) countDial(err error) {
+	if err == nil {
+		atomic.AddUint64(&tm.dialSuccesses, 1)
+	} else {
+		atomic.AddUint64(&tm.dialFailures, 1)
+	}
+}
+
+// Record how long a handshake of the named kind took to complete.
+func (m *Metrics) observeHandshake(kind string, d time.Duration) {
+	m.mu.Lock()
+	h, ok := m.handshakes[kind]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(HANDSHAKE_BUCKETS))}
+		m.handshakes[kind] = h
+	}
+	h.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+// Start timing a handshake of the named kind over cnx.  The function
+// returned records its duration if *err is then nil and reports the
+// outcome to the observer; use it as
+//
+//	defer timeHandshake("kind", cnx)(&err)
+func timeHandshake(kind string, cnx ConnectionI) func(err *error) {
+	start := time.Now()
+	return func(err *error) {
+		d := time.Since(start)
+		if *err == nil {
+			DefaultMetrics.observeHandshake(kind, d)
+		}
+		if cnx != nil && getObserver() != nil {
+			observe(&Event{Kind: EV_HANDSHAKE, Near: cnx.GetNearEnd(),
+				Far: cnx.GetFarEnd(), Duration: d, Op: kind, Err: *err})
+		}
+	}
+}
+
+// A cumulative histogram over HANDSHAKE_BUCKETS, guarded by Metrics.mu.
+type histogram struct {
+	counts []uint64 // observations <= the corresponding bucket bound
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range HANDSHAKE_BUCKETS {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// RENDERING ////////////////////////////////////////////////////////
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Write all metrics to w in the Prometheus text exposition format.
+// Series are sorted by label so that the output is stable.
+func (m *Metrics) WriteTo(w io.Writer) (n int64, err error) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.transports))
+	for name := range m.transports {
+		names = append(names, name)
+	}
+	tms := make([]*transportMetrics, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		tms = append(tms, m.transports[name])
+	}
+	kinds := make([]string, 0, len(m.handshakes))
+	for kind := range m.handshakes {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	hs := make([]histogram, len(kinds))
+	for i, kind := range kinds {
+		h := m.handshakes[kind]
+		hs[i] = histogram{append([]uint64(nil), h.counts...), h.count, h.sum}
+	}
+	m.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	header := func(name, kind, help string) {
+		fmt.Fprintf(cw, "# HELP %s%s %s\n# TYPE %s%s %s\n",
+			METRICS_PREFIX, name, help, METRICS_PREFIX, name, kind)
+	}
+	header("active_connections", "gauge", "Connections currently open.")
+	for i, name := range names {
+		fmt.Fprintf(cw, "%sactive_connections{transport=%q} %d\n",
+			METRICS_PREFIX, name, atomic.LoadInt64(&tms[i].active))
+	}
+	header("dials_total", "counter", "Outgoing connection attempts.")
+	for i, name := range names {
+		fmt.Fprintf(cw, "%sdials_total{transport=%q,result=\"success\"} %d\n",
+			METRICS_PREFIX, name, atomic.LoadUint64(&tms[i].dialSuccesses))
+		fmt.Fprintf(cw, "%sdials_total{transport=%q,result=\"failure\"} %d\n",
+			METRICS_PREFIX, name, atomic.LoadUint64(&tms[i].dialFailures))
+	}
+	header("accepts_total", "counter", "Incoming connections accepted.")
+	for i, name := range names {
+		fmt.Fprintf(cw, "%saccepts_total{transport=%q} %d\n",
+			METRICS_PREFIX, name, atomic.LoadUint64(&tms[i].accepts))
+	}
+	header("bytes_total", "counter", "Bytes transferred.")
+	for i, name := range names {
+		fmt.Fprintf(cw, "%sbytes_total{transport=%q,direction=\"read\"} %d\n",
+			METRICS_PREFIX, name, atomic.LoadUint64(&tms[i].bytesRead))
+		fmt.Fprintf(cw, "%sbytes_total{transport=%q,direction=\"write\"} %d\n",
+			METRICS_PREFIX, name, atomic.LoadUint64(&tms[i].bytesWritten))
+	}
+	header("handshake_duration_seconds", "histogram",
+		"Time taken by completed handshakes.")
+	for i, kind := range kinds {
+		h := hs[i]
+		for j, bound := range HANDSHAKE_BUCKETS {
+			fmt.Fprintf(cw,
+				"%shandshake_duration_seconds_bucket{handshake=%q,le=%q} %d\n",
+				METRICS_PREFIX, kind, formatFloat(bound), h.counts[j])
+		}
+		fmt.Fprintf(cw,
+			"%shandshake_duration_seconds_bucket{handshake=%q,le=\"+Inf\"} %d\n",
+			METRICS_PREFIX, kind, h.count)
+		fmt.Fprintf(cw, "%shandshake_duration_seconds_sum{handshake=%q} %s\n",
+			METRICS_PREFIX, kind, formatFloat(h.sum))
+		fmt.Fprintf(cw, "%shandshake_duration_seconds_count{handshake=%q} %d\n",
+			METRICS_PREFIX, kind, h.count)
+	}
+	if err = cw.err; err == nil {
+		err = bw.Flush()
+	}
+	return cw.n, err
+}
+
+// Serve the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	m.WriteTo(w)
+}
+
+// Counts bytes written and remembers the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) Write(b []byte) (n int, err error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err = cw.w.Write(b)
+	cw.n += int64(n)
+	cw.err = err
+	return
+}
@@ -0,0 +1,67 @@
+package transport
+
+// xlTransport_go/metrics_test.go
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"net/http/httptest"
+	"strings"
+)
+
+func (s *XLSuite) TestMetrics(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_METRICS")
+	}
+	saved := DefaultMetrics
+	DefaultMetrics = NewMetrics()
+	defer func() { DefaultMetrics = saved }()
+
+	// one TCP connection with a preamble exchanged over it, one
+	// refused mock dial
+	client, server := s.makeTcpPair(c)
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := ExchangePreamble(server, SUPPORTED_CAPS, 0)
+		done <- err
+	}()
+	_, _, err := ExchangePreamble(client, SUPPORTED_CAPS, 0)
+	c.Assert(err, IsNil)
+	c.Assert(<-done, IsNil)
+	sent := client.Stats().BytesWritten
+	c.Assert(client.Close(), IsNil)
+
+	net := NewMockNetwork()
+	ctor, err := net.NewMockConnector(NewMockEndPoint("T", "nobody"))
+	c.Assert(err, IsNil)
+	_, err = ctor.Connect(nil)
+	c.Assert(err, Equals, ConnectionRefused)
+
+	rec := httptest.NewRecorder()
+	DefaultMetrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	c.Assert(rec.Header().Get("Content-Type"), Equals, METRICS_CONTENT_TYPE)
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		lines[line] = true
+	}
+	for _, want := range []string{
+		"# TYPE xlattice_transport_active_connections gauge",
+		`xlattice_transport_active_connections{transport="tcp"} 1`,
+		`xlattice_transport_dials_total{transport="tcp",result="success"} 1`,
+		`xlattice_transport_dials_total{transport="mock",result="failure"} 1`,
+		`xlattice_transport_accepts_total{transport="tcp"} 1`,
+		fmt.Sprintf(`xlattice_transport_bytes_total{transport="tcp",direction="write"} %d`,
+			2*sent),
+		"# TYPE xlattice_transport_handshake_duration_seconds histogram",
+		`xlattice_transport_handshake_duration_seconds_bucket{handshake="preamble",le="+Inf"} 2`,
+		`xlattice_transport_handshake_duration_seconds_count{handshake="preamble"} 2`,
+	} {
+		c.Assert(lines[want], Equals, true, Commentf("missing %q", want))
+	}
+	server.Close()
+	var buf strings.Builder
+	_, err = DefaultMetrics.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(buf.String(),
+		`xlattice_transport_active_connections{transport="tcp"} 0`), Equals, true)
+}
@@ -1,42 +1,96 @@
 package transport
 
 import (
-	"bytes"
 	"fmt"
 	xc "github.com/jddixon/xlCrypto_go"
+	"io"
 	"sync"
+	"time"
 )
 
+// One direction of a MockConnection: a queue of messages written at one
+// end and not yet read at the other.  Each message becomes readable
+// once the queue's latency has passed on the queue's clock.  Readers
+// block until a message is readable or the queue is closed.
+type mockQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	msgs    []mockMsg
+	closed  bool
+	clock   ClockI
+	latency time.Duration
+}
+
+type mockMsg struct {
+	data []byte
+	at   time.Time // when the message can be read
+}
+
+func newMockQueue(clock ClockI, latency time.Duration) *mockQueue {
+	q := &mockQueue{
+		msgs:    make([]mockMsg, 0, 8),
+		clock:   clock,
+		latency: latency,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Wake any blocked readers after d has passed on the queue's clock.
+func (q *mockQueue) wakeAfter(d time.Duration) TimerI {
+	return q.clock.AfterFunc(d, func() {
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	})
+}
+
+func (q *mockQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
 type MockConnection struct {
 	State           int
 	NearEnd, FarEnd *MockEndPoint
-	a2bMsg, b2aMsg  *[][]byte
-	a2bMu, b2aMu    sync.Mutex
+	a2bMsg, b2aMsg  *mockQueue
+	readTimeout     time.Duration
+	stats           *connCounters // for this end only
 }
 
 func NewNewMockConnection() (cnx *MockConnection, err error) {
 	cnx = &MockConnection{
 		State: CNX_UNBOUND,
+		stats: &connCounters{},
 	}
 	return
 }
 func NewMockConnection(nearEnd, farEnd *MockEndPoint) (
 	cnx *MockConnection, err error) {
 
+	return newMockConnection(nearEnd, farEnd, RealClock{}, 0)
+}
+
+// Create a connection whose traffic takes latency to arrive, as
+// measured by clock.
+func newMockConnection(nearEnd, farEnd *MockEndPoint, clock ClockI,
+	latency time.Duration) (cnx *MockConnection, err error) {
+
 	if nearEnd == nil || farEnd == nil {
 		err = NilEndPoint
 	} else {
-		// XXX attempt to make things work by assigning a capacity
-		p := make([][]byte, 0, 8)
-		q := make([][]byte, 0, 8)
 		cnx = &MockConnection{
 			NearEnd: nearEnd,
 			FarEnd:  farEnd,
 			State:   CNX_CONNECTED,
 
-			a2bMsg: &p,
-			b2aMsg: &q,
+			a2bMsg: newMockQueue(clock, latency),
+			b2aMsg: newMockQueue(clock, latency),
+			stats:  &connCounters{},
 		}
+		cnx.stats.init(cnx, "mock", clock)
 	}
 	return
 }
@@ -57,9 +111,10 @@ func NewReverseMockConnection(orig *MockConnection) (
 
 			a2bMsg: orig.b2aMsg,
 			b2aMsg: orig.a2bMsg,
-
-			a2bMu: orig.b2aMu,
-			b2aMu: orig.a2bMu,
+			stats:  &connCounters{},
+		}
+		if orig.a2bMsg != nil {
+			cnx.stats.init(cnx, "mock", orig.a2bMsg.clock)
 		}
 	}
 	return
@@ -82,6 +137,7 @@ func (c *MockConnection) BindNearEnd(e EndPointI) (err error) {
 		switch v := e.(type) {
 		case *MockEndPoint:
 			c.NearEnd = v
+			observeState(c, c.State, CNX_BOUND)
 			c.State = CNX_BOUND
 		default:
 			err = NotAMockEndPoint
@@ -109,7 +165,9 @@ func (c *MockConnection) BindFarEnd(e EndPointI) (err error) {
 		switch v := e.(type) {
 		case *MockEndPoint:
 			c.FarEnd = v
+			observeState(c, c.State, CNX_CONNECTED)
 			c.State = CNX_CONNECTED
+			c.stats.init(c, "mock", RealClock{})
 		default:
 			err = NotAMockEndPoint
 		}
@@ -121,61 +179,132 @@ func (c *MockConnection) BindFarEnd(e EndPointI) (err error) {
 //
 // XXX This code allows you to close an UNBOUND or BOUND connection.
 //
+// Closing either end causes reads at the other end to return io.EOF
+// once any messages already written have been read.
+//
 func (c *MockConnection) Close() (err error) {
+	observeState(c, c.State, CNX_DISCONNECTED)
 	c.State = CNX_DISCONNECTED
+	if c.a2bMsg != nil {
+		c.a2bMsg.close()
+		c.b2aMsg.close()
+	}
+	c.stats.countClose()
 	return
 }
 
 func (c *MockConnection) GetNearEnd() (ep EndPointI) {
-	return c.NearEnd
+	if c.NearEnd != nil {
+		ep = c.NearEnd
+	}
+	return
 }
 
 // XXX 2013-07-20: this returns the near end instead !
 func (c *MockConnection) GetFarEnd() (ep EndPointI) {
-	return c.FarEnd
+	if c.FarEnd != nil {
+		ep = c.FarEnd
+	}
+	return
 }
 
 // Read from the connection.  In this implementation we have a queue of
 // incoming messages, each a byte slice.  If it will fit, we read all of
 // the first message into the output buffer b.  Otherwise, we read what
-// will fit and leave the rest of the first message on the queue.
+// will fit and leave the rest of the first message on the queue.  If
+// the queue is empty, Read blocks until a message arrives or either end
+// of the connection is closed.  If a read timeout has been set, Read
+// returns ReadTimeout if nothing arrives in that time.
 //
 func (c *MockConnection) Read(b []byte) (count int, err error) {
+	if c.b2aMsg == nil {
+		return 0, NotBound
+	}
+	defer func() { c.stats.countRead(count, err) }()
+	q := c.b2aMsg
+	q.mu.Lock()
+	defer q.mu.Unlock()
 
-	if len(*c.b2aMsg) == 0 {
-		count = 0
-	} else {
-		lenB := len(b) // how many bytes we can return
-		lenMsg := len((*c.b2aMsg)[0])
-		if lenB >= lenMsg {
-			buf := bytes.NewBuffer(b)
-			c.b2aMu.Lock()
-			count, err = buf.Write((*c.b2aMsg)[0])
-			*c.b2aMsg = (*c.b2aMsg)[1:]
-			c.b2aMu.Unlock()
-		} else {
-			// send what we can
-			buf := bytes.NewBuffer(b)
-			c.b2aMu.Lock()
-			count, err = buf.Write((*c.b2aMsg)[0][0:lenB])
-			(*c.b2aMsg)[0] = (*c.b2aMsg)[0][count:]
-			c.b2aMu.Unlock()
+	timedOut := false
+	if c.readTimeout > 0 {
+		timer := q.clock.AfterFunc(c.readTimeout, func() {
+			q.mu.Lock()
+			timedOut = true
+			q.cond.Broadcast()
+			q.mu.Unlock()
+		})
+		defer timer.Stop()
+	}
+	var (
+		wake   TimerI    // wakes us when the next message is readable
+		wakeAt time.Time // when it is scheduled for
+	)
+	defer func() {
+		if wake != nil {
+			wake.Stop()
+		}
+	}()
+	for {
+		if len(q.msgs) > 0 {
+			head := q.msgs[0]
+			now := q.clock.Now()
+			if !head.at.After(now) {
+				count = copy(b, head.data)
+				if count == len(head.data) {
+					q.msgs = q.msgs[1:]
+				} else {
+					// leave the rest of the message on the queue
+					q.msgs[0].data = head.data[count:]
+				}
+				return
+			}
+			if wake == nil || !wakeAt.Equal(head.at) {
+				if wake != nil {
+					wake.Stop()
+				}
+				wake, wakeAt = q.wakeAfter(head.at.Sub(now)), head.at
+			}
+		} else if q.closed {
+			return 0, io.EOF
 		}
+		if timedOut {
+			return 0, ReadTimeout
+		}
+		q.cond.Wait()
 	}
-	return
+}
+
+// Set how long a Read may wait for data, as measured by the
+// connection's clock.  Zero, the default, means forever.
+func (c *MockConnection) SetReadTimeout(d time.Duration) {
+	c.readTimeout = d
 }
 
 // Write msg b to the connection.  In this implementation we maintain
-// a queue of output messages.  We will simply append this message to
-// that queue, making no change to the message.
+// a queue of output messages.  We will simply append a copy of this
+// message to that queue, making no change to the message.
 //
 func (c *MockConnection) Write(b []byte) (count int, err error) {
-	count = len(b)
-	c.a2bMu.Lock()
-	*c.a2bMsg = append(*c.a2bMsg, b)
-	c.a2bMu.Unlock()
+	if c.a2bMsg == nil {
+		return 0, NotBound
+	}
+	defer func() { c.stats.countWrite(count, err) }()
+	q := c.a2bMsg
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		err = ClosedConnection
+	} else {
+		msg := make([]byte, len(b))
+		count = copy(msg, b)
+		q.msgs = append(q.msgs, mockMsg{msg, q.clock.Now().Add(q.latency)})
+		q.cond.Broadcast()
+	}
 	return
 }
+func (c *MockConnection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
 func (c *MockConnection) IsBlocking() bool {
 	// XXX STUB NotImplemented
 	return false
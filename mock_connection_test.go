@@ -46,6 +46,9 @@ func (s *XLSuite) TestMockConnection(c *C) {
 	msg1 := make([]byte, msg1Len)
 	msg2 := make([]byte, msg2Len)
 	msg3 := make([]byte, msg3Len)
+	rng.NextBytes(msg1)
+	rng.NextBytes(msg2)
+	rng.NextBytes(msg3)
 
 	// the client writes three messages -----------------------------
 	count, err := clientCnx.Write(msg1)
@@ -68,17 +71,17 @@ func (s *XLSuite) TestMockConnection(c *C) {
 	count, err = serverCnx.Read(sBuf1)
 	c.Assert(err, IsNil)
 	c.Assert(count, Equals, msg1Len)
-	bytes.Equal(msg1, sBuf1)
+	c.Assert(bytes.Equal(msg1, sBuf1), Equals, true)
 
 	count, err = serverCnx.Read(sBuf2)
 	c.Assert(err, IsNil)
 	c.Assert(count, Equals, msg2Len)
-	bytes.Equal(msg2, sBuf2)
+	c.Assert(bytes.Equal(msg2, sBuf2), Equals, true)
 
 	count, err = serverCnx.Read(sBuf3)
 	c.Assert(err, IsNil)
 	c.Assert(count, Equals, msg3Len)
-	bytes.Equal(msg3, sBuf3)
+	c.Assert(bytes.Equal(msg3, sBuf3), Equals, true)
 
 	// the server echoes the three messages back -----------------------
 	count, err = serverCnx.Write(sBuf1)
@@ -101,16 +104,16 @@ func (s *XLSuite) TestMockConnection(c *C) {
 	count, err = clientCnx.Read(cBuf1)
 	c.Assert(err, IsNil)
 	c.Assert(count, Equals, msg1Len)
-	bytes.Equal(msg1, cBuf1)
+	c.Assert(bytes.Equal(msg1, cBuf1), Equals, true)
 
 	count, err = clientCnx.Read(cBuf2)
 	c.Assert(err, IsNil)
 	c.Assert(count, Equals, msg2Len)
-	bytes.Equal(msg2, cBuf2)
+	c.Assert(bytes.Equal(msg2, cBuf2), Equals, true)
 
 	count, err = clientCnx.Read(cBuf3)
 	c.Assert(err, IsNil)
 	c.Assert(count, Equals, msg3Len)
-	bytes.Equal(msg3, cBuf2)
+	c.Assert(bytes.Equal(msg3, cBuf3), Equals, true)
 
 }
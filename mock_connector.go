@@ -4,9 +4,12 @@ package transport
 
 import ()
 
-//
+// A MockConnector made by a MockNetwork connects to the MockAcceptor
+// listening at its far end.  One made by NewMockConnector has no
+// network and simply fabricates a connection.
 type MockConnector struct {
-	FarEnd *MockEndPoint
+	FarEnd  *MockEndPoint
+	network *MockNetwork
 }
 
 func NewMockConnector(farEnd EndPointI) (ctor *MockConnector, err error) {
@@ -21,7 +24,7 @@ func NewMockConnector(farEnd EndPointI) (ctor *MockConnector, err error) {
 	// copy the far end
 	ep2, err := mockFarEnd.Clone()
 	if err == nil {
-		ctor = &MockConnector{ep2.(*MockEndPoint)}
+		ctor = &MockConnector{FarEnd: ep2.(*MockEndPoint)}
 	}
 	return
 }
@@ -37,15 +40,28 @@ func (c *MockConnector) Connect(nearEnd EndPointI) (
 
 	var (
 		mockNearEnd *MockEndPoint
+		mockCnx     *MockConnection
 	)
-	if nearEnd == nil {
-		mockNearEnd = NewMockEndPoint("T", "A").(*MockEndPoint)
+	if nearEnd != nil {
+		var ok bool
+		if mockNearEnd, ok = nearEnd.(*MockEndPoint); !ok {
+			return nil, NotMockEndPoint
+		}
+	}
+	finish := observeDial(nearEnd, c.FarEnd)
+	if c.network != nil {
+		mockCnx, err = c.network.connect(mockNearEnd, c.FarEnd)
 	} else {
-		mockNearEnd = nearEnd.(*MockEndPoint)
+		if mockNearEnd == nil {
+			mockNearEnd = NewMockEndPoint("T", "A").(*MockEndPoint)
+		}
+		mockCnx, err = NewMockConnection(mockNearEnd, c.FarEnd)
 	}
+	DefaultMetrics.transport("mock").countDial(err)
 	if err == nil {
-		cnx, err = NewMockConnection(mockNearEnd, c.FarEnd)
+		cnx = mockCnx
 	}
+	finish(cnx, err)
 	return
 }
 
@@ -34,8 +34,8 @@ func (m *MockEndPoint) Equal(any interface{}) bool {
 		return false
 	}
 	other := any.(*MockEndPoint)
-	return other.T == other.T &&
-		other.Addr.String() == other.Addr.String()
+	return m.T == other.T &&
+		m.Addr.String() == other.Addr.String()
 }
 func (m *MockEndPoint) Address() AddressI {
 	return m.Addr
@@ -0,0 +1,210 @@
+package transport
+
+// xlTransport_go/mock_network.go
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A simulated network on which MockAcceptors listen at MockAddresses
+// and MockConnectors connect to them.  A connection made by a connector
+// is delivered, as seen from the other end, to the acceptor listening
+// at the connector's far end.  If nothing is listening there, or the
+// acceptor's backlog is full, the connection is refused.
+//
+// Traffic over the network takes a configurable latency to arrive, as
+// measured by the network's clock.  With a VirtualClock the network
+// runs in simulated time.
+
+const MOCK_BACKLOG = 16
+
+type MockNetwork struct {
+	mu        sync.Mutex
+	acceptors map[string]*MockAcceptor // keyed by address
+	nextPort  int                      // for ephemeral near ends
+	clock     ClockI
+	latency   time.Duration
+}
+
+func NewMockNetwork() *MockNetwork {
+	return &MockNetwork{
+		acceptors: make(map[string]*MockAcceptor),
+		clock:     RealClock{},
+	}
+}
+
+// Set the clock used by connections made from now on.
+func (n *MockNetwork) SetClock(clock ClockI) {
+	n.mu.Lock()
+	n.clock = clock
+	n.mu.Unlock()
+}
+
+func (n *MockNetwork) GetClock() ClockI {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.clock
+}
+
+// Set the one-way latency of connections made from now on.
+func (n *MockNetwork) SetLatency(latency time.Duration) {
+	n.mu.Lock()
+	n.latency = latency
+	n.mu.Unlock()
+}
+
+// Start listening at addr on this network.
+func (n *MockNetwork) NewMockAcceptor(transport, addr string) (
+	acc *MockAcceptor, err error) {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.acceptors[addr]; ok {
+		err = AddressInUse
+	} else {
+		acc = &MockAcceptor{
+			endPoint: NewMockEndPoint(transport, addr).(*MockEndPoint),
+			network:  n,
+			pending:  make(chan *MockConnection, MOCK_BACKLOG),
+			done:     make(chan struct{}),
+		}
+		n.acceptors[addr] = acc
+	}
+	return
+}
+
+// Create a connector which will connect over this network to farEnd.
+func (n *MockNetwork) NewMockConnector(farEnd EndPointI) (
+	ctor *MockConnector, err error) {
+
+	if ctor, err = NewMockConnector(farEnd); err == nil {
+		ctor.network = n
+	}
+	return
+}
+
+// Connect nearEnd to the acceptor listening at farEnd, returning the
+// connection as seen from the near end.
+func (n *MockNetwork) connect(nearEnd, farEnd *MockEndPoint) (
+	cnx *MockConnection, err error) {
+
+	n.mu.Lock()
+	acc := n.acceptors[farEnd.Addr.String()]
+	if nearEnd == nil {
+		n.nextPort++
+		nearEnd = NewMockEndPoint(farEnd.T,
+			fmt.Sprintf("ephemeral-%d", n.nextPort)).(*MockEndPoint)
+	}
+	clock, latency := n.clock, n.latency
+	n.mu.Unlock()
+
+	if acc == nil {
+		return nil, ConnectionRefused
+	}
+	if cnx, err = newMockConnection(nearEnd, farEnd, clock, latency); err == nil {
+		var reverse *MockConnection
+		reverse, _ = NewReverseMockConnection(cnx)
+		if !acc.deliver(reverse) {
+			// neither end was ever open
+			cnx.stats.countClose()
+			reverse.stats.countClose()
+			cnx, err = nil, ConnectionRefused
+		} else {
+			// a connection takes a round trip to establish
+			cnx.stats.setConnectLatency(2 * latency)
+		}
+	}
+	return
+}
+
+func (n *MockNetwork) remove(acc *MockAcceptor) {
+	n.mu.Lock()
+	addr := acc.endPoint.Addr.String()
+	if n.acceptors[addr] == acc {
+		delete(n.acceptors, addr)
+	}
+	n.mu.Unlock()
+}
+
+// An acceptor listening on a MockNetwork.
+type MockAcceptor struct {
+	endPoint  *MockEndPoint
+	network   *MockNetwork
+	pending   chan *MockConnection // the backlog
+	mu        sync.Mutex
+	closed    bool
+	done      chan struct{} // closed when the acceptor is
+	closeOnce sync.Once
+	stats     acceptorCounters
+}
+
+// Queue a newly made connection for Accept, returning false if the
+// acceptor is closed or its backlog full.
+func (a *MockAcceptor) deliver(cnx *MockConnection) (ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.closed {
+		select {
+		case a.pending <- cnx:
+			ok = true
+		default:
+			a.stats.countReject()
+		}
+	}
+	return
+}
+
+// Block until a connection arrives or the acceptor is closed.
+func (a *MockAcceptor) Accept() (cnx ConnectionI, err error) {
+	select {
+	case mc := <-a.pending:
+		a.stats.countAccept(mc.stats)
+		observeAccept(mc)
+		cnx = mc
+	case <-a.done:
+		err = AcceptorClosed
+	}
+	return
+}
+
+// Stop listening.  Connections still in the backlog are closed, so
+// that the far ends see them fail.
+func (a *MockAcceptor) Close() error {
+	a.closeOnce.Do(func() {
+		a.network.remove(a)
+		a.mu.Lock()
+		a.closed = true
+		close(a.done)
+		a.mu.Unlock()
+		for {
+			select {
+			case cnx := <-a.pending:
+				a.stats.countReject()
+				cnx.Close()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (a *MockAcceptor) IsClosed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closed
+}
+
+func (a *MockAcceptor) GetEndPoint() EndPointI {
+	return a.endPoint
+}
+
+func (a *MockAcceptor) Stats() AcceptorStats {
+	return a.stats.snapshot()
+}
+
+func (a *MockAcceptor) String() string {
+	return "MockAcceptor: " + a.endPoint.String()
+}
@@ -0,0 +1,121 @@
+package transport
+
+// xlTransport_go/mock_network_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"sync/atomic"
+)
+
+func (s *XLSuite) TestMockNetwork(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MOCK_NETWORK")
+	}
+	rng := xr.MakeSimpleRNG()
+	net := NewMockNetwork()
+
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	c.Assert(AcceptorI(acc).GetEndPoint().Address().String(), Equals, "server")
+
+	// a second acceptor may not listen at the same address
+	_, err = net.NewMockAcceptor("T", "server")
+	c.Assert(err, Equals, AddressInUse)
+
+	ctor, err := net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	client, err := ctor.Connect(NewMockEndPoint("T", "client"))
+	c.Assert(err, IsNil)
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+
+	// the acceptor sees the connection from the other end
+	c.Assert(server.GetNearEnd().Equal(client.GetFarEnd()), Equals, true)
+	c.Assert(server.GetFarEnd().Equal(client.GetNearEnd()), Equals, true)
+
+	// traffic flows both ways, the reader blocking until it arrives
+	msg := make([]byte, 32+rng.Intn(32))
+	rng.NextBytes(msg)
+	go func() {
+		buf := make([]byte, len(msg))
+		io.ReadFull(server, buf)
+		server.Write(buf)
+		server.Close()
+	}()
+	count, err := client.Write(msg)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, len(msg))
+	reply := make([]byte, len(msg))
+	_, err = io.ReadFull(client, reply)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(msg, reply), Equals, true)
+
+	// once the server has closed, the client reads EOF
+	_, err = client.Read(reply)
+	c.Assert(err, Equals, io.EOF)
+	client.Close()
+
+	// connections with no near end are given ephemeral addresses
+	cnx1, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	cnx2, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	c.Assert(cnx1.GetNearEnd().Equal(cnx2.GetNearEnd()), Equals, false)
+}
+
+func (s *XLSuite) TestMockNetworkRefused(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MOCK_NETWORK_REFUSED")
+	}
+	net := NewMockNetwork()
+
+	// nothing is listening
+	ctor, err := net.NewMockConnector(NewMockEndPoint("T", "nobody"))
+	c.Assert(err, IsNil)
+	_, err = ctor.Connect(nil)
+	c.Assert(err, Equals, ConnectionRefused)
+
+	// something was listening but has stopped
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	ctor, err = net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	unaccepted, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	c.Assert(acc.Close(), IsNil)
+	c.Assert(acc.IsClosed(), Equals, true)
+	_, err = ctor.Connect(nil)
+	c.Assert(err, Equals, ConnectionRefused)
+
+	// and the connection it never accepted has been closed
+	_, err = unaccepted.Read(make([]byte, 8))
+	c.Assert(err, Equals, io.EOF)
+	_, err = acc.Accept()
+	c.Assert(err, Equals, AcceptorClosed)
+
+	// the address can now be reused
+	acc, err = net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	acc.Close()
+
+	// the backlog is finite
+	acc, err = net.NewMockAcceptor("T", "busy")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	ctor, err = net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	for i := 0; i < MOCK_BACKLOG; i++ {
+		_, err = ctor.Connect(nil)
+		c.Assert(err, IsNil)
+	}
+	active := atomic.LoadInt64(&DefaultMetrics.transport("mock").active)
+	_, err = ctor.Connect(nil)
+	c.Assert(err, Equals, ConnectionRefused)
+	c.Assert(atomic.LoadInt64(&DefaultMetrics.transport("mock").active),
+		Equals, active)
+}
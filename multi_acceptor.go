@@ -0,0 +1,190 @@
+package transport
+
+// xlTransport_go/multi_acceptor.go
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A MultiAcceptor listens through several acceptors at once, perhaps
+// of different transports: IPv4 and IPv6, loopback and LAN, TCP and
+// Unix.  Connections accepted by any of them are delivered through the
+// one Accept, and closing the MultiAcceptor closes them all.
+//
+// Each underlying acceptor is served by its own goroutine.  A failure
+// to accept is returned by Accept.  As with the relay and rendezvous
+// servers, a temporary failure is retried after acceptRetryDelay, the
+// pause growing while failures continue; an acceptor which has been
+// closed, or has failed permanently, is taken out of service.  Once
+// no acceptor remains in service Accept returns AcceptorClosed.
+
+type MultiAcceptor struct {
+	acceptors []AcceptorI
+	results   chan acceptResult
+	done      chan struct{} // closed by Close
+	serving   sync.WaitGroup
+	allDone   chan struct{} // closed when no acceptor is in service
+	closeOnce sync.Once
+	closeErr  error
+	mu        sync.Mutex
+	clock     ClockI // times the pause after a temporary failure
+}
+
+type acceptResult struct {
+	cnx ConnectionI
+	err error
+}
+
+// Accept connections through all of acceptors, of which there must be
+// at least one.
+func NewMultiAcceptor(acceptors ...AcceptorI) (m *MultiAcceptor, err error) {
+	if len(acceptors) == 0 {
+		return nil, NilAcceptor
+	}
+	for _, acc := range acceptors {
+		if acc == nil {
+			return nil, NilAcceptor
+		}
+	}
+	m = &MultiAcceptor{
+		acceptors: append([]AcceptorI(nil), acceptors...),
+		results:   make(chan acceptResult),
+		done:      make(chan struct{}),
+		allDone:   make(chan struct{}),
+		clock:     RealClock{},
+	}
+	m.serving.Add(len(acceptors))
+	for _, acc := range m.acceptors {
+		go m.serve(acc)
+	}
+	go func() {
+		m.serving.Wait()
+		close(m.allDone)
+	}()
+	return
+}
+
+// Pass what acc accepts to Accept until acc or m is closed or acc fails
+// permanently.
+func (m *MultiAcceptor) serve(acc AcceptorI) {
+	defer m.serving.Done()
+	var delay time.Duration
+	for {
+		cnx, err := acc.Accept()
+		if err != nil && (acc.IsClosed() || errors.Is(err, AcceptorClosed)) {
+			return
+		}
+		select {
+		case m.results <- acceptResult{cnx, err}:
+		case <-m.done:
+			if cnx != nil {
+				cnx.Close()
+			}
+			return
+		}
+		if err == nil {
+			delay = 0
+			continue
+		}
+		if !IsTemporary(err) {
+			return
+		}
+		delay = acceptRetryDelay(delay)
+		select {
+		case <-m.getClock().After(delay):
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Set the clock timing the pause before an acceptor is tried again
+// after a temporary failure.
+func (m *MultiAcceptor) SetClock(clock ClockI) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = clock
+}
+
+func (m *MultiAcceptor) getClock() ClockI {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clock
+}
+
+// Block until any of the acceptors accepts a connection.
+func (m *MultiAcceptor) Accept() (ConnectionI, error) {
+	select {
+	case r := <-m.results:
+		return r.cnx, r.err
+	case <-m.done:
+	case <-m.allDone:
+	}
+	return nil, AcceptorClosed
+}
+
+// Close every acceptor, returning any errors in doing so joined.
+func (m *MultiAcceptor) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		var errs []error
+		for _, acc := range m.acceptors {
+			if err := acc.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		m.serving.Wait()
+		m.closeErr = errors.Join(errs...)
+	})
+	return m.closeErr
+}
+
+func (m *MultiAcceptor) IsClosed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Return the end point of the first acceptor.
+func (m *MultiAcceptor) GetEndPoint() EndPointI {
+	return m.acceptors[0].GetEndPoint()
+}
+
+// Return the end points of all the acceptors, in the order given.
+func (m *MultiAcceptor) GetEndPoints() []EndPointI {
+	eps := make([]EndPointI, len(m.acceptors))
+	for i, acc := range m.acceptors {
+		eps[i] = acc.GetEndPoint()
+	}
+	return eps
+}
+
+// Return the underlying acceptors.
+func (m *MultiAcceptor) GetAcceptors() []AcceptorI {
+	return append([]AcceptorI(nil), m.acceptors...)
+}
+
+// Return the statistics of all the acceptors summed.
+func (m *MultiAcceptor) Stats() (s AcceptorStats) {
+	for _, acc := range m.acceptors {
+		as := acc.Stats()
+		s.Accepted += as.Accepted
+		s.Rejected += as.Rejected
+		s.Active += as.Active
+	}
+	return
+}
+
+func (m *MultiAcceptor) String() string {
+	eps := make([]string, len(m.acceptors))
+	for i, ep := range m.GetEndPoints() {
+		eps[i] = ep.String()
+	}
+	return "MultiAcceptor: [" + strings.Join(eps, ", ") + "]"
+}
@@ -0,0 +1,157 @@
+package transport
+
+// xlTransport_go/multi_acceptor_test.go
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"time"
+)
+
+func (s *XLSuite) TestMultiAcceptor(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MULTI_ACCEPTOR")
+	}
+	_, err := NewMultiAcceptor()
+	c.Assert(err, Equals, NilAcceptor)
+
+	tcpAcc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	acceptors := []AcceptorI{tcpAcc}
+	if tcp6Acc, err := NewTcpAcceptor("[::1]:0"); err == nil {
+		acceptors = append(acceptors, tcp6Acc)
+	} // otherwise there is no IPv6 loopback here
+	net := NewMockNetwork()
+	mockAcc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	acceptors = append(acceptors, mockAcc)
+
+	m, err := NewMultiAcceptor(acceptors...)
+	c.Assert(err, IsNil)
+	c.Assert(m.GetEndPoint().Equal(tcpAcc.GetEndPoint()), Equals, true)
+	eps := m.GetEndPoints()
+	c.Assert(len(eps), Equals, len(acceptors))
+	for i, acc := range acceptors {
+		c.Assert(eps[i].Equal(acc.GetEndPoint()), Equals, true)
+	}
+
+	// a client of each acceptor is accepted through the one Accept
+	var clients []ConnectionI
+	for _, acc := range acceptors {
+		var ctor ConnectorI
+		if _, ok := acc.(*MockAcceptor); ok {
+			ctor, err = net.NewMockConnector(acc.GetEndPoint())
+		} else {
+			ctor, err = NewTcpConnector(acc.GetEndPoint())
+		}
+		c.Assert(err, IsNil)
+		client, err := ctor.Connect(nil)
+		c.Assert(err, IsNil)
+		clients = append(clients, client)
+	}
+	seen := make(map[string]bool)
+	for range clients {
+		server, err := m.Accept()
+		c.Assert(err, IsNil)
+		seen[server.GetFarEnd().String()] = true
+		defer server.Close()
+	}
+	for _, client := range clients {
+		c.Assert(seen[client.GetNearEnd().String()], Equals, true)
+		client.Close()
+	}
+	c.Assert(m.Stats().Accepted, Equals, uint64(len(clients)))
+
+	// closing the MultiAcceptor closes everything
+	c.Assert(m.IsClosed(), Equals, false)
+	c.Assert(m.Close(), IsNil)
+	c.Assert(m.IsClosed(), Equals, true)
+	for _, acc := range acceptors {
+		c.Assert(acc.IsClosed(), Equals, true)
+	}
+	_, err = m.Accept()
+	c.Assert(err, Equals, AcceptorClosed)
+}
+
+// Once every underlying acceptor has been closed, Accept gives up.
+func (s *XLSuite) TestMultiAcceptorUnderlyingClosed(c *C) {
+	net := NewMockNetwork()
+	a, err := net.NewMockAcceptor("T", "a")
+	c.Assert(err, IsNil)
+	b, err := net.NewMockAcceptor("T", "b")
+	c.Assert(err, IsNil)
+	m, err := NewMultiAcceptor(a, b)
+	c.Assert(err, IsNil)
+	defer m.Close()
+	a.Close()
+	b.Close()
+	_, err = m.Accept()
+	c.Assert(err, Equals, AcceptorClosed)
+	c.Assert(m.String(), Equals,
+		"MultiAcceptor: [MockEndPoint: T, a, MockEndPoint: T, b]")
+}
+
+// An acceptor whose first Accept fails with err.
+type failOnceAcceptor struct {
+	AcceptorI
+	err    error
+	failed bool
+}
+
+func (a *failOnceAcceptor) Accept() (ConnectionI, error) {
+	if !a.failed {
+		a.failed = true
+		return nil, a.err
+	}
+	return a.AcceptorI.Accept()
+}
+
+// A temporary failure does not take the acceptor out of service.
+func (s *XLSuite) TestMultiAcceptorSkipsFailure(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MULTI_ACCEPTOR_SKIPS_FAILURE")
+	}
+	net := NewMockNetwork()
+	a, err := net.NewMockAcceptor("T", "a")
+	c.Assert(err, IsNil)
+	// a client reset its connection before it could be accepted
+	m, err := NewMultiAcceptor(&failOnceAcceptor{AcceptorI: a,
+		err: ConnectionReset})
+	c.Assert(err, IsNil)
+	defer m.Close()
+	clock := NewVirtualClock(time.Unix(0, 0))
+	m.SetClock(clock)
+	_, err = m.Accept()
+	c.Assert(err, Equals, ConnectionReset)
+	// the acceptor is tried again once the pause is over
+	clock.WaitForTimers(1)
+	clock.Advance(ACCEPT_RETRY_MIN)
+
+	ctor, err := net.NewMockConnector(a.GetEndPoint())
+	c.Assert(err, IsNil)
+	client, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer client.Close()
+	server, err := m.Accept()
+	c.Assert(err, IsNil)
+	c.Assert(server.GetFarEnd().Equal(client.GetNearEnd()), Equals, true)
+	server.Close()
+}
+
+// A permanent failure takes the acceptor out of service.
+func (s *XLSuite) TestMultiAcceptorPermanentFailure(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MULTI_ACCEPTOR_PERMANENT_FAILURE")
+	}
+	net := NewMockNetwork()
+	a, err := net.NewMockAcceptor("T", "a")
+	c.Assert(err, IsNil)
+	m, err := NewMultiAcceptor(&failOnceAcceptor{AcceptorI: a, err: io.EOF})
+	c.Assert(err, IsNil)
+	defer m.Close()
+	_, err = m.Accept()
+	c.Assert(err, Equals, io.EOF)
+	_, err = m.Accept()
+	c.Assert(err, Equals, AcceptorClosed)
+}
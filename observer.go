@@ -0,0 +1,185 @@
+package transport
+
+// xlTransport_go/observer.go
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// Transports report what happens to their connections to an observer,
+// which may log it, trace it or ignore it.  There is one observer for
+// the library, set by SetObserver; by default there is none.  Observers
+// are called synchronously, in the goroutine doing the work, and so
+// must be quick and must not block.
+
+type EventKind int
+
+const (
+	EV_DIAL_START  EventKind = iota // a connector begins to connect
+	EV_DIAL_FINISH                  // and has succeeded or failed
+	EV_ACCEPT                       // an acceptor has accepted a connection
+	EV_HANDSHAKE                    // a handshake has succeeded or failed
+	EV_STATE                        // a connection has changed state
+	EV_ERROR                        // a read, write or accept has failed
+	EV_CLOSE                        // a connection has closed
+)
+
+var eventKindNames = []string{
+	"dial start", "dial finish", "accept", "handshake", "state change",
+	"error", "close",
+}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventKindNames) {
+		return "unknown event"
+	}
+	return eventKindNames[k]
+}
+
+type Event struct {
+	Kind      EventKind
+	At        time.Time
+	Near, Far EndPointI // either may be nil if not yet known
+
+	// How long the dial or handshake took; for EV_CLOSE, how long the
+	// connection was open.
+	Duration time.Duration
+
+	Op       string // for EV_HANDSHAKE the handshake, for EV_ERROR the operation
+	From, To int    // for EV_STATE, the CNX_* states
+	Err      error  // for EV_DIAL_FINISH, EV_HANDSHAKE and EV_ERROR
+}
+
+type ObserverI interface {
+	Observe(ev *Event)
+}
+
+type observerHolder struct {
+	o ObserverI
+}
+
+var observer atomic.Value // an observerHolder
+
+// Set the library's observer, returning the one it replaces.  A nil
+// observer turns observation off.
+func SetObserver(o ObserverI) (prev ObserverI) {
+	if old, ok := observer.Swap(observerHolder{o}).(observerHolder); ok {
+		prev = old.o
+	}
+	return
+}
+
+func getObserver() ObserverI {
+	h, _ := observer.Load().(observerHolder)
+	return h.o
+}
+
+// Pass an event to the observer, if there is one, timestamping it.
+func observe(ev *Event) {
+	if o := getObserver(); o != nil {
+		if ev.At.IsZero() {
+			ev.At = time.Now()
+		}
+		o.Observe(ev)
+	}
+}
+
+// Report the start of a dial from nearEnd, which may be nil, to farEnd,
+// returning the function which reports its end.
+func observeDial(nearEnd, farEnd EndPointI) (finish func(ConnectionI, error)) {
+	if getObserver() == nil {
+		return func(ConnectionI, error) {}
+	}
+	observe(&Event{Kind: EV_DIAL_START, Near: nearEnd, Far: farEnd})
+	start := time.Now()
+	return func(cnx ConnectionI, err error) {
+		ev := &Event{Kind: EV_DIAL_FINISH, Near: nearEnd, Far: farEnd,
+			Duration: time.Since(start), Err: err}
+		if cnx != nil {
+			ev.Near, ev.Far = cnx.GetNearEnd(), cnx.GetFarEnd()
+		}
+		observe(ev)
+	}
+}
+
+// Report that acc has accepted cnx.
+func observeAccept(cnx ConnectionI) {
+	if getObserver() != nil {
+		observe(&Event{Kind: EV_ACCEPT, Near: cnx.GetNearEnd(),
+			Far: cnx.GetFarEnd()})
+	}
+}
+
+// Report a change of state on cnx.
+func observeState(cnx ConnectionI, from, to int) {
+	if from != to && getObserver() != nil {
+		observe(&Event{Kind: EV_STATE, Near: cnx.GetNearEnd(),
+			Far: cnx.GetFarEnd(), From: from, To: to})
+	}
+}
+
+// Report a failed operation on cnx, which may be nil.
+func observeError(cnx ConnectionI, op string, err error) {
+	if getObserver() != nil {
+		ev := &Event{Kind: EV_ERROR, Op: op, Err: err}
+		if cnx != nil {
+			ev.Near, ev.Far = cnx.GetNearEnd(), cnx.GetFarEnd()
+		}
+		observe(ev)
+	}
+}
+
+// SLOG /////////////////////////////////////////////////////////////
+
+// An observer which writes one structured log record per event.  Dial
+// and handshake failures and errors are logged at level Warn, state
+// changes at Debug and everything else at Info.
+type SlogObserver struct {
+	logger *slog.Logger
+}
+
+// Log events to logger, or to slog.Default() if logger is nil.
+func NewSlogObserver(logger *slog.Logger) *SlogObserver {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogObserver{logger}
+}
+
+func (so *SlogObserver) Observe(ev *Event) {
+	level := slog.LevelInfo
+	if ev.Err != nil {
+		level = slog.LevelWarn
+	} else if ev.Kind == EV_STATE {
+		level = slog.LevelDebug
+	}
+	ctx := context.Background()
+	if !so.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := make([]slog.Attr, 0, 6)
+	if ev.Near != nil {
+		attrs = append(attrs, slog.String("near", ev.Near.String()))
+	}
+	if ev.Far != nil {
+		attrs = append(attrs, slog.String("far", ev.Far.String()))
+	}
+	if ev.Op != "" {
+		attrs = append(attrs, slog.String("op", ev.Op))
+	}
+	switch ev.Kind {
+	case EV_DIAL_FINISH, EV_HANDSHAKE, EV_CLOSE:
+		attrs = append(attrs, slog.Duration("duration", ev.Duration))
+	case EV_STATE:
+		attrs = append(attrs, slog.Int("from", ev.From), slog.Int("to", ev.To))
+	}
+	if ev.Err != nil {
+		attrs = append(attrs, slog.String("err", ev.Err.Error()))
+	}
+	r := slog.NewRecord(ev.At, level, "transport "+ev.Kind.String(), 0)
+	r.AddAttrs(attrs...)
+	so.logger.Handler().Handle(ctx, r)
+}
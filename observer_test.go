@@ -0,0 +1,116 @@
+package transport
+
+// xlTransport_go/observer_test.go
+
+import (
+	"bytes"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Remembers every event observed.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) Observe(ev *Event) {
+	l.mu.Lock()
+	l.events = append(l.events, *ev)
+	l.mu.Unlock()
+}
+
+func (l *eventLog) kinds() (kinds []EventKind) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ev := range l.events {
+		kinds = append(kinds, ev.Kind)
+	}
+	return
+}
+
+func (s *XLSuite) TestObserver(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_OBSERVER")
+	}
+	log := &eventLog{}
+	prev := SetObserver(log)
+	defer SetObserver(prev)
+
+	net := NewMockNetwork()
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	ctor, err := net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	client, err := ctor.Connect(NewMockEndPoint("T", "client"))
+	c.Assert(err, IsNil)
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := ExchangePreamble(server, SUPPORTED_CAPS, 0)
+		done <- err
+	}()
+	_, _, err = ExchangePreamble(client, SUPPORTED_CAPS, 0)
+	c.Assert(err, IsNil)
+	c.Assert(<-done, IsNil)
+	c.Assert(client.Close(), IsNil)
+	_, err = client.Write([]byte("late"))
+	c.Assert(err, Equals, ClosedConnection)
+
+	kinds := log.kinds()
+	c.Assert(kinds[:3], DeepEquals,
+		[]EventKind{EV_DIAL_START, EV_DIAL_FINISH, EV_ACCEPT})
+	c.Assert(kinds[len(kinds)-3:], DeepEquals,
+		[]EventKind{EV_STATE, EV_CLOSE, EV_ERROR})
+	handshakes := 0
+	for _, ev := range log.events {
+		switch ev.Kind {
+		case EV_DIAL_FINISH:
+			c.Assert(ev.Err, IsNil)
+			c.Assert(ev.Near.String(), Equals, "MockEndPoint: T, client")
+			c.Assert(ev.Far.Equal(acc.GetEndPoint()), Equals, true)
+		case EV_HANDSHAKE:
+			c.Assert(ev.Op, Equals, "preamble")
+			handshakes++
+		case EV_STATE:
+			c.Assert(ev.From, Equals, CNX_CONNECTED)
+			c.Assert(ev.To, Equals, CNX_DISCONNECTED)
+		case EV_ERROR:
+			c.Assert(ev.Op, Equals, "write")
+			c.Assert(ev.Err, Equals, ClosedConnection)
+		}
+	}
+	c.Assert(handshakes, Equals, 2)
+}
+
+func (s *XLSuite) TestSlogObserver(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SLOG_OBSERVER")
+	}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	prev := SetObserver(NewSlogObserver(logger))
+	defer SetObserver(prev)
+
+	net := NewMockNetwork()
+	ctor, err := net.NewMockConnector(NewMockEndPoint("T", "nobody"))
+	c.Assert(err, IsNil)
+	_, err = ctor.Connect(nil)
+	c.Assert(err, Equals, ConnectionRefused)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	c.Assert(len(lines), Equals, 2)
+	c.Assert(strings.Contains(lines[0], `level=INFO msg="transport dial start"`),
+		Equals, true)
+	c.Assert(strings.Contains(lines[1], `level=WARN msg="transport dial finish"`),
+		Equals, true)
+	c.Assert(strings.Contains(lines[1], `far="MockEndPoint: T, nobody"`),
+		Equals, true)
+	c.Assert(strings.Contains(lines[1], `err="`+ConnectionRefused.Error()+`"`),
+		Equals, true)
+}
@@ -0,0 +1,398 @@
+package transport
+
+// xlTransport_go/preamble.go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peers running different versions of this library agree on what they
+// speak by exchanging a preamble as soon as the connection is up.  Each
+// side sends its library version, the capabilities it supports and the
+// capabilities it insists upon.  Both sides then compute the same
+// result: the capabilities common to the two, or a PreambleError if
+// the versions are incompatible or either side's requirements are not
+// met.
+//
+// The preamble is a single frame:
+//     magic "XLTP"
+//     version length (1 byte), version string
+//     supported capabilities (4 bytes)
+//     required capabilities (4 bytes)
+
+type Capabilities uint32
+
+const (
+	CAP_FRAMING Capabilities = 1 << iota
+	CAP_AUTH                 // the hello exchange
+	CAP_ENCRYPTION
+	CAP_REKEY
+	CAP_MUX
+)
+
+// The most time a PreambleAcceptor allows a new connection to send its
+// preamble.
+const PREAMBLE_TIMEOUT = 10 * time.Second
+
+// The capabilities this version of the library supports.
+const SUPPORTED_CAPS = CAP_FRAMING | CAP_AUTH | CAP_ENCRYPTION | CAP_REKEY
+
+var preambleMagic = []byte("XLTP")
+
+var capNames = []string{"framing", "auth", "encryption", "rekey", "mux"}
+
+// Whether all capabilities in want are present in c.
+func (c Capabilities) Has(want Capabilities) bool {
+	return c&want == want
+}
+
+func (c Capabilities) String() string {
+	var names []string
+	for i, name := range capNames {
+		if c&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if rest := c &^ (1<<uint(len(capNames)) - 1); rest != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(rest)))
+	}
+	return "[" + strings.Join(names, ",") + "]"
+}
+
+// What one side of a connection says about itself.
+type Preamble struct {
+	Version  string
+	Caps     Capabilities
+	Required Capabilities
+}
+
+// Returned when the two sides cannot agree.  Reason is one of
+// IncompatibleVersion or MissingCapabilities.
+type PreambleError struct {
+	Reason        error
+	Local, Remote *Preamble
+}
+
+func (e *PreambleError) Error() string {
+	return fmt.Sprintf("%s: local version %s caps %s requires %s, "+
+		"remote version %s caps %s requires %s",
+		e.Reason.Error(),
+		e.Local.Version, e.Local.Caps, e.Local.Required,
+		e.Remote.Version, e.Remote.Caps, e.Remote.Required)
+}
+
+func (e *PreambleError) Unwrap() error {
+	return e.Reason
+}
+
+// Return the major and minor numbers of a version string such as
+// "0.5.13".
+func parseVersion(v string) (major, minor int, err error) {
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 {
+		err = BadVersion
+	} else if major, err = strconv.Atoi(parts[0]); err == nil {
+		minor, err = strconv.Atoi(parts[1])
+	}
+	if err != nil {
+		err = BadVersion
+	}
+	return
+}
+
+// Two versions are compatible if their major numbers match and, while
+// the major number is 0, their minor numbers match as well.
+func VersionsCompatible(a, b string) bool {
+	aMajor, aMinor, err := parseVersion(a)
+	if err != nil {
+		return false
+	}
+	bMajor, bMinor, err := parseVersion(b)
+	if err != nil {
+		return false
+	}
+	return aMajor == bMajor && (aMajor > 0 || aMinor == bMinor)
+}
+
+func encodePreamble(p *Preamble) (msg []byte, err error) {
+	if len(p.Version) > 255 {
+		err = BadPreamble
+	} else {
+		var buf bytes.Buffer
+		var capBuf [4]byte
+		buf.Write(preambleMagic)
+		buf.WriteByte(byte(len(p.Version)))
+		buf.WriteString(p.Version)
+		binary.BigEndian.PutUint32(capBuf[:], uint32(p.Caps))
+		buf.Write(capBuf[:])
+		binary.BigEndian.PutUint32(capBuf[:], uint32(p.Required))
+		buf.Write(capBuf[:])
+		msg = buf.Bytes()
+	}
+	return
+}
+
+func decodePreamble(msg []byte) (p *Preamble, err error) {
+	magicLen := len(preambleMagic)
+	if len(msg) < magicLen+1 || !bytes.Equal(msg[:magicLen], preambleMagic) {
+		err = BadPreamble
+	} else {
+		msg = msg[magicLen:]
+		vLen := int(msg[0])
+		msg = msg[1:]
+		if len(msg) != vLen+8 {
+			err = BadPreamble
+		} else {
+			p = &Preamble{
+				Version:  string(msg[:vLen]),
+				Caps:     Capabilities(binary.BigEndian.Uint32(msg[vLen:])),
+				Required: Capabilities(binary.BigEndian.Uint32(msg[vLen+4:])),
+			}
+		}
+	}
+	return
+}
+
+// Exchange preambles over cnx, offering caps and insisting upon
+// required, which should be a subset of caps.  Both sides of the
+// connection must call ExchangePreamble.  Returns what the far end
+// said and the capabilities the two sides have in common.
+func ExchangePreamble(cnx ConnectionI, caps, required Capabilities) (
+	remote *Preamble, common Capabilities, err error) {
+
+	return exchangePreamble(cnx, &Preamble{VERSION, caps, required})
+}
+
+func exchangePreamble(cnx ConnectionI, local *Preamble) (
+	remote *Preamble, common Capabilities, err error) {
+
+	defer timeHandshake("preamble", cnx)(&err)
+	var msg, reply []byte
+	if cnx == nil {
+		err = NilConnection
+	} else {
+		msg, err = encodePreamble(local)
+	}
+	if err == nil {
+		reply, err = exchangeFrames(cnx, msg)
+	}
+	if err == nil {
+		remote, err = decodePreamble(reply)
+	}
+	if err == nil {
+		common = local.Caps & remote.Caps
+		if !VersionsCompatible(local.Version, remote.Version) {
+			err = &PreambleError{IncompatibleVersion, local, remote}
+		} else if !common.Has(local.Required) || !common.Has(remote.Required) {
+			err = &PreambleError{MissingCapabilities, local, remote}
+		}
+		if err != nil {
+			common = 0
+		}
+	}
+	return
+}
+
+// A connection over which preambles have been exchanged successfully.
+type PreambleConnection struct {
+	ConnectionI
+	peer   *Preamble
+	common Capabilities
+}
+
+// Return the preamble sent by the far end.
+func (c *PreambleConnection) GetPeerPreamble() *Preamble {
+	return c.peer
+}
+
+// Return the capabilities supported by both ends.
+func (c *PreambleConnection) GetCapabilities() Capabilities {
+	return c.common
+}
+
+// Wraps a connector so that every connection it makes begins with a
+// preamble exchange.
+type PreambleConnector struct {
+	ConnectorI
+	caps, required Capabilities
+}
+
+func NewPreambleConnector(ctor ConnectorI, caps, required Capabilities) (
+	*PreambleConnector, error) {
+
+	if ctor == nil {
+		return nil, NilConnector
+	}
+	return &PreambleConnector{ctor, caps, required}, nil
+}
+
+// Connect and exchange preambles.  If the exchange fails the new
+// connection is closed and the error returned.
+func (c *PreambleConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
+	cnx, err := c.ConnectorI.Connect(nearEnd)
+	if err != nil {
+		return nil, err
+	}
+	return withPreamble(cnx, c.caps, c.required, 0, nil)
+}
+
+// Wraps an acceptor so that every connection it accepts begins with a
+// preamble exchange.  Exchanges run concurrently, each in a goroutine of
+// its own, so that a client which is slow to send its preamble, or never
+// sends one, does not hold up the rest; one which takes longer than the
+// timeout, as measured by the acceptor's clock, is closed.  Accept
+// returns connections as their exchanges succeed.  Those whose
+// exchanges fail are closed and reported to the observer.
+type PreambleAcceptor struct {
+	AcceptorI
+	caps, required Capabilities
+	timeout        time.Duration
+	clock          ClockI
+	startOnce      sync.Once
+	ready          chan preambleResult
+	done           chan struct{}
+	closeOnce      sync.Once
+	mu             sync.Mutex
+	exchanging     map[ConnectionI]bool // exchanges under way
+}
+
+type preambleResult struct {
+	cnx ConnectionI
+	err error
+}
+
+func NewPreambleAcceptor(acc AcceptorI, caps, required Capabilities) (
+	*PreambleAcceptor, error) {
+
+	if acc == nil {
+		return nil, NilAcceptor
+	}
+	return &PreambleAcceptor{AcceptorI: acc, caps: caps, required: required,
+		timeout: PREAMBLE_TIMEOUT, clock: RealClock{},
+		ready: make(chan preambleResult), done: make(chan struct{}),
+		exchanging: make(map[ConnectionI]bool)}, nil
+}
+
+// Set the most time a new connection has to send its preamble,
+// PREAMBLE_TIMEOUT by default.  Call this before the first Accept.
+func (a *PreambleAcceptor) SetTimeout(d time.Duration) {
+	a.timeout = d
+}
+
+// Set the clock timing preamble exchanges.  Call this before the first
+// Accept.
+func (a *PreambleAcceptor) SetClock(clock ClockI) {
+	a.clock = clock
+}
+
+// Return the next connection over which preambles have been exchanged.
+// An error from the underlying acceptor is returned as it comes; the
+// acceptor may remain open, so callers should check IsClosed() before
+// giving up on it.
+func (a *PreambleAcceptor) Accept() (ConnectionI, error) {
+	a.startOnce.Do(func() { go a.acceptLoop() })
+	select {
+	case r := <-a.ready:
+		return r.cnx, r.err
+	case <-a.done:
+		return nil, AcceptorClosed
+	}
+}
+
+func (a *PreambleAcceptor) acceptLoop() {
+	for {
+		cnx, err := a.AcceptorI.Accept()
+		if err != nil {
+			if a.AcceptorI.IsClosed() {
+				a.stop()
+				return
+			}
+			a.deliver(preambleResult{nil, err})
+			continue
+		}
+		a.mu.Lock()
+		closing := a.isDone()
+		if !closing {
+			a.exchanging[cnx] = true
+		}
+		a.mu.Unlock()
+		if closing {
+			cnx.Close()
+			continue
+		}
+		go func() {
+			pc, err := withPreamble(cnx, a.caps, a.required, a.timeout,
+				a.clock)
+			a.mu.Lock()
+			delete(a.exchanging, cnx)
+			a.mu.Unlock()
+			if err != nil {
+				observeError(cnx, "preamble", err)
+			} else if !a.deliver(preambleResult{pc, nil}) {
+				pc.Close()
+			}
+		}()
+	}
+}
+
+// Hand r to Accept, returning false if the acceptor closes first.
+func (a *PreambleAcceptor) deliver(r preambleResult) bool {
+	select {
+	case a.ready <- r:
+		return true
+	case <-a.done:
+		return false
+	}
+}
+
+func (a *PreambleAcceptor) stop() {
+	a.closeOnce.Do(func() { close(a.done) })
+}
+
+func (a *PreambleAcceptor) isDone() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close the underlying acceptor, and with it any connection whose
+// preamble exchange is still under way.
+func (a *PreambleAcceptor) Close() error {
+	a.stop()
+	a.mu.Lock()
+	for cnx := range a.exchanging {
+		cnx.Close()
+	}
+	a.mu.Unlock()
+	return a.AcceptorI.Close()
+}
+
+// Exchange preambles over cnx, closing it if the exchange fails or, if
+// timeout is not zero, takes longer than that by clock.  An exchange
+// cut short by the timeout fails with PreambleTimeout.
+func withPreamble(cnx ConnectionI, caps, required Capabilities,
+	timeout time.Duration, clock ClockI) (ConnectionI, error) {
+
+	var timer TimerI
+	if timeout > 0 {
+		timer = clock.AfterFunc(timeout, func() { cnx.Close() })
+	}
+	remote, common, err := ExchangePreamble(cnx, caps, required)
+	if timer != nil && !timer.Stop() {
+		err = PreambleTimeout
+	}
+	if err != nil {
+		cnx.Close()
+		return nil, err
+	}
+	return &PreambleConnection{cnx, remote, common}, nil
+}
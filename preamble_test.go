@@ -0,0 +1,181 @@
+package transport
+
+// xlTransport_go/preamble_test.go
+
+import (
+	"errors"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"time"
+)
+
+func (s *XLSuite) TestVersionsCompatible(c *C) {
+	c.Assert(VersionsCompatible(VERSION, VERSION), Equals, true)
+	c.Assert(VersionsCompatible("0.5.13", "0.5.2"), Equals, true)
+	c.Assert(VersionsCompatible("0.5.13", "0.6.0"), Equals, false)
+	c.Assert(VersionsCompatible("1.2.0", "1.7.3"), Equals, true)
+	c.Assert(VersionsCompatible("1.2.0", "2.2.0"), Equals, false)
+	c.Assert(VersionsCompatible("1.2.0", "x.y"), Equals, false)
+}
+
+func (s *XLSuite) TestCapabilitiesString(c *C) {
+	caps := CAP_FRAMING | CAP_REKEY
+	c.Assert(caps.String(), Equals, "[framing,rekey]")
+	c.Assert(caps.Has(CAP_REKEY), Equals, true)
+	c.Assert(caps.Has(CAP_REKEY|CAP_MUX), Equals, false)
+}
+
+func (s *XLSuite) TestPreambleAcceptorAndConnector(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PREAMBLE_ACCEPTOR_AND_CONNECTOR")
+	}
+	tcpAcc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer tcpAcc.Close()
+	acc, err := NewPreambleAcceptor(tcpAcc, SUPPORTED_CAPS, CAP_FRAMING)
+	c.Assert(err, IsNil)
+
+	accepted := make(chan ConnectionI, 1)
+	go func() {
+		cnx, _ := acc.Accept()
+		accepted <- cnx
+	}()
+	tcpCtor, err := NewTcpConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	ctor, err := NewPreambleConnector(tcpCtor, CAP_FRAMING|CAP_MUX, 0)
+	c.Assert(err, IsNil)
+
+	cnx, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer cnx.Close()
+	server := <-accepted
+	c.Assert(server, NotNil)
+	defer server.Close()
+
+	// only framing is common to the two
+	clientPC := cnx.(*PreambleConnection)
+	serverPC := server.(*PreambleConnection)
+	c.Assert(clientPC.GetCapabilities(), Equals, CAP_FRAMING)
+	c.Assert(serverPC.GetCapabilities(), Equals, CAP_FRAMING)
+	c.Assert(clientPC.GetPeerPreamble().Version, Equals, VERSION)
+	c.Assert(serverPC.GetPeerPreamble().Caps, Equals, CAP_FRAMING|CAP_MUX)
+}
+
+func (s *XLSuite) TestPreambleIncompatible(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PREAMBLE_INCOMPATIBLE")
+	}
+	type outcome struct {
+		common Capabilities
+		err    error
+	}
+	// run the exchange with the server claiming serverSide, returning
+	// what each side concludes
+	run := func(clientSide, serverSide *Preamble) (client, server outcome) {
+		clientCnx, serverCnx := s.makeTcpPair(c)
+		defer clientCnx.Close()
+		defer serverCnx.Close()
+		done := make(chan outcome, 1)
+		go func() {
+			_, common, err := exchangePreamble(serverCnx, serverSide)
+			done <- outcome{common, err}
+		}()
+		_, client.common, client.err = exchangePreamble(clientCnx, clientSide)
+		server = <-done
+		return
+	}
+
+	// a newer minor version is incompatible while the major is 0
+	client, server := run(
+		&Preamble{VERSION, SUPPORTED_CAPS, 0},
+		&Preamble{"0.99.0", SUPPORTED_CAPS, 0})
+	c.Assert(errors.Is(client.err, IncompatibleVersion), Equals, true)
+	c.Assert(errors.Is(server.err, IncompatibleVersion), Equals, true)
+	c.Assert(client.common, Equals, Capabilities(0))
+
+	// the server insists on multiplexing, which the client lacks
+	client, server = run(
+		&Preamble{VERSION, SUPPORTED_CAPS, 0},
+		&Preamble{VERSION, SUPPORTED_CAPS | CAP_MUX, CAP_MUX})
+	c.Assert(errors.Is(client.err, MissingCapabilities), Equals, true)
+	c.Assert(errors.Is(server.err, MissingCapabilities), Equals, true)
+
+	var pErr *PreambleError
+	c.Assert(errors.As(client.err, &pErr), Equals, true)
+	c.Assert(pErr.Remote.Required, Equals, CAP_MUX)
+}
+
+// A client which never sends its preamble holds up no one else, and is
+// closed when its time runs out by the acceptor's clock.
+func (s *XLSuite) TestPreambleAcceptorSilentClient(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PREAMBLE_ACCEPTOR_SILENT_CLIENT")
+	}
+	log := &eventLog{}
+	prev := SetObserver(log)
+	defer SetObserver(prev)
+	tcpAcc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	acc, err := NewPreambleAcceptor(tcpAcc, SUPPORTED_CAPS, 0)
+	c.Assert(err, IsNil)
+	clock := NewVirtualClock(time.Unix(0, 0))
+	acc.SetClock(clock)
+	tcpCtor, err := NewTcpConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+
+	silent, err := tcpCtor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer silent.Close()
+	accepted := make(chan ConnectionI, 1)
+	go func() {
+		cnx, _ := acc.Accept()
+		accepted <- cnx
+	}()
+	clock.WaitForTimers(1) // the silent client is accepted first
+	ctor, err := NewPreambleConnector(tcpCtor, SUPPORTED_CAPS, 0)
+	c.Assert(err, IsNil)
+	cnx, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer cnx.Close()
+	server := <-accepted
+	c.Assert(server, NotNil)
+	defer server.Close()
+	c.Assert(server.GetFarEnd().Equal(cnx.GetNearEnd()), Equals, true)
+
+	// the silent client gets the server's preamble, then is cut off
+	clock.Advance(PREAMBLE_TIMEOUT)
+	buf := make([]byte, 256)
+	for err == nil {
+		_, err = silent.Read(buf)
+	}
+	c.Assert(err, Equals, io.EOF)
+	timedOut := func() bool {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+		for _, ev := range log.events {
+			if ev.Kind == EV_ERROR && ev.Op == "preamble" &&
+				errors.Is(ev.Err, PreambleTimeout) {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; i < 200 && !timedOut(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(timedOut(), Equals, true)
+
+	// closing the acceptor cuts off an exchange still under way
+	silent2, err := tcpCtor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer silent2.Close()
+	clock.WaitForTimers(1)
+	c.Assert(acc.Close(), IsNil)
+	for err = nil; err == nil; {
+		_, err = silent2.Read(buf)
+	}
+	c.Assert(err, Equals, io.EOF)
+	_, err = acc.Accept()
+	c.Assert(err, Equals, AcceptorClosed)
+}
@@ -0,0 +1,252 @@
+package transport
+
+// xlTransport_go/proxy_protocol.go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The HAProxy PROXY protocol, versions 1 and 2.  A load balancer which
+// relays a client's connection to a node prefixes it with a header
+// naming the client, since otherwise the node sees only the balancer.
+//
+// A TcpAcceptor given a ProxyConfig expects such a header on every
+// connection from one of the trusted upstreams, and the connections it
+// returns report the client as their far end.  Connections from
+// anywhere else are taken as they are, without looking for a header,
+// since anyone could forge one.  A connection from a trusted upstream
+// whose header is missing, malformed or slow to arrive is closed and
+// counted as rejected, and Accept goes on to the next connection.
+//
+// A header saying LOCAL (v2) or UNKNOWN (v1), as balancers send for
+// their own health checks, is accepted and the connection's far end is
+// the balancer itself.
+//
+// A TcpConnector given a ProxyHeader sends one on every connection it
+// makes, for when a node itself relays connections.
+
+const (
+	// The most time the upstream may take to send the header.
+	PROXY_HEADER_TIMEOUT = 5 * time.Second
+
+	// The longest v1 header allowed, CRLF included.
+	PROXY_V1_MAX_LEN = 107
+)
+
+// The 12 bytes beginning a v2 header.
+var PROXY_V2_SIGNATURE = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// The upstreams whose PROXY headers are believed.
+type ProxyConfig struct {
+	Trusted []*net.IPNet
+	Timeout time.Duration // zero means PROXY_HEADER_TIMEOUT
+}
+
+// Trust the upstreams given as IP addresses or CIDR blocks, as in
+// "10.0.0.0/8" or "::1".
+func NewProxyConfig(trusted ...string) (cfg *ProxyConfig, err error) {
+	cfg = &ProxyConfig{}
+	for _, t := range trusted {
+		var ipNet *net.IPNet
+		if strings.IndexByte(t, '/') >= 0 {
+			_, ipNet, err = net.ParseCIDR(t)
+		} else if ip := net.ParseIP(t); ip == nil {
+			err = BadProxyHeader
+		} else if ip4 := ip.To4(); ip4 != nil {
+			ipNet = &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+		} else {
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+		}
+		if err != nil {
+			return nil, err
+		}
+		cfg.Trusted = append(cfg.Trusted, ipNet)
+	}
+	return
+}
+
+// Whether the far end of conn is a trusted upstream.
+func (cfg *ProxyConfig) trusts(conn *net.TCPConn) bool {
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		for _, ipNet := range cfg.Trusted {
+			if ipNet.Contains(a.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// If conn is from a trusted upstream, read its header, returning the
+// client named in it or nil if it names none.
+func (cfg *ProxyConfig) accept(conn *net.TCPConn) (src *net.TCPAddr, err error) {
+	if !cfg.trusts(conn) {
+		return
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = PROXY_HEADER_TIMEOUT
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	src, _, err = readProxyHeader(conn)
+	if dErr := conn.SetReadDeadline(time.Time{}); err == nil {
+		err = dErr
+	}
+	return
+}
+
+// Read a v1 or v2 header from r, byte by byte where need be so as not
+// to consume anything after it.  src and dst are nil for LOCAL or
+// UNKNOWN.
+func readProxyHeader(r io.Reader) (src, dst *net.TCPAddr, err error) {
+	buf := make([]byte, len(PROXY_V2_SIGNATURE), PROXY_V1_MAX_LEN)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return nil, nil, BadProxyHeader
+	}
+	if bytes.Equal(buf, PROXY_V2_SIGNATURE) {
+		return readProxyV2(r)
+	}
+	if !bytes.HasPrefix(buf, []byte("PROXY ")) {
+		return nil, nil, BadProxyHeader
+	}
+	var b [1]byte
+	for !bytes.HasSuffix(buf, []byte("\r\n")) {
+		if len(buf) == PROXY_V1_MAX_LEN {
+			return nil, nil, BadProxyHeader
+		}
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return nil, nil, BadProxyHeader
+		}
+		buf = append(buf, b[0])
+	}
+	return parseProxyV1(string(buf[:len(buf)-2]))
+}
+
+// Parse "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443", without the CRLF.
+func parseProxyV1(line string) (src, dst *net.TCPAddr, err error) {
+	fields := strings.Split(line, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, BadProxyHeader
+	}
+	v4 := fields[1] == "TCP4"
+	parse := func(ipStr, portStr string) *net.TCPAddr {
+		ip := net.ParseIP(ipStr)
+		if ip == nil || !strings.Contains(ipStr, ":") != v4 {
+			return nil
+		}
+		port, pErr := strconv.Atoi(portStr)
+		if pErr != nil || port < 0 || port > 65535 ||
+			strconv.Itoa(port) != portStr {
+			return nil
+		}
+		return &net.TCPAddr{IP: ip, Port: port}
+	}
+	src = parse(fields[2], fields[4])
+	dst = parse(fields[3], fields[5])
+	if src == nil || dst == nil {
+		return nil, nil, BadProxyHeader
+	}
+	return
+}
+
+// Read the rest of a v2 header, its signature already read.
+func readProxyV2(r io.Reader) (src, dst *net.TCPAddr, err error) {
+	var hdr [4]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, BadProxyHeader
+	}
+	verCmd, family := hdr[0], hdr[1]
+	body := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+	if _, err = io.ReadFull(r, body); err != nil {
+		return nil, nil, BadProxyHeader
+	}
+	if verCmd>>4 != 2 {
+		return nil, nil, BadProxyHeader
+	}
+	switch verCmd & 0xf {
+	case 0: // LOCAL
+		return
+	case 1: // PROXY
+	default:
+		return nil, nil, BadProxyHeader
+	}
+	var ipLen int
+	switch family {
+	case 0x00: // UNSPEC
+		return
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		return nil, nil, BadProxyHeader
+	}
+	// any TLVs following the addresses are ignored
+	if len(body) < 2*ipLen+4 {
+		return nil, nil, BadProxyHeader
+	}
+	src = &net.TCPAddr{IP: net.IP(append([]byte(nil), body[:ipLen]...)),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen:]))}
+	dst = &net.TCPAddr{IP: net.IP(append([]byte(nil), body[ipLen:2*ipLen]...)),
+		Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:]))}
+	return
+}
+
+// A header for a TcpConnector to send.  If Source and Dest are nil the
+// connection's own near and far ends are named.
+type ProxyHeader struct {
+	Version int // 1 or 2
+	Source  *TcpEndPoint
+	Dest    *TcpEndPoint
+}
+
+// Encode the header for a connection from near to far.
+func (h *ProxyHeader) encode(near, far *net.TCPAddr) (buf []byte, err error) {
+	if h.Source != nil {
+		near = h.Source.GetTcpAddr()
+	}
+	if h.Dest != nil {
+		far = h.Dest.GetTcpAddr()
+	}
+	srcIP, dstIP := near.IP.To4(), far.IP.To4()
+	v4 := srcIP != nil && dstIP != nil
+	if !v4 {
+		srcIP, dstIP = near.IP.To16(), far.IP.To16()
+	}
+	switch h.Version {
+	case 1:
+		proto := "TCP4"
+		if !v4 {
+			proto = "TCP6"
+		}
+		buf = []byte("PROXY " + proto + " " + srcIP.String() + " " +
+			dstIP.String() + " " + strconv.Itoa(near.Port) + " " +
+			strconv.Itoa(far.Port) + "\r\n")
+	case 2:
+		family := byte(0x11)
+		if !v4 {
+			family = 0x21
+		}
+		body := make([]byte, 2*len(srcIP)+4)
+		copy(body, srcIP)
+		copy(body[len(srcIP):], dstIP)
+		binary.BigEndian.PutUint16(body[2*len(srcIP):], uint16(near.Port))
+		binary.BigEndian.PutUint16(body[2*len(srcIP)+2:], uint16(far.Port))
+		buf = append([]byte(nil), PROXY_V2_SIGNATURE...)
+		buf = append(buf, 0x21, family, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(body)))
+		buf = append(buf, body...)
+	default:
+		err = BadProxyHeader
+	}
+	return
+}
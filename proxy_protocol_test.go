@@ -0,0 +1,175 @@
+package transport
+
+// xlTransport_go/proxy_protocol_test.go
+
+import (
+	"bytes"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"time"
+)
+
+func (s *XLSuite) TestProxyHeaderParsing(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PROXY_HEADER_PARSING")
+	}
+	src, _ := NewTcpEndPoint("192.0.2.1:56324")
+	dst, _ := NewTcpEndPoint("198.51.100.7:443")
+	src6, _ := NewTcpEndPoint("[2001:db8::1]:56324")
+	dst6, _ := NewTcpEndPoint("[2001:db8::2]:443")
+
+	// what the connector encodes the acceptor decodes, leaving what
+	// follows the header unread
+	for _, version := range []int{1, 2} {
+		for _, pair := range [][2]*TcpEndPoint{{src, dst}, {src6, dst6}} {
+			h := &ProxyHeader{Version: version, Source: pair[0], Dest: pair[1]}
+			buf, err := h.encode(nil, nil)
+			c.Assert(err, IsNil)
+			r := bytes.NewReader(append(buf, "data"...))
+			gotSrc, gotDst, err := readProxyHeader(r)
+			c.Assert(err, IsNil)
+			c.Assert(gotSrc.String(), Equals, pair[0].GetTcpAddr().String())
+			c.Assert(gotDst.String(), Equals, pair[1].GetTcpAddr().String())
+			rest, _ := io.ReadAll(r)
+			c.Assert(string(rest), Equals, "data")
+		}
+	}
+	buf, _ := (&ProxyHeader{Version: 1, Source: src, Dest: dst}).encode(nil, nil)
+	c.Assert(string(buf), Equals,
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\r\n")
+	_, err := (&ProxyHeader{Version: 3, Source: src, Dest: dst}).encode(nil, nil)
+	c.Assert(err, Equals, BadProxyHeader)
+
+	// headers naming no client
+	local := append(append([]byte(nil), PROXY_V2_SIGNATURE...), 0x20, 0, 0, 0)
+	for _, hdr := range []string{"PROXY UNKNOWN\r\n", string(local)} {
+		gotSrc, gotDst, err := readProxyHeader(bytes.NewReader([]byte(hdr)))
+		c.Assert(err, IsNil)
+		c.Assert(gotSrc, IsNil)
+		c.Assert(gotDst, IsNil)
+	}
+
+	// malformed headers are rejected
+	badV2 := func(verCmd, family byte, body ...byte) string {
+		b := append(append([]byte(nil), PROXY_V2_SIGNATURE...),
+			verCmd, family, 0, byte(len(body)))
+		return string(append(b, body...))
+	}
+	for _, hdr := range []string{
+		"",
+		"GET / HTTP/1.1\r\n\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324 65536\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 056324 443\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.7 56324 443\r\n",
+		"PROXY TCP6 192.0.2.1 2001:db8::2 56324 443\r\n",
+		"PROXY TCP5 192.0.2.1 198.51.100.7 56324 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324 443\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.7 56324 443" +
+			string(bytes.Repeat([]byte(" "), 80)) + "\r\n",
+		badV2(0x11, 0x11, make([]byte, 12)...), // version 1
+		badV2(0x22, 0x11, make([]byte, 12)...), // unknown command
+		badV2(0x21, 0x31, make([]byte, 12)...), // Unix family
+		badV2(0x21, 0x11, make([]byte, 11)...), // too short
+		badV2(0x21, 0x11, make([]byte, 40)...)[:30],
+	} {
+		_, _, err := readProxyHeader(bytes.NewReader([]byte(hdr)))
+		c.Assert(err, Equals, BadProxyHeader, Commentf("%q", hdr))
+	}
+
+	_, err = NewProxyConfig("not an address")
+	c.Assert(err, NotNil)
+	cfg, err := NewProxyConfig("10.0.0.0/8", "127.0.0.1", "::1")
+	c.Assert(err, IsNil)
+	c.Assert(len(cfg.Trusted), Equals, 3)
+}
+
+func (s *XLSuite) TestProxyProtocol(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_PROXY_PROTOCOL")
+	}
+	acc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	cfg, err := NewProxyConfig("127.0.0.0/8")
+	c.Assert(err, IsNil)
+	acc.SetProxyConfig(cfg)
+
+	client, _ := NewTcpEndPoint("192.0.2.1:56324")
+	for _, version := range []int{1, 2} {
+		ctor, err := NewTcpConnector(acc.GetEndPoint())
+		c.Assert(err, IsNil)
+		ctor.SetProxyHeader(&ProxyHeader{Version: version, Source: client})
+		cnx, err := ctor.Connect(nil)
+		c.Assert(err, IsNil)
+		_, err = cnx.Write([]byte("hello"))
+		c.Assert(err, IsNil)
+
+		server, err := acc.Accept()
+		c.Assert(err, IsNil)
+		c.Assert(server.GetFarEnd().Equal(client), Equals, true)
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(server, buf)
+		c.Assert(err, IsNil)
+		c.Assert(string(buf), Equals, "hello")
+		server.Close()
+		cnx.Close()
+	}
+
+	// a trusted upstream sending a bad header is dropped, and one slow
+	// to send its header does not hold up the rest
+	silent, err := net.Dial("tcp", acc.GetEndPoint().String()[13:])
+	c.Assert(err, IsNil)
+	defer silent.Close()
+	bad, err := net.Dial("tcp", acc.GetEndPoint().String()[13:])
+	c.Assert(err, IsNil)
+	defer bad.Close()
+	_, err = bad.Write([]byte("PROXY TCP4 bogus\r\n"))
+	c.Assert(err, IsNil)
+	ctor, err := NewTcpConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	ctor.SetProxyHeader(&ProxyHeader{Version: 2})
+	good, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer good.Close()
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	defer server.Close()
+	// naming itself as the client
+	c.Assert(server.GetFarEnd().Equal(good.GetNearEnd()), Equals, true)
+	_, err = bad.Read(make([]byte, 1))
+	c.Assert(err, NotNil)
+	c.Assert(acc.Stats().Rejected, Equals, uint64(1))
+
+	// closing the acceptor drops a connection whose header is awaited
+	c.Assert(acc.Close(), IsNil)
+	silent.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = silent.Read(make([]byte, 1))
+	c.Assert(err, Equals, io.EOF)
+	_, err = acc.Accept()
+	c.Assert(IsClosed(err), Equals, true)
+
+	// headers from untrusted sources are not looked for
+	acc2, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer acc2.Close()
+	cfg2, err := NewProxyConfig("10.0.0.0/8")
+	c.Assert(err, IsNil)
+	acc2.SetProxyConfig(cfg2)
+	ctor2, err := NewTcpConnector(acc2.GetEndPoint())
+	c.Assert(err, IsNil)
+	ctor2.SetProxyHeader(&ProxyHeader{Version: 1, Source: client})
+	forger, err := ctor2.Connect(nil)
+	c.Assert(err, IsNil)
+	defer forger.Close()
+	server2, err := acc2.Accept()
+	c.Assert(err, IsNil)
+	defer server2.Close()
+	c.Assert(server2.GetFarEnd().Equal(forger.GetNearEnd()), Equals, true)
+	buf := make([]byte, 6)
+	_, err = io.ReadFull(server2, buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "PROXY ")
+}
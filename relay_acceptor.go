@@ -0,0 +1,162 @@
+package transport
+
+// xlTransport_go/relay_acceptor.go
+
+import (
+	"encoding/hex"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Accepts connections relayed by a RelayServer for a node which cannot
+// accept them itself.  It registers the node's ID with the relay over
+// a control connection held open for as long as the acceptor; for each
+// call the relay announces there, it makes a fresh connection to the
+// relay to take it.  A call taken waits, like one in a listen queue,
+// until Accept returns it.
+//
+// If the control connection is lost, Accept returns the error which
+// ended it; the acceptor must then be closed and another registered.
+
+type RelayAcceptor struct {
+	endPoint  *RelayEndPoint
+	relay     *TcpConnector
+	ctl       *TcpConnection // the control connection
+	cnxs      chan *RelayConnection
+	done      chan struct{}
+	lost      chan struct{} // closed when the control connection fails
+	lostErr   error
+	closeOnce sync.Once
+	stats     acceptorCounters
+}
+
+// Register ep's node ID with its relay.  Socket options are applied to
+// the connections to the relay.
+func NewRelayAcceptor(ep EndPointI, opts ...*SocketOptions) (*RelayAcceptor, error) {
+	relayEp, ok := ep.(*RelayEndPoint)
+	if !ok || relayEp == nil {
+		return nil, NotRelayEndPoint
+	}
+	ep2, _ := relayEp.Clone()
+	relayEp = ep2.(*RelayEndPoint)
+	relayCtor, err := NewTcpConnector(relayEp.relay, opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctl, _, err := relayRequest(relayCtor, RELAY_REGISTER,
+		hex.EncodeToString(relayEp.nodeID))
+	if err != nil {
+		return nil, wrapError("listen", "relay", relayEp, nil, err)
+	}
+	a := &RelayAcceptor{
+		endPoint: relayEp,
+		relay:    relayCtor,
+		ctl:      ctl,
+		cnxs:     make(chan *RelayConnection),
+		done:     make(chan struct{}),
+		lost:     make(chan struct{}),
+	}
+	go a.listen()
+	return a, nil
+}
+
+// Read calls announced on the control connection, taking each.
+func (a *RelayAcceptor) listen() {
+	for {
+		frame, err := readFrame(a.ctl.conn)
+		if err != nil {
+			if err == io.EOF {
+				err = ConnectionReset
+			}
+			a.lostErr = wrapError("accept", "relay", a.endPoint, nil, err)
+			close(a.lost)
+			return
+		}
+		words := strings.Fields(string(frame))
+		if len(words) != 3 || words[0] != RELAY_INCOMING {
+			a.reject(nil, BadRecord)
+			continue
+		}
+		go a.take(words[1], words[2])
+	}
+}
+
+// Take the call identified by token, from the peer with the hex ID
+// given.
+func (a *RelayAcceptor) take(token, peer string) {
+	var farEnd *RelayEndPoint
+	id, err := hex.DecodeString(peer)
+	if err == nil {
+		farEnd, err = NewRelayEndPoint(a.endPoint.relay, id)
+	}
+	if err != nil {
+		a.reject(nil, BadRecord)
+		return
+	}
+	tcpCnx, _, err := relayRequest(a.relay, RELAY_ACCEPT, token)
+	if err != nil {
+		a.reject(farEnd, err)
+		return
+	}
+	cnx := newRelayConnection(tcpCnx, a.endPoint, farEnd)
+	select {
+	case a.cnxs <- cnx:
+	case <-a.done:
+		cnx.Close()
+		a.stats.countReject()
+	}
+}
+
+func (a *RelayAcceptor) reject(farEnd EndPointI, err error) {
+	a.stats.countReject()
+	observeError(nil, "accept",
+		wrapError("accept", "relay", a.endPoint, farEnd, err))
+}
+
+func (a *RelayAcceptor) Accept() (ConnectionI, error) {
+	select {
+	case cnx := <-a.cnxs:
+		a.stats.countAccept(&cnx.stats)
+		observeAccept(cnx)
+		return cnx, nil
+	case <-a.done:
+		return nil, AcceptorClosed
+	case <-a.lost:
+		if a.IsClosed() {
+			return nil, AcceptorClosed
+		}
+		return nil, a.lostErr
+	}
+}
+
+// Withdraw the registration.  Connections already accepted are not
+// affected.
+func (a *RelayAcceptor) Close() (err error) {
+	a.closeOnce.Do(func() {
+		close(a.done)
+		err = a.ctl.Close()
+	})
+	return
+}
+
+func (a *RelayAcceptor) IsClosed() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *RelayAcceptor) GetEndPoint() EndPointI {
+	return a.endPoint
+}
+
+func (a *RelayAcceptor) Stats() AcceptorStats {
+	return a.stats.snapshot()
+}
+
+func (a *RelayAcceptor) String() string {
+	return "RelayAcceptor: " + a.endPoint.String()
+}
@@ -0,0 +1,106 @@
+package transport
+
+// xlTransport_go/relay_connection.go
+
+import (
+	"fmt"
+	xc "github.com/jddixon/xlCrypto_go"
+	"io"
+	"net"
+)
+
+// A connection spliced together by a RelayServer.  It is carried by a
+// TCP connection to the relay, but reports RelayEndPoints, the relay's
+// end point and a node ID, for both its ends.
+
+type RelayConnection struct {
+	tcpCnx    *TcpConnection // to the relay
+	conn      *net.TCPConn
+	near, far *RelayEndPoint
+	state     int
+	stats     connCounters
+}
+
+func newRelayConnection(tcpCnx *TcpConnection, near, far *RelayEndPoint) *RelayConnection {
+	c := &RelayConnection{tcpCnx: tcpCnx, conn: tcpCnx.conn, near: near,
+		far: far, state: CNX_CONNECTED}
+	c.stats.init(c, "relay", RealClock{})
+	return c
+}
+
+// Return the current state index.
+func (c *RelayConnection) GetState() int {
+	return c.state
+}
+
+func (c *RelayConnection) BindNearEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+func (c *RelayConnection) BindFarEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+// Bring the connection to the DISCONNECTED state, closing the
+// connection to the relay.
+func (c *RelayConnection) Close() (err error) {
+	observeState(c, c.state, CNX_DISCONNECTED)
+	c.state = CNX_DISCONNECTED
+	c.stats.countClose()
+	return c.wrap("close", c.tcpCnx.Close())
+}
+
+func (c *RelayConnection) GetNearEnd() EndPointI {
+	return c.near
+}
+
+func (c *RelayConnection) GetFarEnd() EndPointI {
+	return c.far
+}
+
+// Wrap an error from operation op in a TransportError.
+func (c *RelayConnection) wrap(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return wrapError(op, "relay", c.near, c.far, err)
+}
+
+func (c *RelayConnection) Read(b []byte) (count int, err error) {
+	count, err = c.conn.Read(b)
+	err = c.wrap("read", err)
+	c.stats.countRead(count, err)
+	return
+}
+
+func (c *RelayConnection) Write(b []byte) (count int, err error) {
+	count, err = c.conn.Write(b)
+	err = c.wrap("write", err)
+	c.stats.countWrite(count, err)
+	return
+}
+
+func (c *RelayConnection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
+func (c *RelayConnection) IsBlocking() bool {
+	return false
+}
+
+// The relay sees everything; see SecureConnection.
+func (c *RelayConnection) IsEncrypted() bool {
+	return false
+}
+func (c *RelayConnection) Negotiate(myKey xc.KeyI, hisKey xc.PublicKeyI) (
+	s xc.SecretI, e error) {
+
+	return nil, NotImplemented
+}
+
+func (c *RelayConnection) Equal(any interface{}) bool {
+	return any == c
+}
+
+func (c *RelayConnection) String() string {
+	return fmt.Sprintf("Relay: %s --> %s", c.near.String(), c.far.String())
+}
@@ -0,0 +1,129 @@
+package transport
+
+// xlTransport_go/relay_connector.go
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A RelayConnector reaches a node registered with a RelayServer,
+// asking the relay to splice it through.  A refusal because no such
+// node is registered, or because the node did not take the call in
+// time, is recognized by IsRefused or IsTimeout.
+
+type RelayConnector struct {
+	relay  *TcpConnector
+	farEnd *RelayEndPoint
+}
+
+// Connect to the node named by farEnd, a RelayEndPoint.  Socket options
+// are applied to the connection to the relay.
+func NewRelayConnector(farEnd EndPointI, opts ...*SocketOptions) (
+	*RelayConnector, error) {
+
+	ep, ok := farEnd.(*RelayEndPoint)
+	if !ok || ep == nil {
+		return nil, NotRelayEndPoint
+	}
+	ep2, _ := ep.Clone()
+	relayCtor, err := NewTcpConnector(ep.relay, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &RelayConnector{relay: relayCtor, farEnd: ep2.(*RelayEndPoint)}, nil
+}
+
+// Open a connection to the relay and send it a request, returning the
+// words of its reply if it is OK.
+func relayRequest(relay *TcpConnector, words ...string) (
+	tcpCnx *TcpConnection, reply []string, err error) {
+
+	cnx, err := relay.Connect(nil)
+	if err != nil {
+		return
+	}
+	tcpCnx = cnx.(*TcpConnection)
+	conn := tcpCnx.conn
+	// allow the relay its own timeout for the far end to answer
+	conn.SetDeadline(time.Now().Add(2 * RELAY_TIMEOUT))
+	var frame []byte
+	if err = writeFrame(conn, []byte(strings.Join(words, " "))); err == nil {
+		frame, err = readFrame(conn)
+	}
+	if dErr := conn.SetDeadline(time.Time{}); err == nil {
+		err = dErr
+	}
+	if err == nil {
+		reply = strings.Fields(string(frame))
+		if len(reply) == 0 {
+			err = BadRecord
+		} else if reply[0] == RELAY_ERR {
+			err = relayError(strings.Join(reply[1:], " "))
+		} else if reply[0] != RELAY_OK {
+			err = BadRecord
+		}
+	}
+	if err != nil {
+		tcpCnx.Close()
+		tcpCnx, reply = nil, nil
+	}
+	return
+}
+
+// The error for the relay's reason for refusing a request.
+func relayError(reason string) error {
+	switch reason {
+	case RELAY_NO_NODE:
+		return ConnectionRefused
+	case RELAY_TIMED_OUT:
+		return ConnectTimeout
+	}
+	return fmt.Errorf("%w: %s", RelayRefused, reason)
+}
+
+// Ask the relay for the far end.  If nearEnd is a RelayEndPoint its
+// node ID is given to the far end as ours; otherwise the relay assigns
+// one.
+func (c *RelayConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
+	req := []string{RELAY_CONNECT, hex.EncodeToString(c.farEnd.nodeID)}
+	if ep, ok := nearEnd.(*RelayEndPoint); ok && ep != nil {
+		req = append(req, hex.EncodeToString(ep.nodeID))
+	}
+	finish := observeDial(nearEnd, c.farEnd)
+	start := time.Now()
+	tcpCnx, reply, err := relayRequest(c.relay, req...)
+	var near *RelayEndPoint
+	if err == nil {
+		var id []byte
+		if len(reply) != 2 {
+			err = BadRecord
+		} else if id, err = hex.DecodeString(reply[1]); err == nil {
+			near, err = NewRelayEndPoint(c.farEnd.relay, id)
+		}
+		if err != nil {
+			tcpCnx.Close()
+			err = BadRecord
+		}
+	}
+	DefaultMetrics.transport("relay").countDial(err)
+	if err != nil {
+		err = wrapError("dial", "relay", nearEnd, c.farEnd, err)
+		finish(nil, err)
+		return nil, err
+	}
+	cnx := newRelayConnection(tcpCnx, near, c.farEnd)
+	cnx.stats.setConnectLatency(time.Since(start))
+	finish(cnx, nil)
+	return cnx, nil
+}
+
+func (c *RelayConnector) GetFarEnd() EndPointI {
+	return c.farEnd
+}
+
+func (c *RelayConnector) String() string {
+	return "RelayConnector: " + c.farEnd.addrString()
+}
@@ -0,0 +1,87 @@
+package transport
+
+// xlTransport_go/relay_endpoint.go
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+)
+
+// A node reached through a relay: the relay's TCP end point and the
+// node's ID, serialized as "RelayEndPoint: 127.0.0.1:9000/<hex ID>".
+
+type RelayEndPoint struct {
+	relay  *TcpEndPoint
+	nodeID []byte
+}
+
+func NewRelayEndPoint(relay EndPointI, nodeID []byte) (*RelayEndPoint, error) {
+	tcpEp, ok := relay.(*TcpEndPoint)
+	if !ok || tcpEp == nil {
+		return nil, NotTcpEndPoint
+	}
+	if len(nodeID) == 0 || len(nodeID) > MAX_RELAY_NODE_ID {
+		return nil, NilNodeID
+	}
+	ep, _ := tcpEp.Clone()
+	id := make([]byte, len(nodeID))
+	copy(id, nodeID)
+	return &RelayEndPoint{ep.(*TcpEndPoint), id}, nil
+}
+
+// Parse "host:port/<hex node ID>", the form String returns without its
+// prefix.
+func parseRelayEndPoint(s string) (*RelayEndPoint, error) {
+	slash := strings.LastIndex(s, "/")
+	if slash < 0 {
+		return nil, NotAnEndPoint
+	}
+	relay, err := NewTcpEndPoint(s[:slash])
+	if err != nil {
+		return nil, err
+	}
+	nodeID, err := hex.DecodeString(s[slash+1:])
+	if err != nil {
+		return nil, NotAnEndPoint
+	}
+	return NewRelayEndPoint(relay, nodeID)
+}
+
+func (e *RelayEndPoint) Address() AddressI {
+	return &HostAddress{e.addrString()}
+}
+
+func (e *RelayEndPoint) addrString() string {
+	return hostPort(e.relay) + "/" + hex.EncodeToString(e.nodeID)
+}
+
+func (e *RelayEndPoint) Clone() (EndPointI, error) {
+	return NewRelayEndPoint(e.relay, e.nodeID)
+}
+
+func (e *RelayEndPoint) Equal(any interface{}) bool {
+	other, ok := any.(*RelayEndPoint)
+	return ok && other != nil && other.relay.Equal(e.relay) &&
+		bytes.Equal(other.nodeID, e.nodeID)
+}
+
+func (e *RelayEndPoint) String() string {
+	return "RelayEndPoint: " + e.addrString()
+}
+
+func (e *RelayEndPoint) Transport() string {
+	return "relay"
+}
+
+// Return the relay's end point.
+func (e *RelayEndPoint) GetRelay() *TcpEndPoint {
+	return e.relay
+}
+
+// Return a copy of the node's ID.
+func (e *RelayEndPoint) GetNodeID() []byte {
+	id := make([]byte, len(e.nodeID))
+	copy(id, e.nodeID)
+	return id
+}
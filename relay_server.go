@@ -0,0 +1,362 @@
+package transport
+
+// xlTransport_go/relay_server.go
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A RelayServer lets nodes which cannot accept connections themselves,
+// being behind NAT say, be reached through it.  Such a node registers
+// its ID with the relay over a control connection which it keeps open
+// (see RelayAcceptor).  A peer wanting the node connects to the relay
+// and names the ID (see RelayConnector); the relay tells the node over
+// its control connection, the node makes a fresh connection to the
+// relay to take the call, and the relay splices the two together.
+//
+// Requests and replies are frames holding space-separated words:
+//
+//	REGISTER <id>                    node to relay, on the control connection
+//	INCOMING <token> <peer id>       relay to node, on the control connection
+//	ACCEPT <token>                   node to relay, on a new connection
+//	CONNECT <id> [<peer id>]         peer to relay
+//	OK [<peer id>]                   relay's answer to all but INCOMING
+//	ERR <reason>                     relay's refusal
+//
+// IDs and tokens are in hex.  A peer which gives no ID of its own is
+// assigned one at random, which the relay reports to both ends.
+//
+// The relay believes whatever IDs it is told: the first node to
+// register an ID has it until it disconnects.  Ends wanting to know
+// whom they are talking to should run Hello over the connection.
+
+const (
+	RELAY_REGISTER = "REGISTER"
+	RELAY_INCOMING = "INCOMING"
+	RELAY_ACCEPT   = "ACCEPT"
+	RELAY_CONNECT  = "CONNECT"
+	RELAY_OK       = "OK"
+	RELAY_ERR      = "ERR"
+
+	RELAY_ID_IN_USE  = "id in use"
+	RELAY_NO_NODE    = "no such node"
+	RELAY_NO_REQUEST = "no such request"
+	RELAY_TIMED_OUT  = "timed out"
+	RELAY_BAD_REQ    = "bad request"
+
+	// The most time the relay waits for a request on a new connection,
+	// and for a node to take a call.
+	RELAY_TIMEOUT = 10 * time.Second
+
+	// The longest node ID a relay accepts, in bytes.
+	MAX_RELAY_NODE_ID = 64
+
+	// The length of the IDs assigned to anonymous peers, in bytes.
+	RELAY_PEER_ID_LEN = 16
+)
+
+type RelayServer struct {
+	acc     *TcpAcceptor
+	mu      sync.Mutex
+	nodes   map[string]*relayNode    // registered nodes, by hex ID
+	pending map[string]*relayPending // calls awaiting ACCEPT, by token
+	clock   ClockI                   // times retries and calls awaiting ACCEPT
+	done    chan struct{}
+	once    sync.Once
+}
+
+type relayNode struct {
+	ctl *net.TCPConn
+	wMu sync.Mutex // serializes INCOMING frames
+}
+
+// A peer waiting for the node to take its call.  Whoever removes it
+// from the relay's pending map, under the relay's lock, decides the
+// call: accept by sending on matched, connect by giving up.
+type relayPending struct {
+	matched chan *net.TCPConn // the node's connection, once ACCEPTed
+}
+
+// Listen at strAddr, which may carry socket options in query form, and
+// begin relaying.
+func NewRelayServer(strAddr string, opts ...*SocketOptions) (*RelayServer, error) {
+	acc, err := NewTcpAcceptor(strAddr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s := &RelayServer{
+		acc:     acc,
+		nodes:   make(map[string]*relayNode),
+		pending: make(map[string]*relayPending),
+		clock:   RealClock{},
+		done:    make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Accept calls until the relay is closed.  A temporary failure to
+// accept is retried after a pause; any other closes the relay.
+func (s *RelayServer) serve() {
+	var delay time.Duration
+	for {
+		cnx, err := s.acc.Accept()
+		if err != nil {
+			if s.acc.IsClosed() {
+				return
+			}
+			if !IsTemporary(err) {
+				s.Close()
+				return
+			}
+			delay = acceptRetryDelay(delay)
+			select {
+			case <-s.getClock().After(delay):
+			case <-s.done:
+				return
+			}
+			continue
+		}
+		delay = 0
+		go s.handle(cnx.(*TcpConnection).conn)
+	}
+}
+
+// Set the clock timing retries and calls awaiting ACCEPT.  A call's
+// request must still arrive within RELAY_TIMEOUT of wall-clock time,
+// being read from a real socket.
+func (s *RelayServer) SetClock(clock ClockI) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock
+}
+
+func (s *RelayServer) getClock() ClockI {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock
+}
+
+// Return a random token or ID, in hex.
+func relayRandomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Whether s is a hex-encoded node ID the relay accepts.
+func relayValidID(s string) bool {
+	id, err := hex.DecodeString(s)
+	return err == nil && len(id) > 0 && len(id) <= MAX_RELAY_NODE_ID
+}
+
+func relayReply(conn *net.TCPConn, words ...string) error {
+	return writeFrame(conn, []byte(strings.Join(words, " ")))
+}
+
+// Refuse a request and drop its connection.
+func relayRefuse(conn *net.TCPConn, reason string) {
+	relayReply(conn, RELAY_ERR, reason)
+	conn.Close()
+}
+
+// Read the request opening a new connection and act on it.
+func (s *RelayServer) handle(conn *net.TCPConn) {
+	conn.SetReadDeadline(time.Now().Add(RELAY_TIMEOUT))
+	frame, err := readFrame(conn)
+	if err == nil {
+		err = conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return
+	}
+	words := strings.Fields(string(frame))
+	switch {
+	case len(words) == 2 && words[0] == RELAY_REGISTER && relayValidID(words[1]):
+		s.register(conn, words[1])
+	case len(words) == 2 && words[0] == RELAY_ACCEPT:
+		s.accept(conn, words[1])
+	case (len(words) == 2 || len(words) == 3) && words[0] == RELAY_CONNECT &&
+		relayValidID(words[1]):
+
+		peerID := relayRandomHex(RELAY_PEER_ID_LEN)
+		if len(words) == 3 {
+			if !relayValidID(words[2]) {
+				relayRefuse(conn, RELAY_BAD_REQ)
+				return
+			}
+			peerID = words[2]
+		}
+		s.connect(conn, words[1], peerID)
+	default:
+		relayRefuse(conn, RELAY_BAD_REQ)
+	}
+}
+
+// Hold a node's control connection until it closes.
+func (s *RelayServer) register(conn *net.TCPConn, id string) {
+	node := &relayNode{ctl: conn}
+	s.mu.Lock()
+	_, taken := s.nodes[id]
+	closed := s.isClosed()
+	if !taken && !closed {
+		s.nodes[id] = node
+	}
+	s.mu.Unlock()
+	if taken || closed {
+		relayRefuse(conn, RELAY_ID_IN_USE)
+		return
+	}
+	node.wMu.Lock()
+	err := relayReply(conn, RELAY_OK)
+	node.wMu.Unlock()
+	if err == nil {
+		// the node sends nothing more; this returns when it goes away
+		io.Copy(io.Discard, conn)
+	}
+	s.mu.Lock()
+	if s.nodes[id] == node {
+		delete(s.nodes, id)
+	}
+	s.mu.Unlock()
+	conn.Close()
+}
+
+// Pass a peer's call to the node and, once it is taken, splice the two.
+func (s *RelayServer) connect(conn *net.TCPConn, id, peerID string) {
+	token := relayRandomHex(RELAY_PEER_ID_LEN)
+	p := &relayPending{matched: make(chan *net.TCPConn, 1)}
+	s.mu.Lock()
+	node := s.nodes[id]
+	if node != nil {
+		s.pending[token] = p
+	}
+	s.mu.Unlock()
+	if node == nil {
+		relayRefuse(conn, RELAY_NO_NODE)
+		return
+	}
+	node.wMu.Lock()
+	err := relayReply(node.ctl, RELAY_INCOMING, token, peerID)
+	node.wMu.Unlock()
+
+	var other *net.TCPConn
+	if err == nil {
+		expired := make(chan struct{})
+		timer := s.getClock().AfterFunc(RELAY_TIMEOUT, func() { close(expired) })
+		select {
+		case other = <-p.matched:
+		case <-expired:
+		case <-s.done:
+		}
+		timer.Stop()
+	}
+	if other == nil {
+		// whichever of us and accept takes the call off pending wins;
+		// if accept has it, its answer is on the way
+		s.mu.Lock()
+		lost := s.pending[token] == p
+		if lost {
+			delete(s.pending, token)
+		}
+		s.mu.Unlock()
+		if !lost {
+			other = <-p.matched
+		}
+	}
+	if other == nil {
+		reason := RELAY_TIMED_OUT
+		if err != nil {
+			reason = RELAY_NO_NODE
+		}
+		relayRefuse(conn, reason)
+		return
+	}
+	if err = relayReply(conn, RELAY_OK, peerID); err != nil {
+		conn.Close()
+		other.Close()
+		return
+	}
+	relaySplice(conn, other)
+}
+
+// Hand the node's connection to the peer waiting on token.  If the
+// peer has given up the call is gone and the node's connection is
+// refused.
+func (s *RelayServer) accept(conn *net.TCPConn, token string) {
+	s.mu.Lock()
+	p := s.pending[token]
+	delete(s.pending, token)
+	s.mu.Unlock()
+	if p == nil {
+		relayRefuse(conn, RELAY_NO_REQUEST)
+		return
+	}
+	if err := relayReply(conn, RELAY_OK); err != nil {
+		conn.Close()
+		conn = nil
+	}
+	p.matched <- conn
+}
+
+// Copy each way until both ends have finished sending, passing on
+// half-closes, then close both.
+func relaySplice(a, b *net.TCPConn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src *net.TCPConn) {
+		defer wg.Done()
+		if _, err := io.Copy(dst, src); err != nil {
+			// the far side is gone; don't wait on it
+			a.Close()
+			b.Close()
+		}
+		dst.CloseWrite()
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
+
+func (s *RelayServer) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop listening, drop the nodes' control connections and refuse the
+// calls waiting on them.  Calls already spliced go on until their ends
+// close them.
+func (s *RelayServer) Close() (err error) {
+	s.once.Do(func() {
+		s.mu.Lock()
+		close(s.done)
+		for _, node := range s.nodes {
+			node.ctl.Close()
+		}
+		s.mu.Unlock()
+		err = s.acc.Close()
+	})
+	return
+}
+
+// Return the end point at which the relay listens.
+func (s *RelayServer) GetEndPoint() EndPointI {
+	return s.acc.GetEndPoint()
+}
+
+func (s *RelayServer) String() string {
+	return "RelayServer: " + s.acc.GetEndPoint().String()
+}
@@ -0,0 +1,191 @@
+package transport
+
+// xlTransport_go/relay_test.go
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+func (s *XLSuite) TestRelayEndPoint(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_RELAY_END_POINT")
+	}
+	rng := xr.MakeSimpleRNG()
+	relay, _ := NewTcpEndPoint("127.0.0.1:9000")
+	nodeID := s.makeNodeID(rng)
+	ep, err := NewRelayEndPoint(relay, nodeID)
+	c.Assert(err, IsNil)
+	c.Assert(ep.Transport(), Equals, "relay")
+	c.Assert(ep.GetRelay().Equal(relay), Equals, true)
+	c.Assert(ep.GetNodeID(), DeepEquals, nodeID)
+	ep2, err := ParseEndPoint(ep.String())
+	c.Assert(err, IsNil)
+	c.Assert(ep2.Equal(ep), Equals, true)
+
+	ctor, err := NewRelayConnector(ep)
+	c.Assert(err, IsNil)
+	ctor2, err := ParseConnector(ctor.String())
+	c.Assert(err, IsNil)
+	c.Assert(ctor2.GetFarEnd().Equal(ep), Equals, true)
+
+	_, err = NewRelayEndPoint(relay, nil)
+	c.Assert(err, Equals, NilNodeID)
+	_, err = NewRelayConnector(relay)
+	c.Assert(err, Equals, NotRelayEndPoint)
+	for _, bad := range []string{"RelayEndPoint: 127.0.0.1:9000",
+		"RelayEndPoint: 127.0.0.1:9000/xyz", "RelayEndPoint: 127.0.0.1:9000/"} {
+		_, err = ParseEndPoint(bad)
+		c.Assert(err, NotNil, Commentf("%q", bad))
+	}
+}
+
+func (s *XLSuite) TestRelay(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_RELAY")
+	}
+	rng := xr.MakeSimpleRNG()
+	server, err := NewRelayServer("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Close()
+	relay := server.GetEndPoint()
+
+	// a node behind NAT registers with the relay
+	nodeEp, err := NewRelayEndPoint(relay, s.makeNodeID(rng))
+	c.Assert(err, IsNil)
+	acc, err := NewRelayAcceptor(nodeEp)
+	c.Assert(err, IsNil)
+	c.Assert(acc.String(), Equals, "RelayAcceptor: "+nodeEp.String())
+	accepted := make(chan ConnectionI, 4)
+	go func() {
+		for {
+			cnx, err := acc.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- cnx
+			go func() {
+				echo(cnx)
+				cnx.Close()
+			}()
+		}
+	}()
+
+	// the ID is taken while the node holds it
+	_, err = NewRelayAcceptor(nodeEp)
+	c.Assert(errors.Is(err, RelayRefused), Equals, true)
+
+	// a peer without an ID of its own is given one
+	ctor, err := NewRelayConnector(nodeEp)
+	c.Assert(err, IsNil)
+	cnx, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	c.Assert(cnx.GetFarEnd().Equal(nodeEp), Equals, true)
+	near := cnx.GetNearEnd().(*RelayEndPoint)
+	c.Assert(near.GetRelay().Equal(relay), Equals, true)
+	c.Assert(len(near.GetNodeID()), Equals, RELAY_PEER_ID_LEN)
+	msg := []byte("spliced by the relay")
+	_, err = cnx.Write(msg)
+	c.Assert(err, IsNil)
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(cnx, buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf, DeepEquals, msg)
+	srvCnx := <-accepted
+	c.Assert(srvCnx.GetNearEnd().Equal(nodeEp), Equals, true)
+	c.Assert(srvCnx.GetFarEnd().Equal(near), Equals, true)
+	c.Assert(cnx.Close(), IsNil)
+
+	// one which names itself is known by that name
+	peerEp, err := NewRelayEndPoint(relay, s.makeNodeID(rng))
+	c.Assert(err, IsNil)
+	cnx, err = ctor.Connect(peerEp)
+	c.Assert(err, IsNil)
+	c.Assert(cnx.GetNearEnd().Equal(peerEp), Equals, true)
+	srvCnx = <-accepted
+	c.Assert(srvCnx.GetFarEnd().Equal(peerEp), Equals, true)
+	c.Assert(cnx.Close(), IsNil)
+	c.Assert(acc.Stats().Accepted, Equals, uint64(2))
+
+	// an unregistered node cannot be reached
+	nowhere, _ := NewRelayEndPoint(relay, s.makeNodeID(rng))
+	ctor, err = NewRelayConnector(nowhere)
+	c.Assert(err, IsNil)
+	_, err = ctor.Connect(nil)
+	c.Assert(IsRefused(err), Equals, true)
+	var te *TransportError
+	c.Assert(errors.As(err, &te), Equals, true)
+	c.Assert(te.Transport, Equals, "relay")
+
+	// when the relay goes away the node hears of it
+	c.Assert(server.Close(), IsNil)
+	_, ok := <-accepted
+	c.Assert(ok, Equals, false)
+	_, err = acc.Accept()
+	c.Assert(err, NotNil)
+	c.Assert(err, Not(Equals), AcceptorClosed)
+	c.Assert(acc.Close(), IsNil)
+	_, err = acc.Accept()
+	c.Assert(err, Equals, AcceptorClosed)
+}
+
+// A call the node never takes times out by the relay's clock, and an
+// ACCEPT arriving after that is turned away.
+func (s *XLSuite) TestRelayCallTimeout(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_RELAY_CALL_TIMEOUT")
+	}
+	rng := xr.MakeSimpleRNG()
+	server, err := NewRelayServer("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer server.Close()
+	clock := NewVirtualClock(time.Unix(0, 0))
+	server.SetClock(clock)
+	relayAddr := server.GetEndPoint().(*TcpEndPoint).GetTcpAddr().String()
+
+	// a node which registers but ignores its calls
+	nodeID := s.makeNodeID(rng)
+	ctl, err := net.Dial("tcp", relayAddr)
+	c.Assert(err, IsNil)
+	defer ctl.Close()
+	c.Assert(writeFrame(ctl, []byte(RELAY_REGISTER+" "+
+		hex.EncodeToString(nodeID))), IsNil)
+	reply, err := readFrame(ctl)
+	c.Assert(err, IsNil)
+	c.Assert(string(reply), Equals, RELAY_OK)
+
+	nodeEp, err := NewRelayEndPoint(server.GetEndPoint(), nodeID)
+	c.Assert(err, IsNil)
+	ctor, err := NewRelayConnector(nodeEp)
+	c.Assert(err, IsNil)
+	result := make(chan error, 1)
+	go func() {
+		_, err := ctor.Connect(nil)
+		result <- err
+	}()
+	incoming, err := readFrame(ctl)
+	c.Assert(err, IsNil)
+	words := strings.Fields(string(incoming))
+	c.Assert(len(words), Equals, 3)
+	c.Assert(words[0], Equals, RELAY_INCOMING)
+	clock.WaitForTimers(1)
+	clock.Advance(RELAY_TIMEOUT)
+	err = <-result
+	c.Assert(errors.Is(err, ConnectTimeout), Equals, true)
+
+	late, err := net.Dial("tcp", relayAddr)
+	c.Assert(err, IsNil)
+	defer late.Close()
+	c.Assert(writeFrame(late, []byte(RELAY_ACCEPT+" "+words[1])), IsNil)
+	reply, err = readFrame(late)
+	c.Assert(err, IsNil)
+	c.Assert(string(reply), Equals, RELAY_ERR+" "+RELAY_NO_REQUEST)
+}
@@ -0,0 +1,167 @@
+package transport
+
+// xlTransport_go/rendezvous_server.go
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A RendezvousServer introduces two peers, each perhaps behind NAT,
+// so that they can punch a path between them (see HolePunchConnector).
+// Each peer sends the server a datagram naming a session agreed on
+// beforehand; the server notes the address it sees the datagram come
+// from, the peer's public end point as mapped by any NAT on the way.
+// Once two peers have named the same session, each is told the
+// other's public end point, in reply to this and any later request.
+//
+// Requests and replies are datagrams holding space-separated words:
+//
+//	XLRV1 REGISTER <session>     peer to server, repeated until answered
+//	XLRV1 PEER <host:port>       server to peer
+//
+// A session is forgotten RENDEZVOUS_TTL after it was last used.  The
+// server believes whoever names a session; peers wanting to know whom
+// they have met should run Hello over the connection.
+
+const (
+	RENDEZVOUS_MAGIC    = "XLRV1"
+	RENDEZVOUS_REGISTER = "REGISTER"
+	RENDEZVOUS_PEER     = "PEER"
+
+	RENDEZVOUS_TTL = 60 * time.Second
+
+	// The longest session name accepted, in bytes.
+	MAX_RENDEZVOUS_SESSION = 64
+)
+
+type RendezvousServer struct {
+	conn     *net.UDPConn
+	endPoint *UdpEndPoint
+	mu       sync.Mutex
+	sessions map[string]*rendezvous
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// The peers which have named a session.
+type rendezvous struct {
+	peers    []*net.UDPAddr // at most two
+	lastUsed time.Time
+}
+
+// Whether session is a name a RendezvousServer accepts.
+func validSession(session string) bool {
+	words := strings.Fields(session)
+	return len(session) <= MAX_RENDEZVOUS_SESSION &&
+		len(words) == 1 && words[0] == session
+}
+
+// Listen for peers at strAddr.
+func NewRendezvousServer(strAddr string) (*RendezvousServer, error) {
+	addr, err := net.ResolveUDPAddr("udp", strAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, wrapError("listen", "udp", nil, nil, err)
+	}
+	s := &RendezvousServer{
+		conn:     conn,
+		endPoint: &UdpEndPoint{conn.LocalAddr().(*net.UDPAddr)},
+		sessions: make(map[string]*rendezvous),
+		closed:   make(chan struct{}),
+	}
+	go s.serve()
+	return s, nil
+}
+
+// Answer requests until the server is closed.  A temporary failure to
+// read is retried after a pause; any other closes the server.
+func (s *RendezvousServer) serve() {
+	buf := make([]byte, 2*MAX_RENDEZVOUS_SESSION)
+	var delay time.Duration
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !IsTemporary(err) {
+				s.Close()
+				return
+			}
+			delay = acceptRetryDelay(delay)
+			select {
+			case <-time.After(delay):
+			case <-s.closed:
+				return
+			}
+			continue
+		}
+		delay = 0
+		words := strings.Fields(string(buf[:n]))
+		if len(words) != 3 || words[0] != RENDEZVOUS_MAGIC ||
+			words[1] != RENDEZVOUS_REGISTER || !validSession(words[2]) {
+			continue
+		}
+		if other := s.register(words[2], from); other != nil {
+			s.conn.WriteToUDP([]byte(RENDEZVOUS_MAGIC+" "+
+				RENDEZVOUS_PEER+" "+other.String()), from)
+		}
+	}
+}
+
+// Note that from has named session, returning the other peer in it if
+// there is one yet.
+func (s *RendezvousServer) register(session string, from *net.UDPAddr) (
+	other *net.UDPAddr) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for name, rv := range s.sessions {
+		if now.Sub(rv.lastUsed) > RENDEZVOUS_TTL {
+			delete(s.sessions, name)
+		}
+	}
+	rv := s.sessions[session]
+	if rv == nil {
+		rv = &rendezvous{}
+		s.sessions[session] = rv
+	}
+	known := false
+	for _, p := range rv.peers {
+		if p.IP.Equal(from.IP) && p.Port == from.Port {
+			known = true
+		} else {
+			other = p
+		}
+	}
+	if !known {
+		if len(rv.peers) == 2 {
+			// a third party; ignore it
+			return nil
+		}
+		rv.peers = append(rv.peers, from)
+	}
+	rv.lastUsed = now
+	return
+}
+
+// Stop serving.  Sessions are forgotten.
+func (s *RendezvousServer) Close() (err error) {
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.conn.Close()
+	})
+	return
+}
+
+func (s *RendezvousServer) GetEndPoint() EndPointI {
+	return s.endPoint
+}
+
+func (s *RendezvousServer) String() string {
+	return "RendezvousServer: " + s.endPoint.String()
+}
@@ -0,0 +1,126 @@
+package transport
+
+// xlTransport_go/rudp_acceptor.go
+
+import (
+	"net"
+	"sync"
+)
+
+// Accepts RudpConnections on a UDP socket.  A connection is made as
+// soon as its SYN arrives and waits, like one in a listen queue, until
+// Accept takes it; while RUDP_BACKLOG connections are waiting, further
+// SYNs are dropped, to be sent again.
+//
+// Closing the acceptor stops it taking new connections.  Those already
+// accepted are not affected: the socket stays open until they have
+// been released.
+
+const RUDP_BACKLOG = 64
+
+type RudpAcceptor struct {
+	endPoint  *UdpEndPoint
+	mux       *rudpMux
+	cnxs      chan *RudpConnection
+	done      chan struct{}
+	closeOnce sync.Once
+	stats     acceptorCounters
+}
+
+// Listen at strAddr, which may give port 0 to have one chosen.
+func NewRudpAcceptor(strAddr string) (*RudpAcceptor, error) {
+	addr, err := net.ResolveUDPAddr("udp", strAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, wrapError("listen", "rudp", nil, nil, err)
+	}
+	return NewRudpAcceptorOn(conn), nil
+}
+
+// Listen on pc, a UDP socket or something standing in for one, which
+// the acceptor and its connections then own.
+func NewRudpAcceptorOn(pc net.PacketConn) *RudpAcceptor {
+	ep, _ := NewUdpEndPoint(pc.LocalAddr().String())
+	a := &RudpAcceptor{
+		endPoint: ep,
+		cnxs:     make(chan *RudpConnection, RUDP_BACKLOG),
+		done:     make(chan struct{}),
+	}
+	a.mux = newRudpMux(pc, a, RealClock{})
+	go a.mux.readLoop()
+	return a
+}
+
+// Set the clock timing connections accepted from now on.
+func (a *RudpAcceptor) SetClock(clock ClockI) {
+	a.mux.mu.Lock()
+	defer a.mux.mu.Unlock()
+	a.mux.clock = clock
+}
+
+// A SYN has come for a new connection.  The caller holds the mux's
+// lock.
+func (a *RudpAcceptor) incoming(from net.Addr, id uint32) *RudpConnection {
+	if len(a.cnxs) == cap(a.cnxs) {
+		a.stats.countReject()
+		return nil
+	}
+	cnx := newRudpConnection(a.mux, from, id, false)
+	cnx.closeEstablished()
+	a.mux.add(cnx)
+	a.cnxs <- cnx
+	return cnx
+}
+
+func (a *RudpAcceptor) Accept() (ConnectionI, error) {
+	select {
+	case cnx := <-a.cnxs:
+		a.stats.countAccept(&cnx.stats)
+		observeAccept(cnx)
+		return cnx, nil
+	case <-a.done:
+		return nil, AcceptorClosed
+	}
+}
+
+// Stop accepting connections, closing any not yet taken by Accept.
+func (a *RudpAcceptor) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.done)
+		a.mux.stopListening()
+		for {
+			select {
+			case cnx := <-a.cnxs:
+				cnx.Close()
+				a.stats.countReject()
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+func (a *RudpAcceptor) IsClosed() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *RudpAcceptor) GetEndPoint() EndPointI {
+	return a.endPoint
+}
+
+func (a *RudpAcceptor) Stats() AcceptorStats {
+	return a.stats.snapshot()
+}
+
+func (a *RudpAcceptor) String() string {
+	return "RudpAcceptor: " + a.endPoint.String()
+}
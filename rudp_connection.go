@@ -0,0 +1,706 @@
+package transport
+
+// xlTransport_go/rudp_connection.go
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	xc "github.com/jddixon/xlCrypto_go"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A reliable, ordered, congestion-controlled byte stream carried over
+// UDP datagrams, for nodes which can reach each other by UDP alone:
+// because TCP is blocked, say, or because the path was opened by hole
+// punching.
+//
+// Every packet begins with its type and a connection ID chosen by the
+// client, so that one socket can carry many connections:
+//
+//	SYN     type(1) id(4)                   client opens the connection
+//	SYNACK  type(1) id(4)                   server answers each SYN
+//	DATA    type(1) id(4) seq(4) flags(1) payload
+//	ACK     type(1) id(4) next(4) window(2) nBlocks(1) [start(4) end(4)]...
+//	PROBE   type(1) id(4)                   asks for an ACK
+//	RST     type(1) id(4)                   no such connection
+//
+// Each direction numbers its segments from zero, one per DATA packet.
+// An ACK gives the next segment expected, the number more the receiver
+// has room for, and up to RUDP_MAX_SACK blocks of segments received
+// beyond a gap.  Every DATA packet is acknowledged.
+//
+// The sender keeps no more segments in flight than the smaller of the
+// receiver's window and its congestion window, which grows by one
+// segment per segment acknowledged until it passes the slow start
+// threshold, and by one segment per window thereafter.  A segment with
+// RUDP_DUP_THRESH segments above it acknowledged is taken to be lost:
+// it is resent at once and the congestion window halved, once per
+// window of data.  If nothing is acknowledged for the retransmission
+// timeout, computed from the round trip time as TCP does (RFC 6298),
+// every unacknowledged segment is resent, starting again from a
+// congestion window of one segment, and the timeout doubled.  After
+// RUDP_MAX_RETRIES timeouts in a row the connection fails with
+// RetransmitLimit.  When the receiver's window is closed the sender
+// probes it at the same interval.
+//
+// Close sends a segment flagged FIN after any data still queued, and
+// returns at once.  The far end reads io.EOF once it has read
+// everything before the FIN.  The connection's resources are released
+// shortly after the FINs in both directions have been acknowledged, or
+// RUDP_FIN_WAIT after ours has been if the far end never closes.
+
+const (
+	RUDP_SYN    = 1
+	RUDP_SYNACK = 2
+	RUDP_DATA   = 3
+	RUDP_ACK    = 4
+	RUDP_PROBE  = 5
+	RUDP_RST    = 6
+
+	RUDP_FLAG_FIN = 0x01
+
+	RUDP_HEADER_LEN = 5
+
+	// The most payload carried by one DATA packet, small enough that
+	// packets are not fragmented on most paths.
+	RUDP_MSS = 1200
+
+	// The most segments a receiver holds, read or not, and the most a
+	// sender queues before Write blocks.
+	RUDP_WINDOW      = 512
+	RUDP_SEND_BUFFER = 512
+
+	RUDP_MAX_SACK     = 16
+	RUDP_DUP_THRESH   = 3
+	RUDP_INITIAL_CWND = 10
+
+	RUDP_INITIAL_RTO = 200 * time.Millisecond
+	RUDP_MIN_RTO     = 50 * time.Millisecond
+	RUDP_MAX_RTO     = 4 * time.Second
+	RUDP_MAX_RETRIES = 10
+
+	// The most time a connection lingers after closing, waiting for the
+	// far end to close too.
+	RUDP_FIN_WAIT = 10 * time.Second
+)
+
+type rudpSegment struct {
+	seq  uint32
+	data []byte
+	fin  bool
+
+	sentAt        time.Time
+	retransmitted bool // so not to be used for timing (Karn)
+	sacked        bool // received beyond a gap
+	needRetx      bool // to be resent as the window allows
+	fastRetx      bool // already resent as lost
+}
+
+// Whether sequence number a comes before b.
+func seqBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+type RudpConnection struct {
+	mux       *rudpMux
+	far       net.Addr
+	id        uint32
+	client    bool
+	near, fEp *UdpEndPoint
+	state     int
+	clock     ClockI
+	stats     connCounters
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	established chan struct{} // closed once the handshake is over
+	estClosed   bool
+
+	// sending
+	sndUna     uint32         // the first segment not acknowledged
+	sndQ       []*rudpSegment // from sndUna on
+	nSent      int            // sndQ[:nSent] have been sent at least once
+	cwnd       float64
+	ssthresh   float64
+	rwnd       int
+	inRecovery bool
+	recoverSeq uint32
+	srtt       time.Duration
+	rttvar     time.Duration
+	rto        time.Duration
+	retries    int
+	rtoTimer   TimerI
+	rtoGen     int // so that a stale timer does nothing
+	finAcked   bool
+
+	// receiving
+	rcvNxt      uint32
+	ooo         map[uint32]*rudpSegment // received beyond a gap
+	rBuf        bytes.Buffer
+	finReceived bool
+	lastAdv     int // the window last advertised
+
+	closedLocal bool
+	err         error // why the connection failed, if it has
+	lingerTimer TimerI
+	lingerShort bool
+	released    bool
+}
+
+func newRudpConnection(mux *rudpMux, far net.Addr, id uint32,
+	client bool) *RudpConnection {
+
+	near, _ := NewUdpEndPoint(mux.pc.LocalAddr().String())
+	fEp, _ := NewUdpEndPoint(far.String())
+	c := &RudpConnection{
+		mux:         mux,
+		far:         far,
+		id:          id,
+		client:      client,
+		near:        near,
+		fEp:         fEp,
+		state:       CNX_CONNECTED,
+		clock:       mux.clock,
+		established: make(chan struct{}),
+		cwnd:        RUDP_INITIAL_CWND,
+		ssthresh:    RUDP_WINDOW,
+		rwnd:        RUDP_WINDOW,
+		rto:         RUDP_INITIAL_RTO,
+		ooo:         make(map[uint32]*rudpSegment),
+		lastAdv:     RUDP_WINDOW,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	c.stats.init(c, "rudp", c.clock)
+	return c
+}
+
+// PACKETS //////////////////////////////////////////////////////////
+
+func (c *RudpConnection) header(kind byte, extra int) []byte {
+	pkt := make([]byte, RUDP_HEADER_LEN, RUDP_HEADER_LEN+extra)
+	pkt[0] = kind
+	binary.BigEndian.PutUint32(pkt[1:], c.id)
+	return pkt
+}
+
+// Send a packet carrying nothing but its type.
+func (c *RudpConnection) sendCtl(kind byte) {
+	c.mux.pc.WriteTo(c.header(kind, 0), c.far)
+}
+
+// Send a segment, for the first time or again.  The caller holds mu.
+func (c *RudpConnection) sendSegment(s *rudpSegment, retx bool) {
+	pkt := c.header(RUDP_DATA, 5+len(s.data))
+	pkt = binary.BigEndian.AppendUint32(pkt, s.seq)
+	var flags byte
+	if s.fin {
+		flags |= RUDP_FLAG_FIN
+	}
+	pkt = append(append(pkt, flags), s.data...)
+	s.sentAt = c.clock.Now()
+	s.needRetx = false
+	if retx {
+		s.retransmitted = true
+	}
+	c.mux.pc.WriteTo(pkt, c.far)
+}
+
+// The number of segments there is room for.  The caller holds mu.
+func (c *RudpConnection) window() int {
+	w := RUDP_WINDOW - len(c.ooo) - (c.rBuf.Len()+RUDP_MSS-1)/RUDP_MSS
+	if w < 0 {
+		w = 0
+	}
+	return w
+}
+
+// Acknowledge what has been received.  The caller holds mu.
+func (c *RudpConnection) sendAck() {
+	var seqs []uint32
+	for seq := range c.ooo {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqBefore(seqs[i], seqs[j]) })
+	var blocks [][2]uint32
+	for _, seq := range seqs {
+		if n := len(blocks); n > 0 && blocks[n-1][1] == seq {
+			blocks[n-1][1]++
+		} else if n < RUDP_MAX_SACK {
+			blocks = append(blocks, [2]uint32{seq, seq + 1})
+		} else {
+			break
+		}
+	}
+	w := c.window()
+	pkt := c.header(RUDP_ACK, 7+8*len(blocks))
+	pkt = binary.BigEndian.AppendUint32(pkt, c.rcvNxt)
+	pkt = binary.BigEndian.AppendUint16(pkt, uint16(w))
+	pkt = append(pkt, byte(len(blocks)))
+	for _, b := range blocks {
+		pkt = binary.BigEndian.AppendUint32(pkt, b[0])
+		pkt = binary.BigEndian.AppendUint32(pkt, b[1])
+	}
+	c.lastAdv = w
+	c.mux.pc.WriteTo(pkt, c.far)
+}
+
+// Act on a packet from the far end.  body follows the header and may
+// be reused once this returns.
+func (c *RudpConnection) input(kind byte, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.released {
+		return
+	}
+	switch kind {
+	case RUDP_SYN:
+		if !c.client {
+			c.sendCtl(RUDP_SYNACK)
+		}
+	case RUDP_SYNACK:
+		if c.client {
+			c.closeEstablished()
+		}
+	case RUDP_DATA:
+		if len(body) >= 5 {
+			c.onData(binary.BigEndian.Uint32(body),
+				body[4]&RUDP_FLAG_FIN != 0, body[5:])
+		}
+	case RUDP_ACK:
+		if len(body) < 7 || len(body) < 7+8*int(body[6]) {
+			return
+		}
+		blocks := make([][2]uint32, body[6])
+		for i := range blocks {
+			b := body[7+8*i:]
+			blocks[i] = [2]uint32{binary.BigEndian.Uint32(b),
+				binary.BigEndian.Uint32(b[4:])}
+		}
+		c.onAck(binary.BigEndian.Uint32(body),
+			int(binary.BigEndian.Uint16(body[4:])), blocks)
+	case RUDP_PROBE:
+		c.sendAck()
+	case RUDP_RST:
+		c.fail(ConnectionReset)
+	}
+}
+
+func (c *RudpConnection) closeEstablished() {
+	if !c.estClosed {
+		c.estClosed = true
+		close(c.established)
+	}
+}
+
+// RECEIVING ////////////////////////////////////////////////////////
+
+func (c *RudpConnection) onData(seq uint32, fin bool, data []byte) {
+	if off := int32(seq - c.rcvNxt); off >= 0 && off < RUDP_WINDOW {
+		if _, dup := c.ooo[seq]; !dup {
+			c.ooo[seq] = &rudpSegment{seq: seq, data: append([]byte(nil), data...),
+				fin: fin}
+		}
+		for s := c.ooo[c.rcvNxt]; s != nil; s = c.ooo[c.rcvNxt] {
+			delete(c.ooo, c.rcvNxt)
+			c.rcvNxt++
+			if !c.closedLocal {
+				c.rBuf.Write(s.data)
+			}
+			if s.fin {
+				c.finReceived = true
+			}
+		}
+		c.cond.Broadcast()
+	}
+	c.sendAck()
+	c.maybeRelease()
+}
+
+// SENDING //////////////////////////////////////////////////////////
+
+// The number of segments sent and neither acknowledged nor given up
+// as lost.  The caller holds mu.
+func (c *RudpConnection) inFlight() (n int) {
+	for _, s := range c.sndQ[:c.nSent] {
+		if !s.sacked && !s.needRetx {
+			n++
+		}
+	}
+	return
+}
+
+// Send what the windows allow, segments to be resent first.  The
+// caller holds mu.
+func (c *RudpConnection) trySend() {
+	if c.err != nil {
+		return
+	}
+	window := int(c.cwnd)
+	if c.rwnd < window {
+		window = c.rwnd
+	}
+	flight := c.inFlight()
+	for _, s := range c.sndQ[:c.nSent] {
+		if flight >= window {
+			break
+		}
+		if s.needRetx {
+			c.sendSegment(s, true)
+			flight++
+		}
+	}
+	for c.nSent < len(c.sndQ) && flight < window {
+		c.sendSegment(c.sndQ[c.nSent], false)
+		c.nSent++
+		flight++
+	}
+	if len(c.sndQ) > 0 {
+		// awaiting acknowledgement, or a window to send into
+		c.armRto()
+	}
+}
+
+func (c *RudpConnection) sampleRtt(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt, c.rttvar = rtt, rtt/2
+	} else {
+		diff := c.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		c.rttvar = (3*c.rttvar + diff) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < RUDP_MIN_RTO {
+		c.rto = RUDP_MIN_RTO
+	} else if c.rto > RUDP_MAX_RTO {
+		c.rto = RUDP_MAX_RTO
+	}
+}
+
+func (c *RudpConnection) onAck(next uint32, rwnd int, blocks [][2]uint32) {
+	acked := int(int32(next - c.sndUna))
+	if acked < 0 || acked > c.nSent {
+		return // stale or bogus
+	}
+	c.retries = 0
+	c.rwnd = rwnd
+	if acked > 0 {
+		if last := c.sndQ[acked-1]; !last.retransmitted {
+			c.sampleRtt(c.clock.Now().Sub(last.sentAt))
+		}
+		for _, s := range c.sndQ[:acked] {
+			if s.fin {
+				c.finAcked = true
+			}
+			if c.inRecovery {
+				continue
+			} else if c.cwnd < c.ssthresh {
+				c.cwnd++
+			} else {
+				c.cwnd += 1 / c.cwnd
+			}
+		}
+		if c.cwnd > 2*RUDP_WINDOW {
+			c.cwnd = 2 * RUDP_WINDOW
+		}
+		c.sndQ = c.sndQ[acked:]
+		c.nSent -= acked
+		c.sndUna = next
+		if c.inRecovery && !seqBefore(next, c.recoverSeq) {
+			c.inRecovery = false
+		}
+		c.stopRto()
+		c.cond.Broadcast()
+	}
+	for _, b := range blocks {
+		for seq := b[0]; seqBefore(seq, b[1]); seq++ {
+			i := int(int32(seq - c.sndUna))
+			if i >= c.nSent {
+				break
+			}
+			if i >= 0 {
+				c.sndQ[i].sacked = true
+				c.sndQ[i].needRetx = false
+			}
+		}
+	}
+
+	// resend at once what is evidently lost
+	above := 0
+	for i := c.nSent - 1; i >= 0; i-- {
+		s := c.sndQ[i]
+		if s.sacked {
+			above++
+		} else if above >= RUDP_DUP_THRESH && !s.fastRetx && !s.needRetx {
+			if !c.inRecovery {
+				c.ssthresh = c.cwnd / 2
+				if c.ssthresh < 2 {
+					c.ssthresh = 2
+				}
+				c.cwnd = c.ssthresh
+				c.inRecovery = true
+				c.recoverSeq = c.sndUna + uint32(c.nSent)
+			}
+			s.fastRetx = true
+			c.sendSegment(s, true)
+		}
+	}
+	c.trySend()
+	c.maybeRelease()
+}
+
+// Start the retransmission timer if it is not running.  The caller
+// holds mu.
+func (c *RudpConnection) armRto() {
+	if c.rtoTimer == nil {
+		gen := c.rtoGen
+		c.rtoTimer = c.clock.AfterFunc(c.rto, func() { c.onRto(gen) })
+	}
+}
+
+func (c *RudpConnection) stopRto() {
+	if c.rtoTimer != nil {
+		c.rtoTimer.Stop()
+		c.rtoTimer = nil
+		c.rtoGen++
+	}
+}
+
+func (c *RudpConnection) onRto(gen int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.rtoGen || c.released || c.err != nil {
+		return
+	}
+	c.rtoTimer = nil
+	c.rtoGen++
+	if c.retries >= RUDP_MAX_RETRIES {
+		c.fail(RetransmitLimit)
+		return
+	}
+	c.retries++
+	if c.rto *= 2; c.rto > RUDP_MAX_RTO {
+		c.rto = RUDP_MAX_RTO
+	}
+	flight := c.inFlight()
+	if flight == 0 {
+		// the window is closed, or the ACK covering it all was lost
+		c.sendCtl(RUDP_PROBE)
+		c.armRto()
+		return
+	}
+	c.ssthresh = float64(flight) / 2
+	if c.ssthresh < 2 {
+		c.ssthresh = 2
+	}
+	c.cwnd = 1
+	c.inRecovery = false
+	for _, s := range c.sndQ[:c.nSent] {
+		if !s.sacked {
+			s.needRetx = true
+			s.fastRetx = false
+		}
+	}
+	c.trySend()
+}
+
+// TEARDOWN /////////////////////////////////////////////////////////
+
+// The connection is dead.  The caller holds mu.
+func (c *RudpConnection) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.closeEstablished()
+	c.cond.Broadcast()
+	c.release()
+}
+
+// Release the connection once both ends are done with it.  The caller
+// holds mu.
+func (c *RudpConnection) maybeRelease() {
+	if c.released || !c.closedLocal || !c.finAcked {
+		return
+	}
+	short := c.finReceived
+	if c.lingerTimer != nil {
+		if !short || c.lingerShort {
+			return
+		}
+		c.lingerTimer.Stop()
+	}
+	// having closed, linger long enough to acknowledge the far end's
+	// FIN again should our ACK be lost
+	d := RUDP_FIN_WAIT
+	if short {
+		d = 3 * c.rto
+	}
+	c.lingerShort = short
+	c.lingerTimer = c.clock.AfterFunc(d, func() {
+		c.mu.Lock()
+		c.release()
+		c.mu.Unlock()
+	})
+}
+
+// Stop all timers and give up the connection's place in the socket.
+// The caller holds mu.
+func (c *RudpConnection) release() {
+	if !c.released {
+		c.released = true
+		c.stopRto()
+		if c.lingerTimer != nil {
+			c.lingerTimer.Stop()
+		}
+		c.mux.remove(c)
+	}
+}
+
+// CONNECTIONI //////////////////////////////////////////////////////
+
+// Return the current state index.
+func (c *RudpConnection) GetState() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+func (c *RudpConnection) BindNearEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+func (c *RudpConnection) BindFarEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+// Send a FIN after anything still queued and bring the connection to
+// the DISCONNECTED state.  Anything received but not yet read is
+// discarded.
+func (c *RudpConnection) Close() (err error) {
+	c.mu.Lock()
+	if c.closedLocal {
+		c.mu.Unlock()
+		return
+	}
+	observeState(c, c.state, CNX_DISCONNECTED)
+	c.state = CNX_DISCONNECTED
+	c.closedLocal = true
+	c.rBuf.Reset()
+	if c.err == nil {
+		c.sndQ = append(c.sndQ, &rudpSegment{
+			seq: c.sndUna + uint32(len(c.sndQ)), fin: true})
+		c.trySend()
+	} else {
+		c.release()
+	}
+	c.cond.Broadcast()
+	c.maybeRelease()
+	c.mu.Unlock()
+	c.stats.countClose()
+	return
+}
+
+func (c *RudpConnection) GetNearEnd() EndPointI {
+	return c.near
+}
+
+func (c *RudpConnection) GetFarEnd() EndPointI {
+	return c.fEp
+}
+
+// Wrap an error from operation op in a TransportError.
+func (c *RudpConnection) wrap(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return wrapError(op, "rudp", c.near, c.fEp, err)
+}
+
+func (c *RudpConnection) Read(b []byte) (count int, err error) {
+	c.mu.Lock()
+	for c.rBuf.Len() == 0 && !c.finReceived && c.err == nil && !c.closedLocal {
+		c.cond.Wait()
+	}
+	switch {
+	case c.closedLocal:
+		err = ClosedConnection
+	case c.rBuf.Len() > 0:
+		count, _ = c.rBuf.Read(b)
+		// tell the far end once the window has opened appreciably
+		if c.err == nil && c.window()-c.lastAdv >= RUDP_WINDOW/4 {
+			c.sendAck()
+		}
+	case c.finReceived:
+		err = io.EOF
+	default:
+		err = c.err
+	}
+	c.mu.Unlock()
+	err = c.wrap("read", err)
+	c.stats.countRead(count, err)
+	return
+}
+
+// Queue b for sending, blocking while the send buffer is full.
+func (c *RudpConnection) Write(b []byte) (count int, err error) {
+	c.mu.Lock()
+	for count < len(b) {
+		if c.closedLocal {
+			err = ClosedConnection
+			break
+		}
+		if c.err != nil {
+			err = c.err
+			break
+		}
+		if len(c.sndQ) >= RUDP_SEND_BUFFER {
+			c.trySend()
+			c.cond.Wait()
+			continue
+		}
+		n := len(b) - count
+		if n > RUDP_MSS {
+			n = RUDP_MSS
+		}
+		c.sndQ = append(c.sndQ, &rudpSegment{
+			seq:  c.sndUna + uint32(len(c.sndQ)),
+			data: append([]byte(nil), b[count:count+n]...),
+		})
+		count += n
+	}
+	c.trySend()
+	c.mu.Unlock()
+	err = c.wrap("write", err)
+	c.stats.countWrite(count, err)
+	return
+}
+
+func (c *RudpConnection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
+func (c *RudpConnection) IsBlocking() bool {
+	return false
+}
+func (c *RudpConnection) IsEncrypted() bool {
+	return false
+}
+func (c *RudpConnection) Negotiate(myKey xc.KeyI, hisKey xc.PublicKeyI) (
+	s xc.SecretI, e error) {
+
+	return nil, NotImplemented
+}
+
+func (c *RudpConnection) Equal(any interface{}) bool {
+	return any == c
+}
+
+func (c *RudpConnection) String() string {
+	return fmt.Sprintf("Rudp: %s --> %s", c.near.String(), c.fEp.String())
+}
@@ -0,0 +1,142 @@
+package transport
+
+// xlTransport_go/rudp_connector.go
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// Makes RudpConnections to a RudpAcceptor, each from a UDP socket of
+// its own.
+
+const (
+	// The most time Connect spends sending SYNs.
+	RUDP_CONNECT_TIMEOUT = 10 * time.Second
+)
+
+type RudpConnector struct {
+	farEnd *UdpEndPoint
+	clock  ClockI
+}
+
+func NewRudpConnector(farEnd EndPointI) (*RudpConnector, error) {
+	ep, ok := farEnd.(*UdpEndPoint)
+	if !ok || ep == nil {
+		return nil, NotUdpEndPoint
+	}
+	ep2, _ := ep.Clone()
+	return &RudpConnector{farEnd: ep2.(*UdpEndPoint), clock: RealClock{}}, nil
+}
+
+// A UDP socket connected to one far end, which ignores the address
+// WriteTo is given.
+type dialedPacketConn struct {
+	*net.UDPConn
+}
+
+func (d dialedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return d.UDPConn.Write(b)
+}
+
+// Connect from a new socket, bound to nearEnd if it is not nil.
+func (c *RudpConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
+	var laddr *net.UDPAddr
+	if nearEnd != nil {
+		ep, ok := nearEnd.(*UdpEndPoint)
+		if !ok {
+			return nil, NotUdpEndPoint
+		}
+		laddr = ep.udpAddr
+	}
+	conn, err := net.DialUDP("udp", laddr, c.farEnd.udpAddr)
+	if err != nil {
+		return nil, wrapError("dial", "rudp", nearEnd, c.farEnd, err)
+	}
+	return c.ConnectOn(dialedPacketConn{conn})
+}
+
+// Connect from pc, a UDP socket or something standing in for one,
+// which the connection then owns.  pc is closed if this fails.
+func (c *RudpConnector) ConnectOn(pc net.PacketConn) (*RudpConnection, error) {
+	var idBytes [4]byte
+	rand.Read(idBytes[:])
+	mux := newRudpMux(pc, nil, c.clock)
+	cnx := newRudpConnection(mux, c.farEnd.udpAddr,
+		binary.BigEndian.Uint32(idBytes[:]), true)
+	mux.mu.Lock()
+	mux.add(cnx)
+	mux.mu.Unlock()
+	go mux.readLoop()
+
+	finish := observeDial(cnx.near, c.farEnd)
+	start := c.clock.Now()
+	err := cnx.handshake(start.Add(RUDP_CONNECT_TIMEOUT))
+	DefaultMetrics.transport("rudp").countDial(err)
+	if err != nil {
+		cnx.mu.Lock()
+		cnx.fail(err)
+		cnx.mu.Unlock()
+		cnx.stats.countClose()
+		err = wrapError("dial", "rudp", cnx.near, c.farEnd, err)
+		finish(nil, err)
+		return nil, err
+	}
+	cnx.stats.setConnectLatency(c.clock.Now().Sub(start))
+	finish(cnx, nil)
+	return cnx, nil
+}
+
+// Send SYNs, backing off as retransmissions do, until one is answered
+// or the deadline passes.
+func (c *RudpConnection) handshake(deadline time.Time) error {
+	start := c.clock.Now()
+	rto := RUDP_INITIAL_RTO
+	for sent := 0; ; sent++ {
+		c.sendCtl(RUDP_SYN)
+		wait := deadline.Sub(c.clock.Now())
+		if wait > rto {
+			wait = rto
+		}
+		expired := make(chan struct{})
+		timer := c.clock.AfterFunc(wait, func() { close(expired) })
+		select {
+		case <-c.established:
+			timer.Stop()
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			switch c.err {
+			case nil:
+				if sent == 0 {
+					c.sampleRtt(c.clock.Now().Sub(start))
+				}
+				return nil
+			case ConnectionReset:
+				// turned away by a socket not listening
+				return ConnectionRefused
+			default:
+				return c.err
+			}
+		case <-expired:
+			if !c.clock.Now().Before(deadline) {
+				return ConnectTimeout
+			}
+			rto *= 2
+		}
+	}
+}
+
+// Set the clock timing connections made from now on.
+func (c *RudpConnector) SetClock(clock ClockI) {
+	c.clock = clock
+}
+
+func (c *RudpConnector) GetFarEnd() EndPointI {
+	return c.farEnd
+}
+
+func (c *RudpConnector) String() string {
+	return "RudpConnector: " + c.farEnd.udpAddr.String()
+}
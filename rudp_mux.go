@@ -0,0 +1,140 @@
+package transport
+
+// xlTransport_go/rudp_mux.go
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// A UDP socket shared by RudpConnections, which reads packets and
+// hands each to the connection it belongs to, by the address it came
+// from and its connection ID.  A socket listening for connections
+// passes each SYN for a connection it does not know to its acceptor;
+// any other packet for an unknown connection is answered with an RST.
+//
+// The socket is closed once it is no longer listening and its last
+// connection has been released.
+
+type rudpMux struct {
+	pc       net.PacketConn
+	mu       sync.Mutex
+	conns    map[string]*RudpConnection
+	acceptor *RudpAcceptor // nil if not listening
+	clock    ClockI        // for connections made from now on
+	closed   bool
+}
+
+func newRudpMux(pc net.PacketConn, acceptor *RudpAcceptor,
+	clock ClockI) *rudpMux {
+
+	return &rudpMux{
+		pc:       pc,
+		conns:    make(map[string]*RudpConnection),
+		acceptor: acceptor,
+		clock:    clock,
+	}
+}
+
+func rudpKey(addr net.Addr, id uint32) string {
+	return fmt.Sprintf("%s/%08x", addr.String(), id)
+}
+
+func (m *rudpMux) add(c *RudpConnection) {
+	m.conns[rudpKey(c.far, c.id)] = c
+}
+
+func (m *rudpMux) readLoop() {
+	buf := make([]byte, UDP_MAX_DATAGRAM)
+	for {
+		n, from, err := m.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if errors.Is(err, syscall.ECONNREFUSED) {
+				// an ICMP port unreachable: nothing is listening at
+				// the far end of a dialed socket, or nothing was for a
+				// moment.  Like TCP, treat it as fatal only to a
+				// connection not yet made; the socket is closed if it
+				// is now idle.
+				m.failAll(true)
+				continue
+			}
+			m.failAll(false)
+			return
+		}
+		if n < RUDP_HEADER_LEN {
+			continue
+		}
+		kind, id := buf[0], binary.BigEndian.Uint32(buf[1:])
+		m.mu.Lock()
+		c := m.conns[rudpKey(from, id)]
+		listening := m.acceptor != nil
+		if c == nil && kind == RUDP_SYN && listening {
+			c = m.acceptor.incoming(from, id)
+		}
+		m.mu.Unlock()
+		if c != nil {
+			c.input(kind, buf[RUDP_HEADER_LEN:n])
+		} else if kind != RUDP_RST && (kind != RUDP_SYN || !listening) {
+			// a SYN turned away by a full backlog is dropped, and will
+			// be sent again
+			rst := append([]byte{RUDP_RST}, buf[1:RUDP_HEADER_LEN]...)
+			m.pc.WriteTo(rst, from)
+		}
+	}
+}
+
+// Fail every connection on the socket, or if pendingOnly only those
+// not yet made.  A connection not yet made is refused, one made is
+// reset.
+func (m *rudpMux) failAll(pendingOnly bool) {
+	m.mu.Lock()
+	var conns []*RudpConnection
+	for _, c := range m.conns {
+		conns = append(conns, c)
+	}
+	m.mu.Unlock()
+	for _, c := range conns {
+		c.mu.Lock()
+		if !c.estClosed {
+			c.fail(ConnectionRefused)
+		} else if !pendingOnly {
+			c.fail(ConnectionReset)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Forget a connection, closing the socket if it was the last and the
+// socket is not listening.
+func (m *rudpMux) remove(c *RudpConnection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := rudpKey(c.far, c.id)
+	if m.conns[key] == c {
+		delete(m.conns, key)
+	}
+	m.closeIfIdle()
+}
+
+// Stop passing SYNs to the acceptor.
+func (m *rudpMux) stopListening() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acceptor = nil
+	m.closeIfIdle()
+}
+
+// The caller holds mu.
+func (m *rudpMux) closeIfIdle() {
+	if !m.closed && m.acceptor == nil && len(m.conns) == 0 {
+		m.closed = true
+		m.pc.Close()
+	}
+}
@@ -0,0 +1,158 @@
+package transport
+
+// xlTransport_go/rudp_test.go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+func (s *XLSuite) TestRudp(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_RUDP")
+	}
+	rng := xr.MakeSimpleRNG()
+	acc, err := NewRudpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+
+	// the connector survives serialization
+	ctor, err := NewRudpConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	ctor2, err := ParseConnector(ctor.String())
+	c.Assert(err, IsNil)
+	c.Assert(ctor2.String(), Equals, ctor.String())
+	tcpEp, err := NewTcpEndPoint("127.0.0.1:80")
+	c.Assert(err, IsNil)
+	_, err = NewRudpConnector(tcpEp)
+	c.Assert(err, Equals, NotUdpEndPoint)
+
+	cnx, err := ctor2.Connect(nil)
+	c.Assert(err, IsNil)
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	c.Assert(cnx.GetFarEnd().Equal(acc.GetEndPoint()), Equals, true)
+	c.Assert(server.GetFarEnd().Equal(cnx.GetNearEnd()), Equals, true)
+
+	// a message spanning many segments, each way
+	msg := make([]byte, 10*RUDP_MSS+17)
+	rng.NextBytes(msg)
+	go func() {
+		cnx.Write(msg)
+		cnx.Close()
+	}()
+	got := make([]byte, len(msg))
+	_, err = io.ReadFull(server, got)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(got, msg), Equals, true)
+	_, err = server.Read(got)
+	c.Assert(err, Equals, io.EOF)
+	c.Assert(server.Close(), IsNil)
+	_, err = server.Write(msg)
+	c.Assert(IsClosed(err), Equals, true)
+
+	// nothing listening
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	silent, _ := NewUdpEndPoint(pc.LocalAddr().String())
+	pc.Close()
+	ctor, err = NewRudpConnector(silent)
+	c.Assert(err, IsNil)
+	active := atomic.LoadInt64(&DefaultMetrics.transport("rudp").active)
+	_, err = ctor.Connect(nil)
+	c.Assert(IsRefused(err), Equals, true)
+	c.Assert(atomic.LoadInt64(&DefaultMetrics.transport("rudp").active),
+		Equals, active)
+}
+
+// The handshake is timed by the connector's clock.
+func (s *XLSuite) TestRudpConnectTimeout(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_RUDP_CONNECT_TIMEOUT")
+	}
+	// a socket which never answers
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer pc.Close()
+	silent, _ := NewUdpEndPoint(pc.LocalAddr().String())
+	ctor, err := NewRudpConnector(silent)
+	c.Assert(err, IsNil)
+	start := time.Unix(0, 0)
+	clock := NewVirtualClock(start)
+	ctor.SetClock(clock)
+	result := make(chan error, 1)
+	go func() {
+		_, err := ctor.Connect(nil)
+		result <- err
+	}()
+	for {
+		select {
+		case err = <-result:
+		default:
+			if clock.Pending() == 0 {
+				time.Sleep(time.Millisecond)
+			} else {
+				clock.Advance(RUDP_MAX_RTO)
+			}
+			continue
+		}
+		break
+	}
+	c.Assert(errors.Is(err, ConnectTimeout), Equals, true)
+	c.Assert(clock.Now().Sub(start) >= RUDP_CONNECT_TIMEOUT, Equals, true)
+}
+
+// A socket whose next read fails as if an ICMP port unreachable had
+// arrived, once refuse is set.
+type refusingPacketConn struct {
+	net.PacketConn
+	refuse int32
+}
+
+func (r *refusingPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if atomic.CompareAndSwapInt32(&r.refuse, 1, 0) {
+		return 0, nil, &net.OpError{Op: "read", Net: "udp",
+			Err: syscall.ECONNREFUSED}
+	}
+	return r.PacketConn.ReadFrom(b)
+}
+
+// A stray port unreachable does not kill an established connection.
+func (s *XLSuite) TestRudpSoftRefusal(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_RUDP_SOFT_REFUSAL")
+	}
+	acc, err := NewRudpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	ctor, err := NewRudpConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	rpc := &refusingPacketConn{PacketConn: pc}
+	cnx, err := ctor.ConnectOn(rpc)
+	c.Assert(err, IsNil)
+	defer cnx.Close()
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	defer server.Close()
+
+	buf := make([]byte, 16)
+	atomic.StoreInt32(&rpc.refuse, 1)
+	for _, msg := range []string{"before", "after"} {
+		_, err = server.Write([]byte(msg))
+		c.Assert(err, IsNil)
+		count, err := io.ReadFull(cnx, buf[:len(msg)])
+		c.Assert(err, IsNil)
+		c.Assert(string(buf[:count]), Equals, msg)
+	}
+	c.Assert(atomic.LoadInt32(&rpc.refuse), Equals, int32(0))
+}
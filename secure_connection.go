@@ -0,0 +1,288 @@
+package transport
+
+// xlTransport_go/secure_connection.go
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A SecureConnection encrypts traffic over an underlying connection
+// using a secret shared by the two ends, typically the result of
+// Negotiate.  Traffic is carried in records, each a frame whose payload
+// is a record type byte followed by the record sealed with AES-256-GCM.
+//
+// Each direction has its own key.  When the sender's RekeyPolicy says
+// so it sends a REKEY record, sealed under the current key, and then
+// moves to the next key in the chain, the old key being zeroed.  The
+// receiver makes the same move on opening the REKEY record.  The REKEY
+// record is therefore the boundary between keys, and data in flight is
+// never lost.  Because the next key is derived from the current one,
+// rekeying requires no further exchange between the two ends.
+
+const (
+	SECURE_KEY_LEN     = 32
+	SECURE_NONCE_LEN   = 12
+	MAX_RECORD_PAYLOAD = 16 * 1024
+
+	RECORD_DATA  = 1
+	RECORD_REKEY = 2
+)
+
+var (
+	initiatorKeyLabel = []byte("xLattice initiator key")
+	responderKeyLabel = []byte("xLattice responder key")
+	rekeyLabel        = []byte("xLattice rekey")
+)
+
+// Determines when a SecureConnection switches to a new key.  A zero
+// field disables that trigger.
+type RekeyPolicy struct {
+	MaxBytes    uint64        // rekey after this many bytes sent
+	MaxInterval time.Duration // rekey after this much time under one key
+}
+
+// One direction of a SecureConnection.
+type secureStream struct {
+	key     []byte
+	aead    cipher.AEAD
+	seq     uint64 // records sealed or opened under the current key
+	bytes   uint64 // plaintext bytes carried under the current key
+	keyedAt time.Time
+	rekeys  int32 // accessed atomically
+	clock   ClockI
+}
+
+func newSecureStream(key []byte) (s *secureStream, err error) {
+	s = &secureStream{key: key, clock: RealClock{}}
+	err = s.setKey(key)
+	return
+}
+
+func (s *secureStream) setKey(key []byte) (err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err == nil {
+		s.aead, err = cipher.NewGCM(block)
+	}
+	if err == nil {
+		s.key = key
+		s.seq = 0
+		s.bytes = 0
+		s.keyedAt = s.clock.Now()
+	}
+	return
+}
+
+// Replace the current key with the next in the chain, zeroing it.
+func (s *secureStream) ratchet() (err error) {
+	old := s.key
+	next := deriveKey(old, rekeyLabel)
+	if err = s.setKey(next); err == nil {
+		atomic.AddInt32(&s.rekeys, 1)
+	}
+	zeroize(old)
+	return
+}
+
+func (s *secureStream) nonce() []byte {
+	n := make([]byte, SECURE_NONCE_LEN)
+	binary.BigEndian.PutUint64(n[SECURE_NONCE_LEN-8:], s.seq)
+	s.seq++
+	return n
+}
+
+type SecureConnection struct {
+	ConnectionI
+	policy RekeyPolicy
+
+	wMu  sync.Mutex
+	send *secureStream
+
+	rMu     sync.Mutex
+	recv    *secureStream
+	pending []byte // plaintext opened but not yet read
+	readErr error  // once reading fails it fails for good
+}
+
+// Wrap cnx so that all traffic over it is encrypted using keys derived
+// from secret.  Exactly one of the two ends must be the initiator.  If
+// policy is nil keys are never rotated.
+func NewSecureConnection(cnx ConnectionI, secret []byte, initiator bool,
+	policy *RekeyPolicy) (sc *SecureConnection, err error) {
+
+	if cnx == nil {
+		err = NilConnection
+	} else if len(secret) == 0 {
+		err = NilSecret
+	} else {
+		sendLabel, recvLabel := initiatorKeyLabel, responderKeyLabel
+		if !initiator {
+			sendLabel, recvLabel = recvLabel, sendLabel
+		}
+		sc = &SecureConnection{ConnectionI: cnx}
+		if policy != nil {
+			sc.policy = *policy
+		}
+		sc.send, err = newSecureStream(deriveKey(secret, sendLabel))
+		if err == nil {
+			sc.recv, err = newSecureStream(deriveKey(secret, recvLabel))
+		}
+		if err != nil {
+			sc = nil
+		}
+	}
+	return
+}
+
+func deriveKey(secret, label []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(label)
+	return mac.Sum(nil)[:SECURE_KEY_LEN]
+}
+
+func zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// Whether the current send key has been used as much as the policy
+// allows.
+func (sc *SecureConnection) rekeyDue() bool {
+	p, s := sc.policy, sc.send
+	return (p.MaxBytes > 0 && s.bytes >= p.MaxBytes) ||
+		(p.MaxInterval > 0 && s.clock.Now().Sub(s.keyedAt) >= p.MaxInterval)
+}
+
+func (sc *SecureConnection) writeRecord(recType byte, plain []byte) (err error) {
+	s := sc.send
+	nonce := s.nonce()
+	hdr := []byte{recType}
+	sealed := s.aead.Seal(hdr, nonce, plain, hdr)
+	if err = writeFrame(sc.ConnectionI, sealed); err == nil {
+		s.bytes += uint64(len(plain))
+	}
+	return
+}
+
+// Set the clock against which RekeyPolicy.MaxInterval is measured.
+// The current send key is treated as having been put into use now.
+// Only the sender's clock matters: the receiver follows REKEY records.
+func (sc *SecureConnection) SetClock(clock ClockI) {
+	sc.wMu.Lock()
+	sc.send.clock = clock
+	sc.send.keyedAt = clock.Now()
+	sc.wMu.Unlock()
+}
+
+// Encrypt b and write it to the underlying connection, rotating the
+// send key first if the policy requires it.
+func (sc *SecureConnection) Write(b []byte) (count int, err error) {
+	sc.wMu.Lock()
+	defer sc.wMu.Unlock()
+
+	for err == nil && count < len(b) {
+		if sc.rekeyDue() {
+			if err = sc.writeRecord(RECORD_REKEY, nil); err == nil {
+				err = sc.send.ratchet()
+			}
+			if err != nil {
+				break
+			}
+		}
+		chunk := b[count:]
+		if len(chunk) > MAX_RECORD_PAYLOAD {
+			chunk = chunk[:MAX_RECORD_PAYLOAD]
+		}
+		if err = sc.writeRecord(RECORD_DATA, chunk); err == nil {
+			count += len(chunk)
+		}
+	}
+	return
+}
+
+// Read and open the next record, handling any change of key.
+func (sc *SecureConnection) readRecord() (plain []byte, err error) {
+	var sealed []byte
+	s := sc.recv
+	for err == nil && plain == nil {
+		sealed, err = readFrame(sc.ConnectionI)
+		if err == nil && len(sealed) < 1 {
+			err = BadRecord
+		}
+		if err == nil {
+			hdr := sealed[:1]
+			plain, err = s.aead.Open(nil, s.nonce(), sealed[1:], hdr)
+			if err != nil {
+				err = BadRecord
+			} else if hdr[0] == RECORD_REKEY {
+				err = s.ratchet()
+				plain = nil
+			} else if hdr[0] != RECORD_DATA {
+				err = BadRecord
+			} else {
+				s.bytes += uint64(len(plain))
+				if plain == nil {
+					plain = []byte{}
+				}
+			}
+		}
+	}
+	return
+}
+
+// Read decrypted bytes from the connection.
+func (sc *SecureConnection) Read(b []byte) (count int, err error) {
+	sc.rMu.Lock()
+	defer sc.rMu.Unlock()
+
+	if sc.readErr != nil {
+		return 0, sc.readErr
+	}
+	for len(sc.pending) == 0 && err == nil {
+		sc.pending, err = sc.readRecord()
+	}
+	if err != nil {
+		sc.readErr = err
+		return
+	}
+	count = copy(b, sc.pending)
+	sc.pending = sc.pending[count:]
+	return
+}
+
+// Close the underlying connection and zero the keys in use.
+func (sc *SecureConnection) Close() (err error) {
+	err = sc.ConnectionI.Close()
+	sc.wMu.Lock()
+	zeroize(sc.send.key)
+	sc.wMu.Unlock()
+	// a blocked Read holds rMu; the close above will release it
+	sc.rMu.Lock()
+	zeroize(sc.recv.key)
+	sc.rMu.Unlock()
+	return
+}
+
+func (sc *SecureConnection) IsEncrypted() bool {
+	return true
+}
+
+// Return the number of times the send and receive keys have been
+// rotated.
+func (sc *SecureConnection) Rekeys() (sent, received int) {
+	sent = int(atomic.LoadInt32(&sc.send.rekeys))
+	received = int(atomic.LoadInt32(&sc.recv.rekeys))
+	return
+}
+
+func (sc *SecureConnection) String() string {
+	return fmt.Sprintf("Secure: %s", sc.ConnectionI.String())
+}
@@ -0,0 +1,141 @@
+package transport
+
+// xlTransport_go/secure_connection_test.go
+
+import (
+	"bytes"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"time"
+)
+
+func (s *XLSuite) makeSecurePair(c *C, rng *xr.SimpleRNG, policy *RekeyPolicy) (
+	client, server *SecureConnection) {
+
+	secret := make([]byte, 32)
+	rng.NextBytes(secret)
+	clientCnx, serverCnx := s.makeTcpPair(c)
+	client, err := NewSecureConnection(clientCnx, secret, true, policy)
+	c.Assert(err, IsNil)
+	server, err = NewSecureConnection(serverCnx, secret, false, policy)
+	c.Assert(err, IsNil)
+	c.Assert(client.IsEncrypted(), Equals, true)
+	return
+}
+
+// The server echoes whatever it reads until the connection is closed.
+func echo(cnx ConnectionI) {
+	buf := make([]byte, 4096)
+	for {
+		count, err := cnx.Read(buf)
+		if err != nil {
+			break
+		}
+		if _, err = cnx.Write(buf[:count]); err != nil {
+			break
+		}
+	}
+}
+
+// Send K messages of random length from client to server and verify
+// that each comes back unchanged.
+func (s *XLSuite) doSecureEcho(c *C, rng *xr.SimpleRNG,
+	client *SecureConnection, K int, pause time.Duration) {
+
+	for i := 0; i < K; i++ {
+		msg := make([]byte, 1+rng.Intn(3*MAX_RECORD_PAYLOAD))
+		rng.NextBytes(msg)
+		count, err := client.Write(msg)
+		c.Assert(err, IsNil)
+		c.Assert(count, Equals, len(msg))
+		reply := make([]byte, len(msg))
+		_, err = io.ReadFull(client, reply)
+		c.Assert(err, IsNil)
+		c.Assert(bytes.Equal(msg, reply), Equals, true)
+		if pause > 0 {
+			time.Sleep(pause)
+		}
+	}
+}
+
+func (s *XLSuite) TestSecureRekeyByBytes(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SECURE_REKEY_BY_BYTES")
+	}
+	rng := xr.MakeSimpleRNG()
+	client, server := s.makeSecurePair(c, rng,
+		&RekeyPolicy{MaxBytes: 8 * 1024})
+	defer client.Close()
+	go echo(server)
+
+	s.doSecureEcho(c, rng, client, 32, 0)
+
+	sent, received := client.Rekeys()
+	c.Assert(sent >= 4, Equals, true)
+	c.Assert(received >= 4, Equals, true)
+
+	// each end has moved to the same keys in each direction
+	sSent, sReceived := server.Rekeys()
+	c.Assert(sReceived, Equals, sent)
+	c.Assert(sSent, Equals, received)
+}
+
+func (s *XLSuite) TestSecureRekeyByTime(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SECURE_REKEY_BY_TIME")
+	}
+	rng := xr.MakeSimpleRNG()
+	client, server := s.makeSecurePair(c, rng,
+		&RekeyPolicy{MaxInterval: 5 * time.Millisecond})
+	defer client.Close()
+	go echo(server)
+
+	s.doSecureEcho(c, rng, client, 8, 10*time.Millisecond)
+
+	sent, received := client.Rekeys()
+	c.Assert(sent >= 4, Equals, true)
+	c.Assert(received >= 4, Equals, true)
+}
+
+func (s *XLSuite) TestSecureOldKeysZeroed(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SECURE_OLD_KEYS_ZEROED")
+	}
+	rng := xr.MakeSimpleRNG()
+	client, server := s.makeSecurePair(c, rng, &RekeyPolicy{MaxBytes: 1})
+	go echo(server)
+
+	oldSendKey, oldRecvKey := client.send.key, client.recv.key
+	s.doSecureEcho(c, rng, client, 2, 0)
+	zeroes := make([]byte, SECURE_KEY_LEN)
+	c.Assert(bytes.Equal(oldSendKey, zeroes), Equals, true)
+	c.Assert(bytes.Equal(oldRecvKey, zeroes), Equals, true)
+
+	curSendKey := client.send.key
+	c.Assert(bytes.Equal(curSendKey, zeroes), Equals, false)
+	client.Close()
+	c.Assert(bytes.Equal(curSendKey, zeroes), Equals, true)
+}
+
+func (s *XLSuite) TestSecureWrongSecret(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SECURE_WRONG_SECRET")
+	}
+	rng := xr.MakeSimpleRNG()
+	clientCnx, serverCnx := s.makeTcpPair(c)
+	defer clientCnx.Close()
+	defer serverCnx.Close()
+	client, err := NewSecureConnection(clientCnx, []byte("one"), true, nil)
+	c.Assert(err, IsNil)
+	server, err := NewSecureConnection(serverCnx, []byte("two"), false, nil)
+	c.Assert(err, IsNil)
+
+	msg := make([]byte, 64)
+	rng.NextBytes(msg)
+	_, err = client.Write(msg)
+	c.Assert(err, IsNil)
+	_, err = server.Read(msg)
+	c.Assert(err, Equals, BadRecord)
+}
@@ -0,0 +1,254 @@
+package transport
+
+// xlTransport_go/sockopt.go
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Options applied to the sockets underlying TCP acceptors and
+// connectors.  The zero value leaves everything as Go and the operating
+// system set it.  Options are given to NewTcpAcceptor and
+// NewTcpConnector, or appended to the address in query form:
+//
+//	127.0.0.1:80?nodelay=0&rcvbuf=65536&keepalive=30s&reuseport=1
+//
+// which is also how a TcpConnector serializes them.  The keys are
+// nodelay, linger, rcvbuf, sndbuf, keepalive, reuseaddr, reuseport, tos
+// and usertimeout.  linger=0 means ResetOnClose.
+//
+// A listener's options are applied to it before it binds and to every
+// connection it accepts; a connector's to the socket before it connects
+// and again once it has.  ReuseAddr, ReusePort, TOS and UserTimeout are
+// set directly on the socket, and where the platform does not support
+// them (see sockopt_linux.go) setting them is an error.
+type SocketOptions struct {
+	Nagle        bool          // clear TCP_NODELAY, which Go sets by default
+	Linger       int           // seconds for Close to wait for unsent data
+	ResetOnClose bool          // SO_LINGER of zero: Close resets the connection
+	RecvBuffer   int           // SO_RCVBUF, in bytes
+	SendBuffer   int           // SO_SNDBUF, in bytes
+	KeepAlive    time.Duration // keepalive period; negative turns it off
+	ReuseAddr    bool          // SO_REUSEADDR
+	ReusePort    bool          // SO_REUSEPORT
+	TOS          int           // IP_TOS, or IPV6_TCLASS over IPv6
+	UserTimeout  time.Duration // TCP_USER_TIMEOUT
+}
+
+// Parse options in query form, as in "nodelay=0&linger=5".
+func ParseSocketOptions(query string) (o *SocketOptions, err error) {
+	var values url.Values
+	if values, err = url.ParseQuery(query); err != nil {
+		return nil, fmt.Errorf("%w: %v", BadSocketOption, err)
+	}
+	o = &SocketOptions{}
+	for key, vals := range values {
+		val := vals[len(vals)-1]
+		var n int
+		var d time.Duration
+		switch key {
+		case "nodelay", "reuseaddr", "reuseport":
+			var b bool
+			if b, err = strconv.ParseBool(val); err == nil {
+				switch key {
+				case "nodelay":
+					o.Nagle = !b
+				case "reuseaddr":
+					o.ReuseAddr = b
+				default:
+					o.ReusePort = b
+				}
+			}
+		case "linger", "rcvbuf", "sndbuf", "tos":
+			if n, err = strconv.Atoi(val); err == nil && n < 0 {
+				err = BadSocketOption
+			}
+			switch key {
+			case "linger":
+				o.Linger, o.ResetOnClose = n, n == 0
+			case "rcvbuf":
+				o.RecvBuffer = n
+			case "sndbuf":
+				o.SendBuffer = n
+			default:
+				o.TOS = n
+			}
+		case "keepalive", "usertimeout":
+			if val == "off" {
+				d = -1
+			} else {
+				d, err = time.ParseDuration(val)
+			}
+			if key == "keepalive" {
+				o.KeepAlive = d
+			} else if d < 0 {
+				err = BadSocketOption
+			} else {
+				o.UserTimeout = d
+			}
+		default:
+			err = BadSocketOption
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s=%s", BadSocketOption, key, val)
+		}
+	}
+	return
+}
+
+// Split an address with options in query form into the two.
+func splitOptions(addr string) (string, *SocketOptions, error) {
+	if i := strings.IndexByte(addr, '?'); i >= 0 {
+		o, err := ParseSocketOptions(addr[i+1:])
+		return addr[:i], o, err
+	}
+	return addr, nil, nil
+}
+
+// Render the options in query form, keys in a fixed order; the empty
+// string if no option is set.
+func (o *SocketOptions) String() string {
+	if o == nil {
+		return ""
+	}
+	var parts []string
+	add := func(key, val string) {
+		parts = append(parts, key+"="+val)
+	}
+	if o.Nagle {
+		add("nodelay", "0")
+	}
+	if o.ResetOnClose {
+		add("linger", "0")
+	} else if o.Linger > 0 {
+		add("linger", strconv.Itoa(o.Linger))
+	}
+	if o.RecvBuffer > 0 {
+		add("rcvbuf", strconv.Itoa(o.RecvBuffer))
+	}
+	if o.SendBuffer > 0 {
+		add("sndbuf", strconv.Itoa(o.SendBuffer))
+	}
+	if o.KeepAlive < 0 {
+		add("keepalive", "off")
+	} else if o.KeepAlive > 0 {
+		add("keepalive", o.KeepAlive.String())
+	}
+	if o.ReuseAddr {
+		add("reuseaddr", "1")
+	}
+	if o.ReusePort {
+		add("reuseport", "1")
+	}
+	if o.TOS > 0 {
+		add("tos", strconv.Itoa(o.TOS))
+	}
+	if o.UserTimeout > 0 {
+		add("usertimeout", o.UserTimeout.String())
+	}
+	return strings.Join(parts, "&")
+}
+
+// Combine option sets, later non-zero fields overriding earlier ones.
+// Returns nil if there are none.
+func mergeOptions(opts ...*SocketOptions) (merged *SocketOptions) {
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &SocketOptions{}
+		}
+		merged.Nagle = merged.Nagle || o.Nagle
+		if o.ResetOnClose || o.Linger > 0 {
+			merged.Linger, merged.ResetOnClose = o.Linger, o.ResetOnClose
+		}
+		if o.RecvBuffer > 0 {
+			merged.RecvBuffer = o.RecvBuffer
+		}
+		if o.SendBuffer > 0 {
+			merged.SendBuffer = o.SendBuffer
+		}
+		if o.KeepAlive != 0 {
+			merged.KeepAlive = o.KeepAlive
+		}
+		merged.ReuseAddr = merged.ReuseAddr || o.ReuseAddr
+		merged.ReusePort = merged.ReusePort || o.ReusePort
+		if o.TOS > 0 {
+			merged.TOS = o.TOS
+		}
+		if o.UserTimeout > 0 {
+			merged.UserTimeout = o.UserTimeout
+		}
+	}
+	return
+}
+
+// Return a function suitable for net.Dialer.Control and
+// net.ListenConfig.Control which sets the options which must be set on
+// the raw socket, or nil if there are none.
+func (o *SocketOptions) control() func(network, address string, c syscall.RawConn) error {
+	if o == nil {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		cErr := c.Control(func(fd uintptr) {
+			err = setRawOptions(fd, o, strings.HasSuffix(network, "6"), true)
+		})
+		if cErr != nil {
+			return cErr
+		}
+		return err
+	}
+}
+
+// Apply the options to a connected socket.
+func (o *SocketOptions) applyConn(conn *net.TCPConn) (err error) {
+	if o == nil {
+		return
+	}
+	if o.Nagle {
+		err = conn.SetNoDelay(false)
+	}
+	if err == nil && o.ResetOnClose {
+		err = conn.SetLinger(0)
+	} else if err == nil && o.Linger > 0 {
+		err = conn.SetLinger(o.Linger)
+	}
+	if err == nil && o.RecvBuffer > 0 {
+		err = conn.SetReadBuffer(o.RecvBuffer)
+	}
+	if err == nil && o.SendBuffer > 0 {
+		err = conn.SetWriteBuffer(o.SendBuffer)
+	}
+	if err == nil && o.KeepAlive < 0 {
+		err = conn.SetKeepAlive(false)
+	} else if err == nil && o.KeepAlive > 0 {
+		if err = conn.SetKeepAlive(true); err == nil {
+			err = conn.SetKeepAlivePeriod(o.KeepAlive)
+		}
+	}
+	if err == nil {
+		var raw syscall.RawConn
+		if raw, err = conn.SyscallConn(); err == nil {
+			ipv6 := false
+			if a, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+				ipv6 = a.IP.To4() == nil
+			}
+			cErr := raw.Control(func(fd uintptr) {
+				err = setRawOptions(fd, o, ipv6, false)
+			})
+			if err == nil {
+				err = cErr
+			}
+		}
+	}
+	return
+}
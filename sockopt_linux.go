@@ -0,0 +1,57 @@
+//go:build linux
+
+package transport
+
+// xlTransport_go/sockopt_linux.go
+
+import (
+	"os"
+	"syscall"
+)
+
+// Not defined by the syscall package.  soReusePort, whose value varies
+// by architecture, is in sockopt_reuseport_*.go.
+const tcpUserTimeout = 0x12
+
+// Set the options which need the raw socket.  unbound is true before
+// the socket has been bound or connected, when SO_REUSEADDR and
+// SO_REUSEPORT take effect and buffer sizes are inherited by accepted
+// connections.
+func setRawOptions(fd uintptr, o *SocketOptions, ipv6, unbound bool) (err error) {
+	s := int(fd)
+	set := func(level, opt, val int) {
+		if err == nil {
+			err = os.NewSyscallError("setsockopt",
+				syscall.SetsockoptInt(s, level, opt, val))
+		}
+	}
+	if unbound {
+		if o.ReuseAddr {
+			set(syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		}
+		if o.ReusePort {
+			if soReusePort < 0 {
+				return UnsupportedSocketOption
+			}
+			set(syscall.SOL_SOCKET, soReusePort, 1)
+		}
+		if o.RecvBuffer > 0 {
+			set(syscall.SOL_SOCKET, syscall.SO_RCVBUF, o.RecvBuffer)
+		}
+		if o.SendBuffer > 0 {
+			set(syscall.SOL_SOCKET, syscall.SO_SNDBUF, o.SendBuffer)
+		}
+	}
+	if o.TOS > 0 {
+		if ipv6 {
+			set(syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, o.TOS)
+		} else {
+			set(syscall.IPPROTO_IP, syscall.IP_TOS, o.TOS)
+		}
+	}
+	if o.UserTimeout > 0 {
+		set(syscall.IPPROTO_TCP, tcpUserTimeout,
+			int(o.UserTimeout.Milliseconds()))
+	}
+	return
+}
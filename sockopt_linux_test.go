@@ -0,0 +1,64 @@
+//go:build linux
+
+package transport
+
+// xlTransport_go/sockopt_linux_test.go
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"syscall"
+	"time"
+)
+
+func getsockopt(c *C, cnx ConnectionI, level, opt int) (val int) {
+	raw, err := cnx.(*TcpConnection).conn.SyscallConn()
+	c.Assert(err, IsNil)
+	raw.Control(func(fd uintptr) {
+		val, err = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	c.Assert(err, IsNil)
+	return
+}
+
+func (s *XLSuite) TestSocketOptionsApplied(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SOCKET_OPTIONS_APPLIED")
+	}
+	opts := &SocketOptions{Nagle: true, RecvBuffer: 65536, TOS: 0x10,
+		UserTimeout: 5 * time.Second, ReusePort: true}
+	acc, err := NewTcpAcceptor("127.0.0.1:0", opts)
+	c.Assert(err, IsNil)
+	defer acc.Close()
+
+	// SO_REUSEPORT lets a second listener share the port
+	acc2, err := NewTcpAcceptor(acc.GetEndPoint().Address().String(), opts)
+	c.Assert(err, IsNil)
+	acc2.Close()
+
+	accepted := make(chan ConnectionI, 1)
+	go func() {
+		cnx, _ := acc.Accept()
+		accepted <- cnx
+	}()
+	ctor, err := NewTcpConnector(acc.GetEndPoint(), opts)
+	c.Assert(err, IsNil)
+	client, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	defer client.Close()
+	server := <-accepted
+	c.Assert(server, NotNil)
+	defer server.Close()
+
+	for _, cnx := range []ConnectionI{client, server} {
+		c.Assert(getsockopt(c, cnx, syscall.IPPROTO_TCP, syscall.TCP_NODELAY),
+			Equals, 0)
+		// the kernel doubles the size asked for
+		c.Assert(getsockopt(c, cnx, syscall.SOL_SOCKET, syscall.SO_RCVBUF) >=
+			65536, Equals, true)
+		c.Assert(getsockopt(c, cnx, syscall.IPPROTO_IP, syscall.IP_TOS),
+			Equals, 0x10)
+		c.Assert(getsockopt(c, cnx, syscall.IPPROTO_TCP, tcpUserTimeout),
+			Equals, 5000)
+	}
+}
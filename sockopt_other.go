@@ -0,0 +1,14 @@
+//go:build !linux
+
+package transport
+
+// xlTransport_go/sockopt_other.go
+
+// Options needing the raw socket are supported only on Linux; elsewhere
+// asking for them is an error.
+func setRawOptions(fd uintptr, o *SocketOptions, ipv6, unbound bool) error {
+	if o.ReuseAddr || o.ReusePort || o.TOS > 0 || o.UserTimeout > 0 {
+		return UnsupportedSocketOption
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build linux && (386 || amd64 || arm || arm64 || loong64 || ppc64 || ppc64le || riscv64 || s390x)
+
+package transport
+
+// xlTransport_go/sockopt_reuseport_linux.go
+
+// SO_REUSEPORT, which the syscall package does not define, has this
+// value on the architectures above.
+const soReusePort = 0xf
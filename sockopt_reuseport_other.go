@@ -0,0 +1,9 @@
+//go:build linux && !(386 || amd64 || arm || arm64 || loong64 || ppc64 || ppc64le || riscv64 || s390x)
+
+package transport
+
+// xlTransport_go/sockopt_reuseport_other.go
+
+// SO_REUSEPORT has other values on mips, parisc and sparc; rather than
+// guess, asking for it there is an error.
+const soReusePort = -1
@@ -0,0 +1,90 @@
+package transport
+
+// xlTransport_go/sockopt_test.go
+
+import (
+	"errors"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+func (s *XLSuite) TestSocketOptionsQueryForm(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SOCKET_OPTIONS_QUERY_FORM")
+	}
+	o, err := ParseSocketOptions(
+		"tos=16&keepalive=30s&nodelay=0&rcvbuf=65536&linger=0&reuseport=true")
+	c.Assert(err, IsNil)
+	c.Assert(*o, Equals, SocketOptions{Nagle: true, ResetOnClose: true,
+		RecvBuffer: 65536, KeepAlive: 30 * time.Second, ReusePort: true,
+		TOS: 16})
+	c.Assert(o.String(), Equals,
+		"nodelay=0&linger=0&rcvbuf=65536&keepalive=30s&reuseport=1&tos=16")
+
+	o, err = ParseSocketOptions(o.String())
+	c.Assert(err, IsNil)
+	c.Assert(o.ResetOnClose, Equals, true)
+	o, err = ParseSocketOptions("keepalive=off&linger=5")
+	c.Assert(err, IsNil)
+	c.Assert(o.KeepAlive < 0, Equals, true)
+	c.Assert(o.Linger, Equals, 5)
+	c.Assert(o.String(), Equals, "linger=5&keepalive=off")
+	c.Assert((&SocketOptions{}).String(), Equals, "")
+
+	for _, bad := range []string{"colour=red", "rcvbuf=-1", "nodelay=maybe",
+		"usertimeout=off", "keepalive=soon"} {
+		_, err = ParseSocketOptions(bad)
+		c.Assert(errors.Is(err, BadSocketOption), Equals, true, Commentf(bad))
+	}
+
+	// later options override earlier ones
+	merged := mergeOptions(&SocketOptions{RecvBuffer: 1024, Linger: 5},
+		nil, &SocketOptions{RecvBuffer: 2048, ResetOnClose: true})
+	c.Assert(*merged, Equals, SocketOptions{RecvBuffer: 2048, ResetOnClose: true})
+	c.Assert(mergeOptions(), IsNil)
+}
+
+func (s *XLSuite) TestTcpWithSocketOptions(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_TCP_WITH_SOCKET_OPTIONS")
+	}
+	acc, err := NewTcpAcceptor("127.0.0.1:0?nodelay=0&keepalive=1m",
+		&SocketOptions{SendBuffer: 32768})
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	c.Assert(*acc.GetOptions(), Equals, SocketOptions{Nagle: true,
+		KeepAlive: time.Minute, SendBuffer: 32768})
+	_, err = NewTcpAcceptor("127.0.0.1:0?bogus=1")
+	c.Assert(errors.Is(err, BadSocketOption), Equals, true)
+
+	// a connector's options survive serialization
+	ctor, err := NewTcpConnector(acc.GetEndPoint(),
+		&SocketOptions{Nagle: true, Linger: 3})
+	c.Assert(err, IsNil)
+	str := ctor.String()
+	c.Assert(str, Equals, "TcpConnector: "+
+		acc.GetEndPoint().Address().String()+"?nodelay=0&linger=3")
+	parsed, err := ParseConnector(str)
+	c.Assert(err, IsNil)
+	c.Assert(parsed.String(), Equals, str)
+
+	accepted := make(chan ConnectionI, 1)
+	go func() {
+		cnx, _ := acc.Accept()
+		accepted <- cnx
+	}()
+	client, err := parsed.Connect(nil)
+	c.Assert(err, IsNil)
+	server := <-accepted
+	c.Assert(server, NotNil)
+	go echo(server)
+	_, err = client.Write([]byte("options"))
+	c.Assert(err, IsNil)
+	buf := make([]byte, 7)
+	_, err = client.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "options")
+	client.Close()
+	server.Close()
+}
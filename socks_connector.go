@@ -0,0 +1,265 @@
+package transport
+
+// xlTransport_go/socks_connector.go
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// A SocksConnector reaches its far end through a SOCKS5 proxy (RFC
+// 1928), authenticating with a username and password (RFC 1929) if
+// given them.  The far end may be a TcpEndPoint or a HostEndPoint; the
+// name in a HostEndPoint is resolved by the proxy, not here.
+//
+// The connections it makes are ordinary TcpConnections, to the proxy,
+// but report the far end they were asked for as their far end.
+
+const (
+	SOCKS_VERSION = 5
+
+	// The most time the proxy may take to connect to the far end.
+	SOCKS_TIMEOUT = 30 * time.Second
+
+	SOCKS_AUTH_NONE     = 0x00
+	SOCKS_AUTH_PASSWORD = 0x02
+	SOCKS_AUTH_REJECTED = 0xff
+
+	SOCKS_CMD_CONNECT = 0x01
+
+	SOCKS_ATYP_IPV4   = 0x01
+	SOCKS_ATYP_DOMAIN = 0x03
+	SOCKS_ATYP_IPV6   = 0x04
+)
+
+type SocksAuth struct {
+	Username string
+	Password string
+}
+
+// A failure reported by the proxy in reply to a CONNECT.  Refusal and
+// expiry unwrap to ConnectionRefused and ConnectTimeout, so that
+// IsRefused and IsTimeout recognize them.
+type SocksError struct {
+	Reply byte
+}
+
+var socksReplies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+func (e *SocksError) Error() string {
+	if int(e.Reply) < len(socksReplies) {
+		return "socks5: " + socksReplies[e.Reply]
+	}
+	return "socks5: unknown reply"
+}
+
+func (e *SocksError) Unwrap() error {
+	switch e.Reply {
+	case 0x05:
+		return ConnectionRefused
+	case 0x06:
+		return ConnectTimeout
+	}
+	return nil
+}
+
+type SocksConnector struct {
+	proxy  *TcpConnector
+	farEnd EndPointI // a *TcpEndPoint or *HostEndPoint
+	auth   *SocksAuth
+}
+
+// Connect to farEnd through the proxy at proxy.  auth may be nil, in
+// which case the proxy must not require authentication.  Socket
+// options are applied to the connection to the proxy.
+func NewSocksConnector(proxy, farEnd EndPointI, auth *SocksAuth,
+	opts ...*SocketOptions) (*SocksConnector, error) {
+
+	proxyCtor, err := NewTcpConnector(proxy, opts...)
+	if err != nil {
+		return nil, err
+	}
+	switch farEnd.(type) {
+	case *TcpEndPoint, *HostEndPoint:
+	default:
+		return nil, NotTcpEndPoint
+	}
+	ep2, _ := farEnd.Clone()
+	if auth != nil {
+		if len(auth.Username) == 0 || len(auth.Username) > 255 ||
+			len(auth.Password) > 255 {
+			return nil, SocksAuthRejected
+		}
+		auth = &SocksAuth{auth.Username, auth.Password}
+	}
+	return &SocksConnector{proxy: proxyCtor, farEnd: ep2, auth: auth}, nil
+}
+
+// Connect to the proxy, binding to nearEnd if it is not nil, and ask
+// it to connect to the far end.  The dial is counted and observed as
+// succeeding only once the proxy has done so.
+func (c *SocksConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
+	if _, ok := nearEnd.(*TcpEndPoint); nearEnd != nil && !ok {
+		return nil, NotTcpEndPoint
+	}
+	finish := observeDial(nearEnd, c.farEnd)
+	start := time.Now()
+	conn, err := c.proxy.dial(nearEnd)
+	if err == nil {
+		conn.SetDeadline(time.Now().Add(SOCKS_TIMEOUT))
+		err = c.handshake(conn)
+		if dErr := conn.SetDeadline(time.Time{}); err == nil {
+			err = dErr
+		}
+		if err != nil {
+			nearEnd, _ = NewTcpEndPoint(conn.LocalAddr().String())
+			conn.Close()
+		}
+	}
+	DefaultMetrics.transport("socks5").countDial(err)
+	if err != nil {
+		err = wrapError("dial", "socks5", nearEnd, c.farEnd, err)
+		finish(nil, err)
+		return nil, err
+	}
+	tcpCnx, _ := NewTcpConnection(conn)
+	tcpCnx.farEnd = c.farEnd
+	tcpCnx.stats.setConnectLatency(time.Since(start))
+	finish(tcpCnx, nil)
+	return tcpCnx, nil
+}
+
+func (c *SocksConnector) handshake(rw io.ReadWriter) (err error) {
+	// offer our methods, and see which the proxy chooses
+	methods := []byte{SOCKS_VERSION, 1, SOCKS_AUTH_NONE}
+	if c.auth != nil {
+		methods = []byte{SOCKS_VERSION, 2, SOCKS_AUTH_NONE, SOCKS_AUTH_PASSWORD}
+	}
+	if _, err = rw.Write(methods); err != nil {
+		return
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(rw, reply); err != nil {
+		return
+	}
+	if reply[0] != SOCKS_VERSION {
+		return BadRecord
+	}
+	switch reply[1] {
+	case SOCKS_AUTH_NONE:
+	case SOCKS_AUTH_PASSWORD:
+		if c.auth == nil {
+			return BadRecord
+		}
+		if err = c.authenticate(rw); err != nil {
+			return
+		}
+	case SOCKS_AUTH_REJECTED:
+		return SocksAuthRejected
+	default:
+		return BadRecord
+	}
+
+	// ask for the far end
+	req := []byte{SOCKS_VERSION, SOCKS_CMD_CONNECT, 0}
+	var port int
+	switch ep := c.farEnd.(type) {
+	case *TcpEndPoint:
+		addr := ep.GetTcpAddr()
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			req = append(append(req, SOCKS_ATYP_IPV4), ip4...)
+		} else {
+			req = append(append(req, SOCKS_ATYP_IPV6), addr.IP.To16()...)
+		}
+		port = addr.Port
+	case *HostEndPoint:
+		if len(ep.host) > 255 {
+			return NotAnEndPoint
+		}
+		req = append(req, SOCKS_ATYP_DOMAIN, byte(len(ep.host)))
+		req = append(req, ep.host...)
+		port = ep.port
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err = rw.Write(req); err != nil {
+		return
+	}
+
+	// the reply ends with the proxy's own address, which is discarded
+	hdr := make([]byte, 4)
+	if _, err = io.ReadFull(rw, hdr); err != nil {
+		return
+	}
+	if hdr[0] != SOCKS_VERSION {
+		return BadRecord
+	}
+	if hdr[1] != 0 {
+		return &SocksError{hdr[1]}
+	}
+	var addrLen int
+	switch hdr[3] {
+	case SOCKS_ATYP_IPV4:
+		addrLen = net.IPv4len
+	case SOCKS_ATYP_IPV6:
+		addrLen = net.IPv6len
+	case SOCKS_ATYP_DOMAIN:
+		var n [1]byte
+		if _, err = io.ReadFull(rw, n[:]); err != nil {
+			return
+		}
+		addrLen = int(n[0])
+	default:
+		return BadRecord
+	}
+	_, err = io.ReadFull(rw, make([]byte, addrLen+2))
+	return
+}
+
+// Authenticate with username and password, RFC 1929.
+func (c *SocksConnector) authenticate(rw io.ReadWriter) (err error) {
+	req := []byte{1, byte(len(c.auth.Username))}
+	req = append(req, c.auth.Username...)
+	req = append(req, byte(len(c.auth.Password)))
+	req = append(req, c.auth.Password...)
+	if _, err = rw.Write(req); err != nil {
+		return
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(rw, reply); err != nil {
+		return
+	}
+	if reply[0] != 1 {
+		return BadRecord
+	}
+	if reply[1] != 0 {
+		return SocksAuthRejected
+	}
+	return
+}
+
+// Return the end point the proxy is asked to connect to.
+func (c *SocksConnector) GetFarEnd() EndPointI {
+	return c.farEnd
+}
+
+// Return the proxy's end point.
+func (c *SocksConnector) GetProxy() EndPointI {
+	return c.proxy.GetFarEnd()
+}
+
+func (c *SocksConnector) String() string {
+	return "SocksConnector: " + hostPort(c.farEnd) + " via " +
+		hostPort(c.proxy.GetFarEnd())
+}
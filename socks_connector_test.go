@@ -0,0 +1,255 @@
+package transport
+
+// xlTransport_go/socks_connector_test.go
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+// Return the dials counted for transport by DefaultMetrics.
+func dialCounts(transport string) (ok, failed uint64) {
+	tm := DefaultMetrics.transport(transport)
+	return atomic.LoadUint64(&tm.dialSuccesses),
+		atomic.LoadUint64(&tm.dialFailures)
+}
+
+// Return, for each dial log saw finish, whether it failed.
+func dialFinishErrs(log *eventLog) (failed []bool) {
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	for _, ev := range log.events {
+		if ev.Kind == EV_DIAL_FINISH {
+			failed = append(failed, ev.Err != nil)
+		}
+	}
+	return
+}
+
+// A minimal SOCKS5 server standing in for the proxy.  It resolves
+// host names from hosts alone, and requires a password if auth is set.
+type socksServer struct {
+	listener net.Listener
+	auth     *SocksAuth
+	hosts    map[string]string
+}
+
+func newSocksServer(auth *SocksAuth, hosts map[string]string) (*socksServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &socksServer{l, auth, hosts}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+func (s *socksServer) endPoint() *TcpEndPoint {
+	ep, _ := NewTcpEndPoint(s.listener.Addr().String())
+	return ep
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	methods := buf[2 : 2+buf[1]]
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	want := byte(SOCKS_AUTH_NONE)
+	if s.auth != nil {
+		want = SOCKS_AUTH_PASSWORD
+	}
+	chosen := byte(SOCKS_AUTH_REJECTED)
+	for _, m := range methods {
+		if m == want {
+			chosen = want
+		}
+	}
+	conn.Write([]byte{5, chosen})
+	if chosen == SOCKS_AUTH_REJECTED {
+		return
+	}
+	if chosen == SOCKS_AUTH_PASSWORD {
+		readField := func() string {
+			var n [1]byte
+			io.ReadFull(conn, n[:])
+			field := make([]byte, n[0])
+			io.ReadFull(conn, field)
+			return string(field)
+		}
+		var ver [1]byte
+		io.ReadFull(conn, ver[:])
+		user, pass := readField(), readField()
+		if user != s.auth.Username || pass != s.auth.Password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case SOCKS_ATYP_IPV4:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case SOCKS_ATYP_IPV6:
+		io.ReadFull(conn, buf[:16])
+		host = net.IP(buf[:16]).String()
+	case SOCKS_ATYP_DOMAIN:
+		io.ReadFull(conn, buf[:1])
+		n := buf[0]
+		io.ReadFull(conn, buf[:n])
+		var ok bool
+		if host, ok = s.hosts[string(buf[:n])]; !ok {
+			conn.Write([]byte{5, 4, 0, SOCKS_ATYP_IPV4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+	}
+	io.ReadFull(conn, buf[:2])
+	port := binary.BigEndian.Uint16(buf[:2])
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, SOCKS_ATYP_IPV4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	bound := target.LocalAddr().(*net.TCPAddr)
+	reply := append([]byte{5, 0, 0, SOCKS_ATYP_IPV4}, bound.IP.To4()...)
+	conn.Write(binary.BigEndian.AppendUint16(reply, uint16(bound.Port)))
+	go func() {
+		io.Copy(target, conn)
+		target.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(conn, target)
+}
+
+func (s *XLSuite) TestSocksConnector(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_SOCKS_CONNECTOR")
+	}
+	// the real far end
+	acc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	go func() {
+		for {
+			cnx, err := acc.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				echo(cnx)
+				cnx.Close()
+			}()
+		}
+	}()
+	target := acc.GetEndPoint()
+	port := strconv.Itoa(target.(*TcpEndPoint).GetTcpAddr().Port)
+	roundTrip := func(cnx ConnectionI) {
+		msg := "through the proxy"
+		_, err := cnx.Write([]byte(msg))
+		c.Assert(err, IsNil)
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(cnx, buf)
+		c.Assert(err, IsNil)
+		c.Assert(string(buf), Equals, msg)
+	}
+
+	// without authentication, to an IP address
+	open, err := newSocksServer(nil, nil)
+	c.Assert(err, IsNil)
+	defer open.listener.Close()
+	ctor, err := NewSocksConnector(open.endPoint(), target, nil)
+	c.Assert(err, IsNil)
+	c.Assert(ctor.String(), Equals, "SocksConnector: "+
+		target.Address().String()+" via "+open.endPoint().Address().String())
+	cnx, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	c.Assert(cnx.GetFarEnd().Equal(target), Equals, true)
+	roundTrip(cnx)
+	cnx.Close()
+
+	// with a password, to a host name only the proxy can resolve
+	auth := &SocksAuth{"node", "secret"}
+	guarded, err := newSocksServer(auth,
+		map[string]string{"echo.internal": "127.0.0.1"})
+	c.Assert(err, IsNil)
+	defer guarded.listener.Close()
+	named, err := NewHostEndPoint("echo.internal:" + port)
+	c.Assert(err, IsNil)
+	ep, err := ParseEndPoint(named.String())
+	c.Assert(err, IsNil)
+	c.Assert(ep.Equal(named), Equals, true)
+	ctor, err = NewSocksConnector(guarded.endPoint(), named, auth)
+	c.Assert(err, IsNil)
+	cnx, err = ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	c.Assert(cnx.GetFarEnd().Equal(named), Equals, true)
+	roundTrip(cnx)
+	cnx.Close()
+
+	// authentication failures, each counted and observed as a failed
+	// dial and nothing else
+	log := &eventLog{}
+	prev := SetObserver(log)
+	tcpOK, _ := dialCounts("tcp")
+	socksOK, socksFailed := dialCounts("socks5")
+	for _, a := range []*SocksAuth{nil, {"node", "wrong"}} {
+		ctor, err = NewSocksConnector(guarded.endPoint(), named, a)
+		c.Assert(err, IsNil)
+		_, err = ctor.Connect(nil)
+		c.Assert(errors.Is(err, SocksAuthRejected), Equals, true)
+		var te *TransportError
+		c.Assert(errors.As(err, &te), Equals, true)
+		c.Assert(te.Transport, Equals, "socks5")
+	}
+	SetObserver(prev)
+	c.Assert(dialFinishErrs(log), DeepEquals, []bool{true, true})
+	ok, _ := dialCounts("tcp")
+	c.Assert(ok, Equals, tcpOK)
+	ok, failed := dialCounts("socks5")
+	c.Assert(ok, Equals, socksOK)
+	c.Assert(failed, Equals, socksFailed+2)
+
+	// the proxy's failures are reported
+	unknown, _ := NewHostEndPoint("nowhere.internal:" + port)
+	ctor, err = NewSocksConnector(guarded.endPoint(), unknown, auth)
+	c.Assert(err, IsNil)
+	_, err = ctor.Connect(nil)
+	var se *SocksError
+	c.Assert(errors.As(err, &se), Equals, true)
+	c.Assert(se.Reply, Equals, byte(4))
+	c.Assert(IsRefused(err), Equals, false)
+
+	closed, _ := NewTcpEndPoint(acc.GetEndPoint().Address().String())
+	acc.Close()
+	ctor, err = NewSocksConnector(open.endPoint(), closed, nil)
+	c.Assert(err, IsNil)
+	_, err = ctor.Connect(nil)
+	c.Assert(IsRefused(err), Equals, true)
+
+	unixEp, _ := NewUnixEndPoint("/run/node.sock")
+	_, err = NewSocksConnector(open.endPoint(), unixEp, nil)
+	c.Assert(err, Equals, NotTcpEndPoint)
+}
@@ -0,0 +1,177 @@
+package transport
+
+// xlTransport_go/stats.go
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Traffic statistics for a connection, as returned by Stats().  Counts
+// are of bytes and calls at this connection's level: for a wrapper
+// such as a SecureConnection they are those of the connection wrapped.
+type ConnStats struct {
+	BytesRead    uint64
+	BytesWritten uint64
+	Reads        uint64 // calls to Read returning data or an error
+	Writes       uint64
+	ReadErrors   uint64 // excluding io.EOF
+	WriteErrors  uint64
+
+	ConnectLatency time.Duration // time taken to connect, if known
+	ConnectedAt    time.Time
+	ClosedAt       time.Time     // zero if still open
+	TimeConnected  time.Duration // until ClosedAt or now
+}
+
+// Statistics for an acceptor, as returned by Stats().
+type AcceptorStats struct {
+	Accepted uint64 // connections returned by Accept
+	Rejected uint64 // connections turned away or dropped before Accept
+	Active   int64  // accepted connections not yet closed
+}
+
+// Maintains the counts behind ConnStats.  The counters are updated
+// atomically; the times are guarded by mu.
+type connCounters struct {
+	bytesRead, bytesWritten uint64
+	reads, writes           uint64
+	readErrors, writeErrors uint64
+
+	mu             sync.Mutex
+	clock          ClockI
+	connectLatency time.Duration
+	connectedAt    time.Time
+	closedAt       time.Time
+	onClose        func() // called once, on the first close
+	tm             *transportMetrics
+	owner          ConnectionI // reported to the observer
+}
+
+// Start counting for owner, a connection over the named transport,
+// reporting to DefaultMetrics and the observer as well.
+func (k *connCounters) init(owner ConnectionI, transport string, clock ClockI) {
+	k.owner = owner
+	k.clock = clock
+	k.connectedAt = clock.Now()
+	k.tm = DefaultMetrics.transport(transport)
+	atomic.AddInt64(&k.tm.active, 1)
+}
+
+func (k *connCounters) setConnectLatency(d time.Duration) {
+	k.mu.Lock()
+	k.connectLatency = d
+	k.mu.Unlock()
+}
+
+func (k *connCounters) countRead(n int, err error) {
+	atomic.AddUint64(&k.reads, 1)
+	atomic.AddUint64(&k.bytesRead, uint64(n))
+	if k.tm != nil {
+		atomic.AddUint64(&k.tm.bytesRead, uint64(n))
+	}
+	if err != nil && err != io.EOF {
+		atomic.AddUint64(&k.readErrors, 1)
+		observeError(k.owner, "read", err)
+	}
+}
+
+func (k *connCounters) countWrite(n int, err error) {
+	atomic.AddUint64(&k.writes, 1)
+	atomic.AddUint64(&k.bytesWritten, uint64(n))
+	if k.tm != nil {
+		atomic.AddUint64(&k.tm.bytesWritten, uint64(n))
+	}
+	if err != nil {
+		atomic.AddUint64(&k.writeErrors, 1)
+		observeError(k.owner, "write", err)
+	}
+}
+
+// Record that the connection has closed.  Only the first call has any
+// effect.
+func (k *connCounters) countClose() {
+	k.mu.Lock()
+	var onClose func()
+	first := k.closedAt.IsZero()
+	if first {
+		if k.clock != nil {
+			k.closedAt = k.clock.Now()
+		} else {
+			k.closedAt = time.Now()
+		}
+		onClose, k.onClose = k.onClose, nil
+		if k.tm != nil {
+			atomic.AddInt64(&k.tm.active, -1)
+		}
+	}
+	connected := k.closedAt.Sub(k.connectedAt)
+	k.mu.Unlock()
+	if onClose != nil {
+		onClose()
+	}
+	if first && k.owner != nil && getObserver() != nil {
+		observe(&Event{Kind: EV_CLOSE, Near: k.owner.GetNearEnd(),
+			Far: k.owner.GetFarEnd(), Duration: connected})
+	}
+}
+
+func (k *connCounters) snapshot() (s ConnStats) {
+	s.BytesRead = atomic.LoadUint64(&k.bytesRead)
+	s.BytesWritten = atomic.LoadUint64(&k.bytesWritten)
+	s.Reads = atomic.LoadUint64(&k.reads)
+	s.Writes = atomic.LoadUint64(&k.writes)
+	s.ReadErrors = atomic.LoadUint64(&k.readErrors)
+	s.WriteErrors = atomic.LoadUint64(&k.writeErrors)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	s.ConnectLatency = k.connectLatency
+	s.ConnectedAt = k.connectedAt
+	s.ClosedAt = k.closedAt
+	if !s.ConnectedAt.IsZero() {
+		end := s.ClosedAt
+		if end.IsZero() && k.clock != nil {
+			end = k.clock.Now()
+		}
+		if !end.IsZero() {
+			s.TimeConnected = end.Sub(s.ConnectedAt)
+		}
+	}
+	return
+}
+
+// Maintains the counts behind AcceptorStats.
+type acceptorCounters struct {
+	accepted, rejected uint64
+	active             int64
+}
+
+func (k *acceptorCounters) countReject() {
+	atomic.AddUint64(&k.rejected, 1)
+}
+
+// Count a connection as accepted and active until it closes.  One
+// which closed while waiting to be accepted is not active.
+func (k *acceptorCounters) countAccept(cnx *connCounters) {
+	atomic.AddUint64(&k.accepted, 1)
+	if cnx.tm != nil {
+		atomic.AddUint64(&cnx.tm.accepts, 1)
+	}
+	cnx.mu.Lock()
+	if cnx.closedAt.IsZero() {
+		atomic.AddInt64(&k.active, 1)
+		cnx.onClose = func() { atomic.AddInt64(&k.active, -1) }
+	}
+	cnx.mu.Unlock()
+}
+
+func (k *acceptorCounters) snapshot() AcceptorStats {
+	return AcceptorStats{
+		Accepted: atomic.LoadUint64(&k.accepted),
+		Rejected: atomic.LoadUint64(&k.rejected),
+		Active:   atomic.LoadInt64(&k.active),
+	}
+}
@@ -0,0 +1,124 @@
+package transport
+
+// xlTransport_go/stats_test.go
+
+import (
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"time"
+)
+
+func (s *XLSuite) TestTcpStats(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_TCP_STATS")
+	}
+	client, server := s.makeTcpPair(c)
+	defer server.Close()
+	c.Assert(client.Stats().ConnectLatency > 0, Equals, true)
+
+	go echo(server)
+	msg := []byte("counted twice")
+	for i := 0; i < 2; i++ {
+		_, err := client.Write(msg)
+		c.Assert(err, IsNil)
+		_, err = io.ReadFull(client, make([]byte, len(msg)))
+		c.Assert(err, IsNil)
+	}
+	cs := client.Stats()
+	c.Assert(cs.BytesWritten, Equals, uint64(2*len(msg)))
+	c.Assert(cs.BytesRead, Equals, uint64(2*len(msg)))
+	c.Assert(cs.Writes, Equals, uint64(2))
+	c.Assert(cs.ReadErrors+cs.WriteErrors, Equals, uint64(0))
+	c.Assert(cs.ClosedAt.IsZero(), Equals, true)
+
+	// wrappers report the connection they wrap
+	sc := &PreambleConnection{ConnectionI: client}
+	c.Assert(sc.Stats().BytesWritten, Equals, cs.BytesWritten)
+
+	c.Assert(client.Close(), IsNil)
+	cs = client.Stats()
+	c.Assert(cs.ClosedAt.IsZero(), Equals, false)
+	c.Assert(cs.TimeConnected, Equals, cs.ClosedAt.Sub(cs.ConnectedAt))
+}
+
+func (s *XLSuite) TestMockStats(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_MOCK_STATS")
+	}
+	clock := NewVirtualClock(epoch)
+	net := NewMockNetwork()
+	net.SetClock(clock)
+	net.SetLatency(50 * time.Millisecond)
+	acc, err := net.NewMockAcceptor("T", "server")
+	c.Assert(err, IsNil)
+	ctor, err := net.NewMockConnector(acc.GetEndPoint())
+	c.Assert(err, IsNil)
+
+	client, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	c.Assert(client.Stats().ConnectLatency, Equals, 100*time.Millisecond)
+
+	_, err = client.Write([]byte("hello"))
+	c.Assert(err, IsNil)
+	clock.Advance(time.Minute)
+	_, err = server.Read(make([]byte, 5))
+	c.Assert(err, IsNil)
+	server.(*MockConnection).SetReadTimeout(time.Second)
+	readErr := make(chan error)
+	go func() {
+		_, err := server.Read(make([]byte, 5))
+		readErr <- err
+	}()
+	clock.WaitForTimers(1)
+	clock.Advance(time.Second)
+	c.Assert(<-readErr, Equals, ReadTimeout)
+
+	ss := server.Stats()
+	c.Assert(ss.BytesRead, Equals, uint64(5))
+	c.Assert(ss.Reads, Equals, uint64(2))
+	c.Assert(ss.ReadErrors, Equals, uint64(1))
+	c.Assert(ss.TimeConnected >= time.Minute, Equals, true)
+	c.Assert(client.Stats().BytesWritten, Equals, uint64(5))
+
+	// connections beyond the backlog are rejected, as are those left
+	// in the backlog when the acceptor closes
+	for i := 0; i < MOCK_BACKLOG; i++ {
+		_, err = ctor.Connect(nil)
+		c.Assert(err, IsNil)
+	}
+	_, err = ctor.Connect(nil)
+	c.Assert(err, Equals, ConnectionRefused)
+	as := acc.Stats()
+	c.Assert(as.Accepted, Equals, uint64(1))
+	c.Assert(as.Rejected, Equals, uint64(1))
+	c.Assert(as.Active, Equals, int64(1))
+
+	c.Assert(server.Close(), IsNil)
+	c.Assert(server.Close(), IsNil)
+	c.Assert(acc.Close(), IsNil)
+	as = acc.Stats()
+	c.Assert(as.Rejected, Equals, uint64(1+MOCK_BACKLOG))
+	c.Assert(as.Active, Equals, int64(0))
+}
+
+// A connection which closes before it is accepted is never active.
+func (s *XLSuite) TestAcceptorStatsClosedInBacklog(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ACCEPTOR_STATS_CLOSED_IN_BACKLOG")
+	}
+	var acc acceptorCounters
+	var early, late connCounters
+	early.init(nil, "mock", RealClock{})
+	late.init(nil, "mock", RealClock{})
+
+	early.countClose()
+	acc.countAccept(&early)
+	c.Assert(acc.snapshot(), Equals, AcceptorStats{Accepted: 1})
+	acc.countAccept(&late)
+	c.Assert(acc.snapshot(), Equals, AcceptorStats{Accepted: 2, Active: 1})
+	late.countClose()
+	c.Assert(acc.snapshot(), Equals, AcceptorStats{Accepted: 2})
+}
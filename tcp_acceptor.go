@@ -22,53 +22,234 @@ package transport
  */
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 )
 
 var _ = fmt.Printf
 
 type TcpAcceptor struct {
-	closed   bool
+	closed   int32
 	endPoint *TcpEndPoint
 	listener *net.TCPListener
+	opts     *SocketOptions // nil if none
+	proxy    *ProxyConfig   // nil if PROXY headers are not expected
+	stats    acceptorCounters
+
+	// used only when PROXY headers are expected
+	startOnce sync.Once
+	ready     chan tcpAccepted
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	reading   map[*net.TCPConn]bool // connections whose headers are awaited
+}
+
+// A connection whose PROXY header has been read, or the listener's
+// failure to accept one.
+type tcpAccepted struct {
+	conn *net.TCPConn
+	src  *net.TCPAddr
+	err  error
 }
 
-func NewTcpAcceptor(strAddr string) (*TcpAcceptor, error) {
+// Listen at strAddr, which may carry socket options in query form.
+// Options given as arguments override those in strAddr.
+func NewTcpAcceptor(strAddr string, opts ...*SocketOptions) (*TcpAcceptor, error) {
 	var err error
 	var listener *net.TCPListener
 	var tcpAddr *net.TCPAddr
+	var o *SocketOptions
+	if strAddr, o, err = splitOptions(strAddr); err != nil {
+		return nil, err
+	}
+	o = mergeOptions(append([]*SocketOptions{o}, opts...)...)
 	if tcpAddr, err = net.ResolveTCPAddr("tcp", strAddr); err == nil {
-		listener, err = net.ListenTCP("tcp", tcpAddr)
+		lc := net.ListenConfig{Control: o.control()}
+		var l net.Listener
+		if l, err = lc.Listen(context.Background(), "tcp", tcpAddr.String()); err == nil {
+			listener = l.(*net.TCPListener)
+		}
 	}
 	if err == nil {
-		a := TcpAcceptor{}
-		a.listener = listener
-		addr := listener.Addr().String()
-		a.endPoint, _ = NewTcpEndPoint(addr)
-		return &a, nil
+		return newTcpAcceptor(listener, o), nil
 	} else {
-		return nil, err
+		return nil, wrapError("listen", "tcp", nil, nil, err)
 	}
 }
+
+func newTcpAcceptor(listener *net.TCPListener, o *SocketOptions) *TcpAcceptor {
+	a := TcpAcceptor{opts: o}
+	a.listener = listener
+	addr := listener.Addr().String()
+	a.endPoint, _ = NewTcpEndPoint(addr)
+	a.ready = make(chan tcpAccepted)
+	a.done = make(chan struct{})
+	a.reading = make(map[*net.TCPConn]bool)
+	return &a
+}
+
+// Return the next connection.  Where PROXY headers are expected they
+// are read concurrently, each in a goroutine of its own, so that an
+// upstream which is slow to send one does not hold up the rest, and
+// connections are returned as their headers arrive.  A connection
+// whose header is bad is closed and reported to the observer.
 func (a *TcpAcceptor) Accept() (cnx ConnectionI, err error) {
-	conn, err := a.listener.AcceptTCP()
+	var r tcpAccepted
+	if a.proxy == nil {
+		r.conn, r.err = a.acceptTCP()
+	} else {
+		a.startOnce.Do(func() { go a.acceptLoop() })
+		select {
+		case r = <-a.ready:
+		case <-a.done:
+			r.err = net.ErrClosed
+		}
+	}
+	err = wrapError("accept", "tcp", a.endPoint, nil, r.err)
 	if err == nil {
-		cnx, err = NewTcpConnection(conn)
+		var tcpCnx *TcpConnection
+		if tcpCnx, err = NewTcpConnection(r.conn); err == nil {
+			if r.src != nil {
+				// the client named by the PROXY header
+				tcpCnx.farEnd = &TcpEndPoint{r.src}
+			}
+			a.stats.countAccept(&tcpCnx.stats)
+			observeAccept(tcpCnx)
+			cnx = tcpCnx
+		}
+	} else if !errors.Is(err, net.ErrClosed) {
+		a.stats.countReject()
+		observeError(nil, "accept", err)
 	}
 	return
 }
+
+// Accept a connection from the listener and apply the socket options.
+func (a *TcpAcceptor) acceptTCP() (*net.TCPConn, error) {
+	conn, err := a.listener.AcceptTCP()
+	if err == nil {
+		if err = a.opts.applyConn(conn); err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	return conn, err
+}
+
+func (a *TcpAcceptor) acceptLoop() {
+	for {
+		conn, err := a.acceptTCP()
+		if err != nil {
+			if a.isDone() {
+				return
+			}
+			a.deliver(tcpAccepted{err: err})
+			continue
+		}
+		a.mu.Lock()
+		closing := a.isDone()
+		if !closing {
+			a.reading[conn] = true
+		}
+		a.mu.Unlock()
+		if closing {
+			conn.Close()
+			return
+		}
+		go a.readHeader(conn)
+	}
+}
+
+// Read conn's PROXY header and hand it to Accept.
+func (a *TcpAcceptor) readHeader(conn *net.TCPConn) {
+	src, err := a.proxy.accept(conn)
+	a.mu.Lock()
+	delete(a.reading, conn)
+	a.mu.Unlock()
+	if a.isDone() {
+		conn.Close()
+		return
+	}
+	if err != nil {
+		// a bad header is the upstream's failure, not the acceptor's
+		farEnd, _ := NewTcpEndPoint(conn.RemoteAddr().String())
+		a.stats.countReject()
+		observeError(nil, "accept",
+			wrapError("proxy", "tcp", a.endPoint, farEnd, err))
+		conn.Close()
+		return
+	}
+	if !a.deliver(tcpAccepted{conn: conn, src: src}) {
+		conn.Close()
+		a.stats.countReject()
+	}
+}
+
+// Hand r to Accept, returning false if the acceptor closes first.
+func (a *TcpAcceptor) deliver(r tcpAccepted) bool {
+	select {
+	case a.ready <- r:
+		return true
+	case <-a.done:
+		return false
+	}
+}
+
+func (a *TcpAcceptor) isDone() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Expect PROXY protocol headers on connections from the upstreams
+// trusted by cfg, or stop expecting them if cfg is nil.  This should
+// be called before Accept.
+func (a *TcpAcceptor) SetProxyConfig(cfg *ProxyConfig) {
+	a.proxy = cfg
+}
+
+// Stop listening, closing any connection whose PROXY header is still
+// awaited.
 func (a *TcpAcceptor) Close() error {
-	a.closed = true
+	atomic.StoreInt32(&a.closed, 1)
+	a.mu.Lock()
+	a.closeOnce.Do(func() { close(a.done) })
+	for conn := range a.reading {
+		conn.Close()
+	}
+	a.mu.Unlock()
 	return a.listener.Close()
 }
 func (a *TcpAcceptor) IsClosed() bool {
-	return a.closed
+	return atomic.LoadInt32(&a.closed) != 0
 }
 func (a *TcpAcceptor) GetEndPoint() EndPointI {
 	return a.endPoint
 
 }
+
+// Return a duplicate of the listening socket, for handing to another
+// process.  Closing the one does not affect the other.
+func (a *TcpAcceptor) File() (*os.File, error) {
+	return a.listener.File()
+}
+func (a *TcpAcceptor) Stats() AcceptorStats {
+	return a.stats.snapshot()
+}
+
+// Return the socket options applied, nil if none.
+func (a *TcpAcceptor) GetOptions() *SocketOptions {
+	return a.opts
+}
 func (a *TcpAcceptor) String() string {
 	return "TcpAcceptor: " + a.endPoint.String()
 }
@@ -3,12 +3,16 @@ package transport
 import (
 	"fmt"
 	xc "github.com/jddixon/xlCrypto_go"
+	"io"
 	"net"
+	"time"
 )
 
 type TcpConnection struct {
-	conn  *net.TCPConn
-	state int
+	conn   *net.TCPConn
+	farEnd EndPointI // if not nil, reported in place of the socket's peer
+	state  int
+	stats  connCounters
 }
 
 func NewTcpConnection(conn *net.TCPConn) (cnx *TcpConnection, err error) {
@@ -16,6 +20,7 @@ func NewTcpConnection(conn *net.TCPConn) (cnx *TcpConnection, err error) {
 		err = NilConnection
 	} else {
 		cnx = &TcpConnection{conn: conn, state: CNX_CONNECTED}
+		cnx.stats.init(cnx, "tcp", RealClock{})
 	}
 	return
 }
@@ -51,8 +56,10 @@ func (c *TcpConnection) BindFarEnd(e EndPointI) (err error) {
 // Bring the connection to the DISCONNECTED state.
 //
 func (c *TcpConnection) Close() (err error) {
+	observeState(c, c.state, CNX_DISCONNECTED)
 	c.state = CNX_DISCONNECTED
-	return c.conn.Close()
+	c.stats.countClose()
+	return c.wrap("close", c.conn.Close())
 }
 
 // XXX 2013-07-20: this returns the far end instead !
@@ -63,16 +70,49 @@ func (c *TcpConnection) GetNearEnd() (ep EndPointI) {
 
 // XXX 2013-07-20: this returns the near end instead !
 func (c *TcpConnection) GetFarEnd() (ep EndPointI) {
+	if c.farEnd != nil {
+		return c.farEnd
+	}
 	ep, _ = NewTcpEndPoint(c.conn.RemoteAddr().String())
 	return ep
 }
 
-func (c *TcpConnection) Read(b []byte) (int, error) {
-	return c.conn.Read(b)
+// Wrap an error from operation op in a TransportError.
+func (c *TcpConnection) wrap(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return wrapError(op, "tcp", c.GetNearEnd(), c.GetFarEnd(), err)
+}
+
+func (c *TcpConnection) Read(b []byte) (count int, err error) {
+	count, err = c.conn.Read(b)
+	err = c.wrap("read", err)
+	c.stats.countRead(count, err)
+	return
 }
-func (c *TcpConnection) Write(b []byte) (int, error) {
-	return c.conn.Write(b)
+func (c *TcpConnection) Write(b []byte) (count int, err error) {
+	count, err = c.conn.Write(b)
+	err = c.wrap("write", err)
+	c.stats.countWrite(count, err)
+	return
+}
+func (c *TcpConnection) Stats() ConnStats {
+	return c.stats.snapshot()
 }
+// Turn the operating system's TCP keepalive on, probing an idle far end
+// every period, or off if period is zero.  The application hears of a
+// dead peer only as an error on its next read or write; see
+// FramedConnection for a heartbeat which tells it more promptly.
+func (c *TcpConnection) SetKeepAlive(period time.Duration) (err error) {
+	if period <= 0 {
+		err = c.conn.SetKeepAlive(false)
+	} else if err = c.conn.SetKeepAlive(true); err == nil {
+		err = c.conn.SetKeepAlivePeriod(period)
+	}
+	return c.wrap("keepalive", err)
+}
+
 func (c *TcpConnection) IsBlocking() bool {
 	// XXX STUB NotImplemented
 	return false
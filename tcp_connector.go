@@ -1,8 +1,8 @@
 package transport
 
 import (
-	"fmt"
 	"net"
+	"time"
 )
 
 // Used to establish a Connection with another entity (Node).
@@ -12,9 +12,12 @@ import (
 //
 type TcpConnector struct {
 	farEnd *TcpEndPoint
+	opts   *SocketOptions // nil if none
+	proxy  *ProxyHeader   // sent on connecting, if not nil
 }
 
-func NewTcpConnector(farEnd EndPointI) (*TcpConnector, error) {
+// Options given are applied to every socket the connector dials.
+func NewTcpConnector(farEnd EndPointI, opts ...*SocketOptions) (*TcpConnector, error) {
 	switch v := farEnd.(type) {
 	case *TcpEndPoint:
 		_ = v
@@ -26,7 +29,8 @@ func NewTcpConnector(farEnd EndPointI) (*TcpConnector, error) {
 	// copy the far end
 	ep2, err := tcpFarEnd.Clone()
 	if err == nil {
-		ctor := TcpConnector{ep2.(*TcpEndPoint)}
+		ctor := TcpConnector{farEnd: ep2.(*TcpEndPoint),
+			opts: mergeOptions(opts...)}
 		if err == nil {
 			return &ctor, nil
 		}
@@ -41,30 +45,91 @@ func NewTcpConnector(farEnd EndPointI) (*TcpConnector, error) {
 // @param blocking whether the new Connection is to be blocking
 //
 func (c *TcpConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
-	var tcpNearEnd *TcpEndPoint
-	if nearEnd == nil {
-		tcpNearEnd = ANY_TCP_END_POINT
+	if _, ok := nearEnd.(*TcpEndPoint); nearEnd != nil && !ok {
+		return nil, NotTcpEndPoint
+	}
+	finish := observeDial(nearEnd, c.farEnd)
+	start := time.Now()
+	tcpConn, err := c.dial(nearEnd)
+	DefaultMetrics.transport("tcp").countDial(err)
+	if err == nil {
+		cnx, _ := NewTcpConnection(tcpConn)
+		cnx.stats.setConnectLatency(time.Since(start))
+		finish(cnx, nil)
+		return cnx, nil
 	} else {
-		// XXX CHECK TYPE
-		tcpNearEnd = nearEnd.(*TcpEndPoint)
+		err = wrapError("dial", "tcp", nearEnd, c.farEnd, err)
+		finish(nil, err)
+		return nil, err
 	}
-	var err error
-	var tcpConn *net.TCPConn
-	if nearEnd == nil {
+}
+
+// Connect to the far end, sending the PROXY header if there is one,
+// but neither count nor observe the dial.  Connectors which go on to a
+// handshake of their own do that once the handshake is over.
+func (c *TcpConnector) dial(nearEnd EndPointI) (tcpConn *net.TCPConn, err error) {
+	tcpNearEnd := ANY_TCP_END_POINT
+	if nearEnd != nil {
+		var ok bool
+		if tcpNearEnd, ok = nearEnd.(*TcpEndPoint); !ok {
+			return nil, NotTcpEndPoint
+		}
+	}
+	if c.opts != nil {
+		tcpConn, err = c.dialWithOptions(nearEnd, tcpNearEnd)
+	} else if nearEnd == nil {
 		tcpConn, err = net.DialTCP("tcp", nil, c.farEnd.GetTcpAddr())
 	} else {
 		tcpConn, err = net.DialTCP("tcp", tcpNearEnd.GetTcpAddr(),
 			c.farEnd.GetTcpAddr())
 	}
+	if err == nil && c.proxy != nil {
+		if err = c.sendProxyHeader(tcpConn); err != nil {
+			tcpConn.Close()
+			tcpConn = nil
+		}
+	}
+	return
+}
+
+// Dial with the raw socket options set before connecting and the rest
+// once connected.
+func (c *TcpConnector) dialWithOptions(nearEnd EndPointI,
+	tcpNearEnd *TcpEndPoint) (tcpConn *net.TCPConn, err error) {
+
+	d := net.Dialer{Control: c.opts.control(), KeepAlive: c.opts.KeepAlive}
+	if nearEnd != nil {
+		d.LocalAddr = tcpNearEnd.GetTcpAddr()
+	}
+	var conn net.Conn
+	if conn, err = d.Dial("tcp", c.farEnd.GetTcpAddr().String()); err == nil {
+		tcpConn = conn.(*net.TCPConn)
+		if err = c.opts.applyConn(tcpConn); err != nil {
+			tcpConn.Close()
+			tcpConn = nil
+		}
+	}
+	return
+}
+
+// Send a PROXY protocol header on every connection made, or none if h
+// is nil.  This should be called before Connect.
+func (c *TcpConnector) SetProxyHeader(h *ProxyHeader) {
+	c.proxy = h
+}
+
+func (c *TcpConnector) sendProxyHeader(conn *net.TCPConn) error {
+	buf, err := c.proxy.encode(conn.LocalAddr().(*net.TCPAddr),
+		conn.RemoteAddr().(*net.TCPAddr))
 	if err == nil {
-		cnx := TcpConnection{tcpConn, CNX_CONNECTED}
-		return &cnx, nil
-	} else {
-		// DEBUG -- NEVER SEEN
-		fmt.Sprintf("Error in TcpConnector.Connect: %s", err.Error())
-		// END
-		return nil, err
+		_, err = conn.Write(buf)
 	}
+	return err
+}
+
+// Return the socket options applied, nil if none.
+func (c *TcpConnector) GetOptions() *SocketOptions {
+	return c.opts
 }
 
 // return the Acceptor EndPoint that this Connector is used to
@@ -77,5 +142,9 @@ func (c *TcpConnector) GetFarEnd() EndPointI {
 
 func (c *TcpConnector) String() string {
 	// farEnd serialization begins with "TcpEndPoint: "
-	return "TcpConnector: " + c.farEnd.String()[13:]
+	s := "TcpConnector: " + c.farEnd.String()[13:]
+	if opts := c.opts.String(); opts != "" {
+		s += "?" + opts
+	}
+	return s
 }
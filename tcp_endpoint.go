@@ -42,8 +42,9 @@ func (e *TcpEndPoint) Address() AddressI {
 	return a
 }
 
+// Address() is IPv4-only, so clone from the TCP address itself.
 func (e *TcpEndPoint) Clone() (ep EndPointI, err error) {
-	return NewTcpEndPoint(e.Address().String())
+	return NewTcpEndPoint(e.tcpAddr.String())
 }
 
 func (e *TcpEndPoint) Equal(any interface{}) bool {
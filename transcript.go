@@ -0,0 +1,305 @@
+package transport
+
+// xlTransport_go/transcript.go
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A transcript records what crossed a connection, as seen from its near
+// end.  A RecordingConnection writes one; ReplayTranscript plays one
+// back as the far end, so that a misbehaving peer can be reproduced in
+// a test without the peer itself.
+//
+// Transcripts are text, one record per line:
+//     xlTransport transcript 1
+//     near <near end point>
+//     far <far end point>
+//     <timestamp> > <base64 data>      sent by the near end
+//     <timestamp> < <base64 data>      received by the near end
+//     <timestamp> close
+// Timestamps are in RFC 3339 format with nanoseconds.
+
+const (
+	TRANSCRIPT_HEADER = "xlTransport transcript 1"
+
+	SENT     = '>'
+	RECEIVED = '<'
+)
+
+type TranscriptChunk struct {
+	At   time.Time
+	Dir  byte // SENT or RECEIVED
+	Data []byte
+}
+
+type Transcript struct {
+	NearEnd, FarEnd string
+	Chunks          []TranscriptChunk
+	ClosedAt        time.Time // zero if the near end never closed
+}
+
+// Parse a transcript in the format written by RecordingConnection.
+func ReadTranscript(r io.Reader) (t *Transcript, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*MAX_FRAME_LEN)
+	lineNo := 0
+	nextLine := func() (line string, ok bool) {
+		if ok = scanner.Scan(); ok {
+			lineNo++
+			line = scanner.Text()
+		}
+		return
+	}
+	bad := func() error {
+		return fmt.Errorf("%w: line %d", BadTranscript, lineNo)
+	}
+	line, ok := nextLine()
+	if !ok || line != TRANSCRIPT_HEADER {
+		return nil, BadTranscript
+	}
+	t = &Transcript{}
+	if line, ok = nextLine(); !ok || !strings.HasPrefix(line, "near ") {
+		return nil, bad()
+	}
+	t.NearEnd = line[5:]
+	if line, ok = nextLine(); !ok || !strings.HasPrefix(line, "far ") {
+		return nil, bad()
+	}
+	t.FarEnd = line[4:]
+	for err == nil {
+		if line, ok = nextLine(); !ok {
+			break
+		}
+		fields := strings.Split(line, " ")
+		var at time.Time
+		if at, err = time.Parse(time.RFC3339Nano, fields[0]); err != nil {
+			err = bad()
+		} else if len(fields) == 2 && fields[1] == "close" {
+			t.ClosedAt = at
+		} else if len(fields) == 3 && len(fields[1]) == 1 &&
+			(fields[1][0] == SENT || fields[1][0] == RECEIVED) {
+
+			var data []byte
+			if data, err = base64.StdEncoding.DecodeString(fields[2]); err != nil {
+				err = bad()
+			} else {
+				t.Chunks = append(t.Chunks,
+					TranscriptChunk{At: at, Dir: fields[1][0], Data: data})
+			}
+		} else {
+			err = bad()
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+	}
+	if err != nil {
+		t = nil
+	}
+	return
+}
+
+// Read the transcript in the named file.
+func LoadTranscript(path string) (*Transcript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTranscript(f)
+}
+
+// Return all bytes moving in direction dir, concatenated.
+func (t *Transcript) Bytes(dir byte) []byte {
+	var buf bytes.Buffer
+	for _, chunk := range t.Chunks {
+		if chunk.Dir == dir {
+			buf.Write(chunk.Data)
+		}
+	}
+	return buf.Bytes()
+}
+
+// RECORDING ////////////////////////////////////////////////////////
+
+// Passes everything through to the underlying connection while writing
+// a transcript of it.
+type RecordingConnection struct {
+	ConnectionI
+	mu     sync.Mutex
+	w      io.Writer
+	file   *os.File // if we opened the transcript, closed with us
+	clock  ClockI
+	logErr error // the first error writing the transcript
+}
+
+// Record traffic over cnx to w, beginning with the transcript header.
+func NewRecordingConnection(cnx ConnectionI, w io.Writer) (
+	rc *RecordingConnection, err error) {
+
+	if cnx == nil {
+		err = NilConnection
+	} else {
+		rc = &RecordingConnection{ConnectionI: cnx, w: w, clock: RealClock{}}
+		_, err = fmt.Fprintf(w, "%s\nnear %s\nfar %s\n", TRANSCRIPT_HEADER,
+			cnx.GetNearEnd().String(), cnx.GetFarEnd().String())
+		if err != nil {
+			rc = nil
+		}
+	}
+	return
+}
+
+// Record traffic over cnx to the file at path, which is created or
+// truncated, and closed when the connection is.
+func NewRecordingConnectionToFile(cnx ConnectionI, path string) (
+	rc *RecordingConnection, err error) {
+
+	var f *os.File
+	if f, err = os.Create(path); err == nil {
+		if rc, err = NewRecordingConnection(cnx, f); err == nil {
+			rc.file = f
+		} else {
+			f.Close()
+		}
+	}
+	return
+}
+
+// Set the clock used to timestamp the transcript.
+func (rc *RecordingConnection) SetClock(clock ClockI) {
+	rc.mu.Lock()
+	rc.clock = clock
+	rc.mu.Unlock()
+}
+
+// Return the first error encountered writing the transcript, if any.
+// Traffic continues to flow even if the transcript cannot be written.
+func (rc *RecordingConnection) TranscriptErr() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.logErr
+}
+
+func (rc *RecordingConnection) record(rest string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.logErr == nil {
+		at := rc.clock.Now().UTC().Format(time.RFC3339Nano)
+		_, rc.logErr = fmt.Fprintf(rc.w, "%s %s\n", at, rest)
+	}
+}
+
+// Record data as chunks of at most MAX_FRAME_LEN bytes, so that no
+// line is too long for ReadTranscript.
+func (rc *RecordingConnection) recordChunk(dir byte, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > MAX_FRAME_LEN {
+			n = MAX_FRAME_LEN
+		}
+		rc.record(fmt.Sprintf("%c %s", dir,
+			base64.StdEncoding.EncodeToString(data[:n])))
+		data = data[n:]
+	}
+}
+
+func (rc *RecordingConnection) Read(b []byte) (count int, err error) {
+	count, err = rc.ConnectionI.Read(b)
+	rc.recordChunk(RECEIVED, b[:count])
+	return
+}
+
+func (rc *RecordingConnection) Write(b []byte) (count int, err error) {
+	count, err = rc.ConnectionI.Write(b)
+	rc.recordChunk(SENT, b[:count])
+	return
+}
+
+func (rc *RecordingConnection) Close() (err error) {
+	err = rc.ConnectionI.Close()
+	rc.record("close")
+	if rc.file != nil {
+		if fErr := rc.file.Close(); err == nil {
+			err = fErr
+		}
+	}
+	return
+}
+
+func (rc *RecordingConnection) String() string {
+	return "Recording: " + rc.ConnectionI.String()
+}
+
+// REPLAY ///////////////////////////////////////////////////////////
+
+// Play the far end of transcript t over cnx: what the recorded peer
+// sent is written to cnx and what the recorded near end sent is read
+// from cnx and compared with the transcript, in the order recorded.
+// If clock is not nil the recorded gaps between chunks are reproduced
+// on it.  Returns TranscriptMismatch, with detail, if the near end
+// departs from the transcript; otherwise closes cnx at the point at
+// which the near end was recorded closing, if it was.
+func ReplayTranscript(t *Transcript, cnx ConnectionI, clock ClockI) (err error) {
+	var last time.Time
+	for i := 0; err == nil && i < len(t.Chunks); i++ {
+		chunk := t.Chunks[i]
+		if clock != nil && !last.IsZero() && chunk.At.After(last) {
+			clock.Sleep(chunk.At.Sub(last))
+		}
+		last = chunk.At
+		if chunk.Dir == RECEIVED {
+			// the near end received this, so the peer sent it
+			_, err = cnx.Write(chunk.Data)
+		} else {
+			got := make([]byte, len(chunk.Data))
+			var count int
+			count, err = io.ReadFull(cnx, got)
+			if err == nil && !bytes.Equal(got, chunk.Data) {
+				err = fmt.Errorf("%w: chunk %d: expected %q, got %q",
+					TranscriptMismatch, i, chunk.Data, got)
+			} else if err != nil {
+				err = fmt.Errorf("%w: chunk %d: read %d of %d bytes: %v",
+					TranscriptMismatch, i, count, len(chunk.Data), err)
+			}
+		}
+	}
+	if err == nil && !t.ClosedAt.IsZero() {
+		err = cnx.Close()
+	}
+	return
+}
+
+// Return one end of a MockConnection whose far end replays transcript
+// t, as ReplayTranscript does, in its own goroutine.  The result of the
+// replay is sent on done.
+func NewReplayConnection(t *Transcript, clock ClockI) (
+	cnx *MockConnection, done <-chan error, err error) {
+
+	nearEnd := NewMockEndPoint("replay", t.NearEnd).(*MockEndPoint)
+	farEnd := NewMockEndPoint("replay", t.FarEnd).(*MockEndPoint)
+	if clock == nil {
+		cnx, err = NewMockConnection(nearEnd, farEnd)
+	} else {
+		cnx, err = newMockConnection(nearEnd, farEnd, clock, 0)
+	}
+	if err == nil {
+		var peer *MockConnection
+		peer, _ = NewReverseMockConnection(cnx)
+		result := make(chan error, 1)
+		go func() {
+			result <- ReplayTranscript(t, peer, clock)
+		}()
+		done = result
+	}
+	return
+}
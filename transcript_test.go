@@ -0,0 +1,137 @@
+package transport
+
+// xlTransport_go/transcript_test.go
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	xr "github.com/jddixon/rnglib_go"
+	. "gopkg.in/check.v1"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The client sends a request and reads back its length and the request
+// reversed: the kind of exchange we might want to reproduce.
+func (s *XLSuite) runReverseClient(c *C, cnx ConnectionI, request []byte) []byte {
+	_, err := cnx.Write(request)
+	c.Assert(err, IsNil)
+	reply := make([]byte, len(request))
+	_, err = io.ReadFull(cnx, reply)
+	c.Assert(err, IsNil)
+	return reply
+}
+
+func reverseServer(cnx ConnectionI, n int) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(cnx, buf); err == nil {
+		for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+			buf[i], buf[j] = buf[j], buf[i]
+		}
+		cnx.Write(buf)
+	}
+}
+
+func (s *XLSuite) TestRecordAndReplay(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_RECORD_AND_REPLAY")
+	}
+	rng := xr.MakeSimpleRNG()
+	request := make([]byte, 64+rng.Intn(64))
+	rng.NextBytes(request)
+
+	// record a session with a live server
+	clock := NewVirtualClock(epoch)
+	client, server := s.makeTcpPair(c)
+	go reverseServer(server, len(request))
+	path := filepath.Join(c.MkDir(), "session.transcript")
+	rc, err := NewRecordingConnectionToFile(client, path)
+	c.Assert(err, IsNil)
+	rc.SetClock(clock)
+	reply := s.runReverseClient(c, rc, request)
+	clock.Advance(time.Second)
+	c.Assert(rc.Close(), IsNil)
+	c.Assert(rc.TranscriptErr(), IsNil)
+	server.Close()
+
+	// the transcript holds what crossed the wire, with the end points
+	t, err := LoadTranscript(path)
+	c.Assert(err, IsNil)
+	c.Assert(t.NearEnd, Equals, client.GetNearEnd().String())
+	c.Assert(t.FarEnd, Equals, client.GetFarEnd().String())
+	c.Assert(bytes.Equal(t.Bytes(SENT), request), Equals, true)
+	c.Assert(bytes.Equal(t.Bytes(RECEIVED), reply), Equals, true)
+	c.Assert(t.Chunks[0].At.Equal(epoch), Equals, true)
+	c.Assert(t.ClosedAt.Equal(epoch.Add(time.Second)), Equals, true)
+
+	// replaying it reproduces the server without the server
+	cnx, done, err := NewReplayConnection(t, nil)
+	c.Assert(err, IsNil)
+	replayed := s.runReverseClient(c, cnx, request)
+	c.Assert(bytes.Equal(replayed, reply), Equals, true)
+	c.Assert(<-done, IsNil)
+	_, err = cnx.Read(make([]byte, 1))
+	c.Assert(err, Equals, io.EOF)
+
+	// a client which departs from the transcript is caught
+	cnx, done, err = NewReplayConnection(t, nil)
+	c.Assert(err, IsNil)
+	different := make([]byte, len(request))
+	copy(different, request)
+	different[0]++
+	cnx.Write(different)
+	err = <-done
+	c.Assert(errors.Is(err, TranscriptMismatch), Equals, true)
+}
+
+// A Write of any size is recorded as lines ReadTranscript can parse.
+func (s *XLSuite) TestRecordLargeWrite(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_RECORD_LARGE_WRITE")
+	}
+	rng := xr.MakeSimpleRNG()
+	request := make([]byte, 4*1024*1024) // as transporttest's LargePayload
+	rng.NextBytes(request)
+
+	client, server := s.makeTcpPair(c)
+	go reverseServer(server, len(request))
+	var buf bytes.Buffer
+	rc, err := NewRecordingConnection(client, &buf)
+	c.Assert(err, IsNil)
+	reply := s.runReverseClient(c, rc, request)
+	c.Assert(rc.Close(), IsNil)
+	c.Assert(rc.TranscriptErr(), IsNil)
+	server.Close()
+
+	t, err := ReadTranscript(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(t.Bytes(SENT), request), Equals, true)
+	c.Assert(bytes.Equal(t.Bytes(RECEIVED), reply), Equals, true)
+	for _, chunk := range t.Chunks {
+		c.Assert(len(chunk.Data) <= MAX_FRAME_LEN, Equals, true)
+	}
+
+	cnx, done, err := NewReplayConnection(t, nil)
+	c.Assert(err, IsNil)
+	replayed := s.runReverseClient(c, cnx, request)
+	c.Assert(bytes.Equal(replayed, reply), Equals, true)
+	c.Assert(<-done, IsNil)
+}
+
+func (s *XLSuite) TestBadTranscript(c *C) {
+	_, err := ReadTranscript(bytes.NewBufferString("not a transcript\n"))
+	c.Assert(err, Equals, BadTranscript)
+
+	text := TRANSCRIPT_HEADER + "\nnear A\nfar B\n" +
+		"2014-05-14T00:00:00Z > aGVsbG8=\n" +
+		"2014-05-14T00:00:01Z ? aGVsbG8=\n"
+	_, err = ReadTranscript(bytes.NewBufferString(text))
+	c.Assert(errors.Is(err, BadTranscript), Equals, true)
+	c.Assert(err.Error(), Equals, BadTranscript.Error()+": line 5")
+
+	_, err = LoadTranscript(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
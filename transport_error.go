@@ -0,0 +1,159 @@
+package transport
+
+// xlTransport_go/transport_error.go
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The sentinels in errors.go say what went wrong.  A TransportError says
+// where: which operation, over which transport, between which end
+// points.  It wraps its cause, so that errors.Is and errors.As see
+// through it to the sentinel or the underlying net or syscall error.
+//
+// Retry logic should classify errors with the predicates below rather
+// than by comparing error strings: IsTimeout, IsRefused, IsReset and
+// IsClosed recognize both this library's sentinels and the equivalent
+// errors from the net package and the operating system, and
+// IsTemporary says whether trying again might succeed.
+
+type TransportError struct {
+	Op        string    // "dial", "listen", "accept", "read", "write", "close"
+	Transport string    // "tcp", for example
+	Near, Far EndPointI // either may be nil
+	Err       error     // the cause
+}
+
+func endPointAddr(ep EndPointI) string {
+	if ep == nil {
+		return "?"
+	}
+	return hostPort(ep)
+}
+
+// For example "tcp dial 127.0.0.1:0 -> 127.0.0.1:5555: connect:
+// connection refused".
+func (e *TransportError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Transport)
+	b.WriteString(" ")
+	b.WriteString(e.Op)
+	if e.Near != nil || e.Far != nil {
+		b.WriteString(" ")
+		b.WriteString(endPointAddr(e.Near))
+		if e.Far != nil {
+			b.WriteString(" -> ")
+			b.WriteString(endPointAddr(e.Far))
+		}
+	}
+	if e.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Satisfies net.Error.
+func (e *TransportError) Timeout() bool {
+	return IsTimeout(e.Err)
+}
+
+// Satisfies net.Error.
+func (e *TransportError) Temporary() bool {
+	return IsTemporary(e.Err)
+}
+
+// Wrap err, if it is not nil, in a TransportError.  io.EOF is returned
+// as it is, as io.Reader requires.
+func wrapError(op, transport string, near, far EndPointI, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return &TransportError{Op: op, Transport: transport, Near: near,
+		Far: far, Err: err}
+}
+
+// CLASSIFICATION ///////////////////////////////////////////////////
+
+// Whether err is or wraps a timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, ConnectTimeout) || errors.Is(err, ReadTimeout) ||
+		errors.Is(err, PreambleTimeout) || errors.Is(err, HelloTimeout) ||
+		errors.Is(err, PeerDead) || errors.Is(err, RetransmitLimit) ||
+		errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, syscall.ETIMEDOUT) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// Whether err is or wraps a refusal by the far end to connect.
+func IsRefused(err error) bool {
+	return errors.Is(err, ConnectionRefused) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Whether err is or wraps the far end resetting or aborting an
+// established connection.
+func IsReset(err error) bool {
+	return errors.Is(err, ConnectionReset) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// Whether err is or wraps an operation on a connection or acceptor
+// which has been closed, at either end.
+func IsClosed(err error) bool {
+	return errors.Is(err, ClosedConnection) ||
+		errors.Is(err, AcceptorClosed) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.ErrClosedPipe) ||
+		errors.Is(err, io.EOF)
+}
+
+// The bounds on how long a server waits before accepting again after
+// a temporary failure.
+const (
+	ACCEPT_RETRY_MIN = 5 * time.Millisecond
+	ACCEPT_RETRY_MAX = time.Second
+)
+
+// Return how long to wait after a temporary accept failure, given the
+// wait after the last one in a row, or zero if there was none: as
+// net/http's Server does, the wait starts at ACCEPT_RETRY_MIN and
+// doubles with each failure to at most ACCEPT_RETRY_MAX.
+func acceptRetryDelay(last time.Duration) time.Duration {
+	if last == 0 {
+		return ACCEPT_RETRY_MIN
+	}
+	if last *= 2; last > ACCEPT_RETRY_MAX {
+		last = ACCEPT_RETRY_MAX
+	}
+	return last
+}
+
+// Whether the operation which returned err might succeed if tried
+// again: timeouts, refusals, resets and shortages of resources are
+// temporary; everything else, including closure, is permanent.
+func IsTemporary(err error) bool {
+	if err == nil {
+		return false
+	}
+	return IsTimeout(err) || IsRefused(err) || IsReset(err) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS)
+}
@@ -0,0 +1,125 @@
+package transport
+
+// xlTransport_go/transport_error_test.go
+
+import (
+	"errors"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+func (s *XLSuite) TestErrorClassification(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ERROR_CLASSIFICATION")
+	}
+	wrapped := func(err error) error {
+		return fmt.Errorf("context: %w",
+			&TransportError{Op: "read", Transport: "tcp", Err: err})
+	}
+	cases := []struct {
+		err                                   error
+		timeout, refused, reset, closed, temp bool
+	}{
+		{ConnectTimeout, true, false, false, false, true},
+		{ReadTimeout, true, false, false, false, true},
+		{PreambleTimeout, true, false, false, false, true},
+		{HelloTimeout, true, false, false, false, true},
+		{os.ErrDeadlineExceeded, true, false, false, false, true},
+		{ConnectionRefused, false, true, false, false, true},
+		{syscall.ECONNREFUSED, false, true, false, false, true},
+		{ConnectionReset, false, false, true, false, true},
+		{syscall.EPIPE, false, false, true, false, true},
+		{ClosedConnection, false, false, false, true, false},
+		{AcceptorClosed, false, false, false, true, false},
+		{io.EOF, false, false, false, true, false},
+		{syscall.EMFILE, false, false, false, false, true},
+		{BadRecord, false, false, false, false, false},
+	}
+	for _, k := range cases {
+		for _, err := range []error{k.err, wrapped(k.err)} {
+			comment := Commentf("%v", err)
+			c.Assert(IsTimeout(err), Equals, k.timeout, comment)
+			c.Assert(IsRefused(err), Equals, k.refused, comment)
+			c.Assert(IsReset(err), Equals, k.reset, comment)
+			c.Assert(IsClosed(err), Equals, k.closed, comment)
+			c.Assert(IsTemporary(err), Equals, k.temp, comment)
+		}
+	}
+	c.Assert(IsTemporary(nil), Equals, false)
+}
+
+func (s *XLSuite) TestTcpErrors(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_TCP_ERRORS")
+	}
+	// nothing listening: refused, with the far end in the error
+	acc, err := NewTcpAcceptor("127.0.0.1:0")
+	c.Assert(err, IsNil)
+	farEnd := acc.GetEndPoint()
+	acc.Close()
+	ctor, err := NewTcpConnector(farEnd)
+	c.Assert(err, IsNil)
+	_, err = ctor.Connect(nil)
+	var te *TransportError
+	c.Assert(errors.As(err, &te), Equals, true)
+	c.Assert(te.Op, Equals, "dial")
+	c.Assert(te.Far.Equal(farEnd), Equals, true)
+	c.Assert(IsRefused(err), Equals, true)
+	c.Assert(IsTemporary(err), Equals, true)
+	c.Assert(err.Error(), Matches, "tcp dial \\? -> "+farEnd.Address().String()+": .*")
+
+	// a far end which resets the connection
+	client, server := s.makeTcpPair(c)
+	server.(*TcpConnection).conn.SetLinger(0)
+	server.Close()
+	client.(*TcpConnection).conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	c.Assert(IsReset(err), Equals, true, Commentf("%v", err))
+	c.Assert(errors.As(err, &te), Equals, true)
+	c.Assert(te.Op, Equals, "read")
+
+	// a closed connection is closed, not temporarily unavailable, and
+	// EOF is left bare for io.Reader's sake
+	client.Close()
+	_, err = client.Write([]byte("late"))
+	c.Assert(IsClosed(err), Equals, true)
+	c.Assert(IsTemporary(err), Equals, false)
+
+	client, server = s.makeTcpPair(c)
+	server.Close()
+	_, err = client.Read(make([]byte, 1))
+	c.Assert(err, Equals, io.EOF)
+	client.Close()
+
+	// IPv6 end points, whose Address() is nil, are named in full
+	near6, err := NewTcpEndPoint("[::1]:1")
+	c.Assert(err, IsNil)
+	far6, err := NewTcpEndPoint("[::1]:2")
+	c.Assert(err, IsNil)
+	err = wrapError("dial", "tcp", near6, far6, ConnectionRefused)
+	c.Assert(err.Error(), Equals,
+		"tcp dial [::1]:1 -> [::1]:2: connection refused")
+	udp6, err := NewUdpEndPoint("[::1]:3")
+	c.Assert(err, IsNil)
+	err = wrapError("read", "rudp", udp6, nil, ConnectionReset)
+	c.Assert(err.Error(), Equals, "rudp read [::1]:3: connection reset")
+}
+
+func (s *XLSuite) TestAcceptRetryDelay(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_ACCEPT_RETRY_DELAY")
+	}
+	var delays []time.Duration
+	var d time.Duration
+	for i := 0; i < 10; i++ {
+		d = acceptRetryDelay(d)
+		delays = append(delays, d)
+	}
+	c.Assert(delays[0], Equals, ACCEPT_RETRY_MIN)
+	c.Assert(delays[1], Equals, 2*ACCEPT_RETRY_MIN)
+	c.Assert(delays[9], Equals, ACCEPT_RETRY_MAX)
+}
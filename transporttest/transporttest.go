@@ -0,0 +1,559 @@
+// Package transporttest provides a conformance suite which any
+// implementation of the xlTransport_go interfaces can be run against,
+// so that every transport is held to the same contract.
+//
+// A transport's own tests supply a Factory and call Run:
+//
+//	func TestConformance(t *testing.T) {
+//		transporttest.Run(t, &transporttest.Factory{
+//			NewAcceptor:  func() (xt.AcceptorI, error) { ... },
+//			NewConnector: func(ep xt.EndPointI) (xt.ConnectorI, error) { ... },
+//		})
+//	}
+package transporttest
+
+// xlTransport_go/transporttest/transporttest.go
+
+import (
+	"bytes"
+	"fmt"
+	xt "github.com/jddixon/xlTransport_go"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Supplies the transport under test.
+type Factory struct {
+	// Return a fresh acceptor listening on an endpoint of its choice.
+	NewAcceptor func() (xt.AcceptorI, error)
+
+	// Return a connector which will connect to farEnd, the endpoint of
+	// an acceptor made by NewAcceptor.
+	NewConnector func(farEnd xt.EndPointI) (xt.ConnectorI, error)
+}
+
+const (
+	// How long any single check may take before the suite declares
+	// that the transport has hung.
+	CHECK_TIMEOUT = 20 * time.Second
+
+	LARGE_PAYLOAD   = 4 * 1024 * 1024
+	CONCURRENT_CNXS = 16
+)
+
+// Run every conformance check as a subtest of t.
+func Run(t *testing.T, f *Factory) {
+	checks := []struct {
+		name  string
+		check func(*testing.T, *Factory)
+	}{
+		{"States", checkStates},
+		{"EndPoints", checkEndPoints},
+		{"Ordering", checkOrdering},
+		{"FullDuplex", checkFullDuplex},
+		{"LargePayload", checkLargePayload},
+		{"CloseEOF", checkCloseEOF},
+		{"Concurrency", checkConcurrency},
+		{"AcceptorClose", checkAcceptorClose},
+		{"Stats", checkStats},
+	}
+	for _, ch := range checks {
+		ch := ch
+		t.Run(ch.name, func(t *testing.T) {
+			tr := &tracker{}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				ch.check(t, tr.factory(f))
+			}()
+			select {
+			case <-done:
+			case <-time.After(CHECK_TIMEOUT):
+				// free the check, so that it cannot report on t
+				// once the subtest is over
+				tr.closeAll()
+				<-done
+				t.Fatalf("%s: timed out after %v", ch.name, CHECK_TIMEOUT)
+			}
+		})
+	}
+}
+
+// Remembers every acceptor and connection a check opens, so that a
+// check which hangs can be freed by closing them all.  What the check
+// is given is wrapped so that the check and the tracker can both close
+// it without racing.
+type tracker struct {
+	mu      sync.Mutex
+	closers []io.Closer
+	closed  bool
+}
+
+func (tr *tracker) add(c io.Closer) {
+	tr.mu.Lock()
+	closed := tr.closed
+	if !closed {
+		tr.closers = append(tr.closers, c)
+	}
+	tr.mu.Unlock()
+	if closed {
+		c.Close()
+	}
+}
+
+func (tr *tracker) closeAll() {
+	tr.mu.Lock()
+	tr.closed = true
+	closers := tr.closers
+	tr.closers = nil
+	tr.mu.Unlock()
+	for _, c := range closers {
+		c.Close()
+	}
+}
+
+// Return a factory making what f makes, tracked by tr.
+func (tr *tracker) factory(f *Factory) *Factory {
+	return &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			acc, err := f.NewAcceptor()
+			if err != nil {
+				return nil, err
+			}
+			a := &trackedAcceptor{AcceptorI: acc, tr: tr}
+			tr.add(a)
+			return a, nil
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			ctor, err := f.NewConnector(farEnd)
+			if err != nil {
+				return nil, err
+			}
+			return &trackedConnector{ctor, tr}, nil
+		},
+	}
+}
+
+// Close c once, however many times and from however many goroutines
+// this is called.
+type closeOnce struct {
+	once sync.Once
+	err  error
+}
+
+func (o *closeOnce) close(c io.Closer) error {
+	o.once.Do(func() { o.err = c.Close() })
+	return o.err
+}
+
+type trackedAcceptor struct {
+	xt.AcceptorI
+	tr   *tracker
+	once closeOnce
+}
+
+func (a *trackedAcceptor) Accept() (xt.ConnectionI, error) {
+	cnx, err := a.AcceptorI.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return a.tr.track(cnx), nil
+}
+
+func (a *trackedAcceptor) Close() error {
+	return a.once.close(a.AcceptorI)
+}
+
+type trackedConnector struct {
+	xt.ConnectorI
+	tr *tracker
+}
+
+func (c *trackedConnector) Connect(nearEnd xt.EndPointI) (xt.ConnectionI, error) {
+	cnx, err := c.ConnectorI.Connect(nearEnd)
+	if err != nil {
+		return nil, err
+	}
+	return c.tr.track(cnx), nil
+}
+
+type trackedConnection struct {
+	xt.ConnectionI
+	once closeOnce
+}
+
+func (c *trackedConnection) Close() error {
+	return c.once.close(c.ConnectionI)
+}
+
+func (tr *tracker) track(cnx xt.ConnectionI) xt.ConnectionI {
+	tc := &trackedConnection{ConnectionI: cnx}
+	tr.add(tc)
+	return tc
+}
+
+// A connected pair and the acceptor which produced the server end.
+type pair struct {
+	acc            xt.AcceptorI
+	client, server xt.ConnectionI
+}
+
+func (p *pair) close() {
+	p.client.Close()
+	p.server.Close()
+	p.acc.Close()
+}
+
+// Make an acceptor and connect a single client to it.  Failures are
+// reported with t.Error and return nil, as checks run outside the test
+// goroutine may not call t.Fatal.
+func newPair(t *testing.T, f *Factory) *pair {
+	acc, err := f.NewAcceptor()
+	if err != nil {
+		t.Errorf("NewAcceptor: %v", err)
+		return nil
+	}
+	accepted := make(chan xt.ConnectionI, 1)
+	acceptErr := make(chan error, 1)
+	go func() {
+		cnx, err := acc.Accept()
+		if err != nil {
+			acceptErr <- err
+		} else {
+			accepted <- cnx
+		}
+	}()
+	ctor, err := f.NewConnector(acc.GetEndPoint())
+	if err != nil {
+		acc.Close()
+		t.Errorf("NewConnector: %v", err)
+		return nil
+	}
+	client, err := ctor.Connect(nil)
+	if err != nil {
+		acc.Close()
+		t.Errorf("Connect: %v", err)
+		return nil
+	}
+	select {
+	case server := <-accepted:
+		return &pair{acc, client, server}
+	case err = <-acceptErr:
+		client.Close()
+		acc.Close()
+		t.Errorf("Accept: %v", err)
+		return nil
+	}
+}
+
+func randomBytes(rng *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	rng.Read(b)
+	return b
+}
+
+// Read exactly len(want) bytes from cnx and compare them with want.
+func expect(t *testing.T, what string, cnx xt.ConnectionI, want []byte) bool {
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(cnx, got); err != nil {
+		t.Errorf("%s: reading %d bytes: %v", what, len(want), err)
+		return false
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s: bytes read differ from bytes written", what)
+		return false
+	}
+	return true
+}
+
+// Connections are CONNECTED once made and DISCONNECTED once closed.
+func checkStates(t *testing.T, f *Factory) {
+	p := newPair(t, f)
+	if p == nil {
+		return
+	}
+	defer p.close()
+	if s := p.client.GetState(); s != xt.CNX_CONNECTED {
+		t.Errorf("client state is %d after Connect, expected CONNECTED", s)
+	}
+	if s := p.server.GetState(); s != xt.CNX_CONNECTED {
+		t.Errorf("server state is %d after Accept, expected CONNECTED", s)
+	}
+	if err := p.client.Close(); err != nil {
+		t.Errorf("closing client: %v", err)
+	}
+	if s := p.client.GetState(); s != xt.CNX_DISCONNECTED {
+		t.Errorf("client state is %d after Close, expected DISCONNECTED", s)
+	}
+	if _, err := p.client.Write([]byte("too late")); err == nil {
+		t.Errorf("Write on a closed connection succeeded")
+	}
+}
+
+// Each end reports the other's endpoint as its far end, and the client
+// is connected to the acceptor's endpoint.
+func checkEndPoints(t *testing.T, f *Factory) {
+	p := newPair(t, f)
+	if p == nil {
+		return
+	}
+	defer p.close()
+	cNear, cFar := p.client.GetNearEnd(), p.client.GetFarEnd()
+	sNear, sFar := p.server.GetNearEnd(), p.server.GetFarEnd()
+	if cNear == nil || cFar == nil || sNear == nil || sFar == nil {
+		t.Errorf("nil endpoint reported")
+		return
+	}
+	if !cFar.Equal(p.acc.GetEndPoint()) {
+		t.Errorf("client far end %s is not acceptor end point %s",
+			cFar, p.acc.GetEndPoint())
+	}
+	if !sFar.Equal(cNear) {
+		t.Errorf("server far end %s is not client near end %s", sFar, cNear)
+	}
+	if !sNear.Equal(cFar) {
+		t.Errorf("server near end %s is not client far end %s", sNear, cFar)
+	}
+	if cNear.Transport() != cFar.Transport() {
+		t.Errorf("near end transport %s differs from far end transport %s",
+			cNear.Transport(), cFar.Transport())
+	}
+}
+
+// Many small writes arrive in the order written.
+func checkOrdering(t *testing.T, f *Factory) {
+	p := newPair(t, f)
+	if p == nil {
+		return
+	}
+	defer p.close()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var all []byte
+	chunks := make([][]byte, 256)
+	for i := range chunks {
+		chunks[i] = randomBytes(rng, 1+rng.Intn(256))
+		all = append(all, chunks[i]...)
+	}
+	// write while the server reads, as a socket may not buffer it all
+	wrote := make(chan struct{})
+	go func() {
+		defer close(wrote)
+		for i, chunk := range chunks {
+			if _, err := p.client.Write(chunk); err != nil {
+				t.Errorf("write %d: %v", i, err)
+				return
+			}
+		}
+	}()
+	expect(t, "ordering", p.server, all)
+	p.client.Close() // freeing the writer if the read failed
+	<-wrote
+}
+
+// Both ends can write and read at the same time.
+func checkFullDuplex(t *testing.T, f *Factory) {
+	p := newPair(t, f)
+	if p == nil {
+		return
+	}
+	defer p.close()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	c2s := randomBytes(rng, 512*1024)
+	s2c := randomBytes(rng, 512*1024)
+
+	var wg sync.WaitGroup
+	send := func(what string, cnx xt.ConnectionI, data []byte) {
+		defer wg.Done()
+		for len(data) > 0 {
+			n := 4096
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := cnx.Write(data[:n]); err != nil {
+				t.Errorf("%s: %v", what, err)
+				return
+			}
+			data = data[n:]
+		}
+	}
+	receive := func(what string, cnx xt.ConnectionI, data []byte) {
+		defer wg.Done()
+		expect(t, what, cnx, data)
+	}
+	wg.Add(4)
+	go send("client write", p.client, c2s)
+	go send("server write", p.server, s2c)
+	go receive("server read", p.server, c2s)
+	go receive("client read", p.client, s2c)
+	wg.Wait()
+}
+
+// A single large write arrives intact.
+func checkLargePayload(t *testing.T, f *Factory) {
+	p := newPair(t, f)
+	if p == nil {
+		return
+	}
+	defer p.close()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	data := randomBytes(rng, LARGE_PAYLOAD)
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := p.client.Write(data)
+		writeErr <- err
+	}()
+	expect(t, "large payload", p.server, data)
+	if err := <-writeErr; err != nil {
+		t.Errorf("writing large payload: %v", err)
+	}
+}
+
+// When one end closes, the other reads what was sent and then io.EOF.
+func checkCloseEOF(t *testing.T, f *Factory) {
+	p := newPair(t, f)
+	if p == nil {
+		return
+	}
+	defer p.close()
+	msg := []byte("last words")
+	if _, err := p.client.Write(msg); err != nil {
+		t.Errorf("write: %v", err)
+		return
+	}
+	if err := p.client.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	if !expect(t, "before EOF", p.server, msg) {
+		return
+	}
+	buf := make([]byte, 16)
+	count, err := p.server.Read(buf)
+	if err != io.EOF {
+		t.Errorf("read after far end closed returned %d, %v; expected EOF",
+			count, err)
+	}
+}
+
+// Many connections to one acceptor carry traffic independently.
+func checkConcurrency(t *testing.T, f *Factory) {
+	acc, err := f.NewAcceptor()
+	if err != nil {
+		t.Errorf("NewAcceptor: %v", err)
+		return
+	}
+	defer acc.Close()
+
+	// the server echoes everything on every connection
+	go func() {
+		for {
+			cnx, err := acc.Accept()
+			if err != nil {
+				return
+			}
+			go func(cnx xt.ConnectionI) {
+				defer cnx.Close()
+				io.Copy(cnx, cnx)
+			}(cnx)
+		}
+	}()
+	ctor, err := f.NewConnector(acc.GetEndPoint())
+	if err != nil {
+		t.Errorf("NewConnector: %v", err)
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < CONCURRENT_CNXS; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(i) + time.Now().UnixNano()))
+			cnx, err := ctor.Connect(nil)
+			if err != nil {
+				t.Errorf("client %d: connect: %v", i, err)
+				return
+			}
+			defer cnx.Close()
+			for j := 0; j < 16; j++ {
+				msg := randomBytes(rng, 1+rng.Intn(8192))
+				if _, err := cnx.Write(msg); err != nil {
+					t.Errorf("client %d: write: %v", i, err)
+					return
+				}
+				if !expect(t, fmt.Sprintf("client %d echo %d", i, j), cnx, msg) {
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// Closing an acceptor unblocks Accept with an error and is reported by
+// IsClosed.
+func checkAcceptorClose(t *testing.T, f *Factory) {
+	acc, err := f.NewAcceptor()
+	if err != nil {
+		t.Errorf("NewAcceptor: %v", err)
+		return
+	}
+	if acc.IsClosed() {
+		t.Errorf("new acceptor reports that it is closed")
+	}
+	acceptErr := make(chan error, 1)
+	go func() {
+		_, err := acc.Accept()
+		acceptErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err = acc.Close(); err != nil {
+		t.Errorf("closing acceptor: %v", err)
+	}
+	if err = <-acceptErr; err == nil {
+		t.Errorf("Accept on a closed acceptor succeeded")
+	}
+	if !acc.IsClosed() {
+		t.Errorf("closed acceptor does not report that it is closed")
+	}
+}
+
+// Stats count the bytes moved at each end and the acceptor counts the
+// connection as active until it closes.
+func checkStats(t *testing.T, f *Factory) {
+	p := newPair(t, f)
+	if p == nil {
+		return
+	}
+	defer p.close()
+	msg := randomBytes(rand.New(rand.NewSource(6)), 1000)
+	if _, err := p.client.Write(msg); err != nil {
+		t.Errorf("Write: %v", err)
+		return
+	}
+	if !expect(t, "stats", p.server, msg) {
+		return
+	}
+	if n := p.client.Stats().BytesWritten; n != uint64(len(msg)) {
+		t.Errorf("client reports %d bytes written, expected %d", n, len(msg))
+	}
+	if n := p.server.Stats().BytesRead; n != uint64(len(msg)) {
+		t.Errorf("server reports %d bytes read, expected %d", n, len(msg))
+	}
+	if p.client.Stats().ConnectedAt.IsZero() {
+		t.Errorf("client reports no connection time")
+	}
+	as := p.acc.Stats()
+	if as.Accepted != 1 || as.Active != 1 {
+		t.Errorf("acceptor reports %d accepted, %d active; expected 1, 1",
+			as.Accepted, as.Active)
+	}
+	p.server.Close()
+	if a := p.acc.Stats().Active; a != 0 {
+		t.Errorf("acceptor reports %d active after close, expected 0", a)
+	}
+	if p.server.Stats().ClosedAt.IsZero() {
+		t.Errorf("closed connection reports no close time")
+	}
+}
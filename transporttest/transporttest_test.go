@@ -0,0 +1,161 @@
+package transporttest
+
+// xlTransport_go/transporttest/transporttest_test.go
+
+import (
+	"fmt"
+	xt "github.com/jddixon/xlTransport_go"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTcpConformance(t *testing.T) {
+	Run(t, &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			return xt.NewTcpAcceptor("127.0.0.1:0")
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			return xt.NewTcpConnector(farEnd)
+		},
+	})
+}
+
+func TestUnixConformance(t *testing.T) {
+	dir := t.TempDir()
+	n := 0
+	Run(t, &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			n++
+			return xt.NewUnixAcceptor(
+				filepath.Join(dir, fmt.Sprintf("acceptor-%d.sock", n)))
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			return xt.NewUnixConnector(farEnd)
+		},
+	})
+}
+
+func TestMockConformance(t *testing.T) {
+	net := xt.NewMockNetwork()
+	n := 0
+	Run(t, &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			n++
+			return net.NewMockAcceptor("T", fmt.Sprintf("acceptor-%d", n))
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			return net.NewMockConnector(farEnd)
+		},
+	})
+}
+
+// A MultiAcceptor over a TCP and a mock acceptor, reached through TCP.
+func TestMultiConformance(t *testing.T) {
+	net := xt.NewMockNetwork()
+	n := 0
+	Run(t, &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			tcpAcc, err := xt.NewTcpAcceptor("127.0.0.1:0")
+			if err != nil {
+				return nil, err
+			}
+			n++
+			mockAcc, err := net.NewMockAcceptor("T", fmt.Sprintf("acceptor-%d", n))
+			if err != nil {
+				tcpAcc.Close()
+				return nil, err
+			}
+			return xt.NewMultiAcceptor(tcpAcc, mockAcc)
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			return xt.NewTcpConnector(farEnd)
+		},
+	})
+}
+
+func TestWsConformance(t *testing.T) {
+	Run(t, &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			return xt.NewWsAcceptor("ws://127.0.0.1:0/xlattice", nil)
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			return xt.NewWsConnector(farEnd, nil)
+		},
+	})
+}
+
+// Each acceptor a node registered with one relay.
+func TestRelayConformance(t *testing.T) {
+	server, err := xt.NewRelayServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	n := 0
+	Run(t, &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			n++
+			ep, err := xt.NewRelayEndPoint(server.GetEndPoint(),
+				[]byte(fmt.Sprintf("node-%d", n)))
+			if err != nil {
+				return nil, err
+			}
+			return xt.NewRelayAcceptor(ep)
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			return xt.NewRelayConnector(farEnd)
+		},
+	})
+}
+
+func TestRudpConformance(t *testing.T) {
+	Run(t, &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			return xt.NewRudpAcceptor("127.0.0.1:0")
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			return xt.NewRudpConnector(farEnd)
+		},
+	})
+}
+
+// Connects from a socket which loses and delays datagrams.
+type lossyRudpConnector struct {
+	*xt.RudpConnector
+	fi *xt.FaultInjector
+}
+
+func (c *lossyRudpConnector) Connect(nearEnd xt.EndPointI) (xt.ConnectionI, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return c.ConnectOn(c.fi.WrapPacketConn(pc))
+}
+
+// Both ends lose one datagram in fifty and reorder others.
+func TestRudpLossyConformance(t *testing.T) {
+	fi := xt.NewFaultInjector(47, &xt.FaultConfig{
+		Latency:  time.Millisecond,
+		Jitter:   time.Millisecond,
+		DropRate: 0.02,
+	})
+	Run(t, &Factory{
+		NewAcceptor: func() (xt.AcceptorI, error) {
+			pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				return nil, err
+			}
+			return xt.NewRudpAcceptorOn(fi.WrapPacketConn(pc)), nil
+		},
+		NewConnector: func(farEnd xt.EndPointI) (xt.ConnectorI, error) {
+			ctor, err := xt.NewRudpConnector(farEnd)
+			if err != nil {
+				return nil, err
+			}
+			return &lossyRudpConnector{ctor, fi}, nil
+		},
+	})
+}
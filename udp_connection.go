@@ -0,0 +1,220 @@
+package transport
+
+// xlTransport_go/udp_connection.go
+
+import (
+	"bytes"
+	"fmt"
+	xc "github.com/jddixon/xlCrypto_go"
+	"io"
+	"net"
+	"sync"
+)
+
+// A connection carrying datagrams between a UDP socket and one far
+// end.  Each Write is sent as a single datagram, and each Read returns
+// the payload of a single datagram; if b is too short the rest of the
+// datagram is lost; RecvMsg returns it whole.  Nothing is
+// retransmitted or reordered, and datagrams from anywhere but the far
+// end are dropped.
+//
+// The socket need not be connected, so that one already used to reach
+// a rendezvous service, and so holding a mapping open through a NAT,
+// can be reused; see HolePunchConnector.  The connection owns the
+// socket and closes it when closed.
+
+const (
+	// The largest payload a UDP datagram can carry over IPv4.
+	UDP_MAX_DATAGRAM = 65507
+)
+
+type UdpConnection struct {
+	conn      net.PacketConn
+	far       *net.UDPAddr
+	near, fEp *UdpEndPoint
+	state     int
+	stats     connCounters
+
+	rMu     sync.Mutex
+	rBuf    []byte
+	pending []byte // a datagram read while hole punching, not yet Read
+
+	// if not nil, hole punching probes for this session, to be
+	// answered or dropped
+	syn, ack []byte
+}
+
+// Carry datagrams between conn and far.
+func NewUdpConnection(conn net.PacketConn, far *UdpEndPoint) (
+	cnx *UdpConnection, err error) {
+
+	if conn == nil {
+		err = NilConnection
+	} else if far == nil {
+		err = NilEndPoint
+	} else {
+		cnx = newUdpConnection(conn, far.udpAddr)
+	}
+	return
+}
+
+func newUdpConnection(conn net.PacketConn, far *net.UDPAddr) *UdpConnection {
+	near, _ := NewUdpEndPoint(conn.LocalAddr().String())
+	c := &UdpConnection{conn: conn, far: far, near: near,
+		fEp: &UdpEndPoint{far}, state: CNX_CONNECTED}
+	c.stats.init(c, "udp", RealClock{})
+	return c
+}
+
+// Whether from is the address datagrams are expected from.
+func (c *UdpConnection) isFar(from net.Addr) bool {
+	u, ok := from.(*net.UDPAddr)
+	return ok && u.IP.Equal(c.far.IP) && u.Port == c.far.Port
+}
+
+// Return the current state index.
+func (c *UdpConnection) GetState() int {
+	return c.state
+}
+
+func (c *UdpConnection) BindNearEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+func (c *UdpConnection) BindFarEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+// Bring the connection to the DISCONNECTED state, closing the socket.
+func (c *UdpConnection) Close() (err error) {
+	observeState(c, c.state, CNX_DISCONNECTED)
+	c.state = CNX_DISCONNECTED
+	c.stats.countClose()
+	return c.wrap("close", c.conn.Close())
+}
+
+func (c *UdpConnection) GetNearEnd() EndPointI {
+	return c.near
+}
+
+func (c *UdpConnection) GetFarEnd() EndPointI {
+	return c.fEp
+}
+
+// Wrap an error from operation op in a TransportError.
+func (c *UdpConnection) wrap(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return wrapError(op, "udp", c.near, c.fEp, err)
+}
+
+// Read the payload of the next datagram from the far end.
+func (c *UdpConnection) Read(b []byte) (count int, err error) {
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+	var d []byte
+	if d, err = c.next(); err == nil {
+		count = copy(b, d)
+	}
+	err = c.wrap("read", err)
+	c.stats.countRead(count, err)
+	return
+}
+
+// Return the next datagram from the far end, which may be overwritten
+// by the next read.  The caller holds rMu.
+func (c *UdpConnection) next() (d []byte, err error) {
+	if c.pending != nil {
+		d, c.pending = c.pending, nil
+		return
+	}
+	if c.rBuf == nil {
+		c.rBuf = make([]byte, UDP_MAX_DATAGRAM)
+	}
+	for {
+		var n int
+		var from net.Addr
+		if n, from, err = c.conn.ReadFrom(c.rBuf); err != nil {
+			return
+		}
+		if c.isFar(from) && !c.punchProbe(c.rBuf[:n]) {
+			return c.rBuf[:n], nil
+		}
+	}
+}
+
+// If d is a hole punching probe, answer it if need be and report that
+// it has been dealt with.
+func (c *UdpConnection) punchProbe(d []byte) bool {
+	if c.syn == nil {
+		return false
+	}
+	if bytes.Equal(d, c.syn) {
+		// the far end has not heard our ACK
+		c.conn.WriteTo(c.ack, c.far)
+		return true
+	}
+	return bytes.Equal(d, c.ack)
+}
+
+// Send b as a single datagram.
+func (c *UdpConnection) Write(b []byte) (count int, err error) {
+	if len(b) > UDP_MAX_DATAGRAM {
+		err = DatagramTooLong
+	} else {
+		count, err = c.conn.WriteTo(b, c.far)
+	}
+	err = c.wrap("write", err)
+	c.stats.countWrite(count, err)
+	return
+}
+
+// DATAGRAMCONNECTIONI //////////////////////////////////////////////
+
+// Send msg as a single datagram.
+func (c *UdpConnection) SendMsg(msg []byte) (err error) {
+	_, err = c.Write(msg)
+	return
+}
+
+// Return the payload of the next datagram from the far end, however
+// long.
+func (c *UdpConnection) RecvMsg() (msg []byte, err error) {
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+	var d []byte
+	if d, err = c.next(); err == nil {
+		msg = append([]byte{}, d...)
+	}
+	err = c.wrap("read", err)
+	c.stats.countRead(len(msg), err)
+	return
+}
+
+func (c *UdpConnection) MaxMsgSize() int {
+	return UDP_MAX_DATAGRAM
+}
+
+func (c *UdpConnection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
+func (c *UdpConnection) IsBlocking() bool {
+	return false
+}
+func (c *UdpConnection) IsEncrypted() bool {
+	return false
+}
+func (c *UdpConnection) Negotiate(myKey xc.KeyI, hisKey xc.PublicKeyI) (
+	s xc.SecretI, e error) {
+
+	return nil, NotImplemented
+}
+
+func (c *UdpConnection) Equal(any interface{}) bool {
+	return any == c
+}
+
+func (c *UdpConnection) String() string {
+	return fmt.Sprintf("Udp: %s --> %s", c.near.String(), c.fEp.String())
+}
@@ -33,14 +33,30 @@ func (e *UdpEndPoint) Transport() string {
 	return "udp"
 }
 
-func (e *UdpEndPoint) Clone() (*UdpEndPoint, error) {
-	return NewUdpEndPoint(e.Address().String())
+// Address() is IPv4-only, so clone from the UDP address itself.
+func (e *UdpEndPoint) Clone() (EndPointI, error) {
+	return NewUdpEndPoint(e.udpAddr.String())
 }
 
+func (e *UdpEndPoint) Equal(any interface{}) bool {
+	other, ok := any.(*UdpEndPoint)
+	if !ok || other == nil {
+		return false
+	}
+	u, ou := e.udpAddr, other.udpAddr
+	return u.IP.Equal(ou.IP) && u.Port == ou.Port && u.Zone == ou.Zone
+}
+
+// Return the bare address, as net.Addr requires.
 func (e *UdpEndPoint) String() string {
 	return e.udpAddr.String()
 }
 
+// Return the end point in the form ParseEndPoint reads.
+func (e *UdpEndPoint) Serialize() string {
+	return "UdpEndPoint: " + e.udpAddr.String()
+}
+
 // net.Addr interface ///////////////////////////////////////////////
 
 // This is just an alias for Transport
@@ -0,0 +1,79 @@
+package transport
+
+// xlTransport_go/unix_acceptor.go
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+)
+
+// Accepts connections on a Unix domain stream socket.  A listener
+// created by NewUnixAcceptor removes its socket file when closed; one
+// built from an inherited file descriptor leaves it alone.
+type UnixAcceptor struct {
+	closed   int32
+	endPoint *UnixEndPoint
+	listener *net.UnixListener
+	stats    acceptorCounters
+}
+
+// Listen at path, which must not already exist.
+func NewUnixAcceptor(path string) (a *UnixAcceptor, err error) {
+	var ep *UnixEndPoint
+	var listener *net.UnixListener
+	if ep, err = NewUnixEndPoint(path); err == nil {
+		listener, err = net.ListenUnix("unix", ep.GetUnixAddr())
+	}
+	if err != nil {
+		return nil, wrapError("listen", "unix", ep, nil, err)
+	}
+	return newUnixAcceptor(listener), nil
+}
+
+func newUnixAcceptor(listener *net.UnixListener) *UnixAcceptor {
+	return &UnixAcceptor{
+		endPoint: unixEndPointFrom(listener.Addr()),
+		listener: listener,
+	}
+}
+
+func (a *UnixAcceptor) Accept() (cnx ConnectionI, err error) {
+	conn, err := a.listener.AcceptUnix()
+	err = wrapError("accept", "unix", a.endPoint, nil, err)
+	if err == nil {
+		var unixCnx *UnixConnection
+		if unixCnx, err = NewUnixConnection(conn); err == nil {
+			a.stats.countAccept(&unixCnx.stats)
+			observeAccept(unixCnx)
+			cnx = unixCnx
+		}
+	} else if !errors.Is(err, net.ErrClosed) {
+		a.stats.countReject()
+		observeError(nil, "accept", err)
+	}
+	return
+}
+func (a *UnixAcceptor) Close() error {
+	atomic.StoreInt32(&a.closed, 1)
+	return a.listener.Close()
+}
+func (a *UnixAcceptor) IsClosed() bool {
+	return atomic.LoadInt32(&a.closed) != 0
+}
+func (a *UnixAcceptor) GetEndPoint() EndPointI {
+	return a.endPoint
+}
+
+// Return a duplicate of the listening socket, for handing to another
+// process.  Closing the one does not affect the other.
+func (a *UnixAcceptor) File() (*os.File, error) {
+	return a.listener.File()
+}
+func (a *UnixAcceptor) Stats() AcceptorStats {
+	return a.stats.snapshot()
+}
+func (a *UnixAcceptor) String() string {
+	return "UnixAcceptor: " + a.endPoint.String()
+}
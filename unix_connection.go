@@ -0,0 +1,101 @@
+package transport
+
+// xlTransport_go/unix_connection.go
+
+import (
+	"fmt"
+	xc "github.com/jddixon/xlCrypto_go"
+	"io"
+	"net"
+)
+
+// A connection over a Unix domain stream socket.
+type UnixConnection struct {
+	conn  *net.UnixConn
+	state int
+	stats connCounters
+}
+
+func NewUnixConnection(conn *net.UnixConn) (cnx *UnixConnection, err error) {
+	if conn == nil {
+		err = NilConnection
+	} else {
+		cnx = &UnixConnection{conn: conn, state: CNX_CONNECTED}
+		cnx.stats.init(cnx, "unix", RealClock{})
+	}
+	return
+}
+
+// Return the current state index.
+func (c *UnixConnection) GetState() int {
+	return c.state
+}
+
+func (c *UnixConnection) BindNearEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+func (c *UnixConnection) BindFarEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+// Bring the connection to the DISCONNECTED state.
+func (c *UnixConnection) Close() (err error) {
+	observeState(c, c.state, CNX_DISCONNECTED)
+	c.state = CNX_DISCONNECTED
+	c.stats.countClose()
+	return c.wrap("close", c.conn.Close())
+}
+
+func (c *UnixConnection) GetNearEnd() EndPointI {
+	return unixEndPointFrom(c.conn.LocalAddr())
+}
+
+func (c *UnixConnection) GetFarEnd() EndPointI {
+	return unixEndPointFrom(c.conn.RemoteAddr())
+}
+
+// Wrap an error from operation op in a TransportError.
+func (c *UnixConnection) wrap(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return wrapError(op, "unix", c.GetNearEnd(), c.GetFarEnd(), err)
+}
+
+func (c *UnixConnection) Read(b []byte) (count int, err error) {
+	count, err = c.conn.Read(b)
+	err = c.wrap("read", err)
+	c.stats.countRead(count, err)
+	return
+}
+func (c *UnixConnection) Write(b []byte) (count int, err error) {
+	count, err = c.conn.Write(b)
+	err = c.wrap("write", err)
+	c.stats.countWrite(count, err)
+	return
+}
+func (c *UnixConnection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
+func (c *UnixConnection) IsBlocking() bool {
+	return false
+}
+func (c *UnixConnection) IsEncrypted() bool {
+	return false
+}
+func (c *UnixConnection) Negotiate(myKey xc.KeyI, hisKey xc.PublicKeyI) (
+	s xc.SecretI, e error) {
+
+	return nil, NotImplemented
+}
+
+func (c *UnixConnection) Equal(any interface{}) bool {
+	return any == c
+}
+
+func (c *UnixConnection) String() string {
+	return fmt.Sprintf("Unix: %s --> %s",
+		c.GetNearEnd().String(),
+		c.GetFarEnd().String())
+}
@@ -0,0 +1,73 @@
+package transport
+
+// xlTransport_go/unix_connection_test.go
+
+import (
+	"errors"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"path/filepath"
+)
+
+func (s *XLSuite) TestUnixConnection(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_UNIX_CONNECTION")
+	}
+	_, err := NewUnixEndPoint("")
+	c.Assert(err, Equals, EmptyAddrString)
+	_, err = NewUnixConnector(ANY_TCP_END_POINT)
+	c.Assert(err, Equals, NotUnixEndPoint)
+
+	path := filepath.Join(c.MkDir(), "node.sock")
+	acc, err := NewUnixAcceptor(path)
+	c.Assert(err, IsNil)
+	c.Assert(acc.String(), Equals, "UnixAcceptor: UnixEndPoint: "+path)
+	ep := acc.GetEndPoint()
+	c.Assert(ep.Transport(), Equals, "unix")
+	c.Assert(ep.Address().String(), Equals, path)
+
+	// end points and connectors survive serialization
+	ep2, err := ParseEndPoint(ep.String())
+	c.Assert(err, IsNil)
+	c.Assert(ep2.Equal(ep), Equals, true)
+	ctor, err := NewUnixConnector(ep)
+	c.Assert(err, IsNil)
+	c.Assert(ctor.String(), Equals, "UnixConnector: "+path)
+	ctor2, err := ParseConnector(ctor.String())
+	c.Assert(err, IsNil)
+	c.Assert(ctor2.GetFarEnd().Equal(ep), Equals, true)
+
+	go func() {
+		cnx, err := acc.Accept()
+		if err == nil {
+			echo(cnx)
+		}
+	}()
+	client, err := ctor2.Connect(nil)
+	c.Assert(err, IsNil)
+	c.Assert(client.GetFarEnd().Equal(ep), Equals, true)
+	c.Assert(client.GetNearEnd().Address().String(), Equals, "")
+
+	msg := []byte("over a Unix domain socket")
+	_, err = client.Write(msg)
+	c.Assert(err, IsNil)
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(client, buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, string(msg))
+	stats := client.Stats()
+	c.Assert(stats.BytesWritten, Equals, uint64(len(msg)))
+	c.Assert(stats.BytesRead, Equals, uint64(len(msg)))
+	c.Assert(client.Close(), IsNil)
+	c.Assert(client.GetState(), Equals, CNX_DISCONNECTED)
+
+	c.Assert(acc.Close(), IsNil)
+	c.Assert(acc.IsClosed(), Equals, true)
+	c.Assert(acc.Stats().Accepted, Equals, uint64(1))
+	_, err = ctor.Connect(nil)
+	c.Assert(err, NotNil)
+	var te *TransportError
+	c.Assert(errors.As(err, &te), Equals, true)
+	c.Assert(te.Transport, Equals, "unix")
+}
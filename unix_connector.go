@@ -0,0 +1,57 @@
+package transport
+
+// xlTransport_go/unix_connector.go
+
+import (
+	"net"
+	"time"
+)
+
+// Connects to a UnixAcceptor.
+type UnixConnector struct {
+	farEnd *UnixEndPoint
+}
+
+func NewUnixConnector(farEnd EndPointI) (*UnixConnector, error) {
+	unixFarEnd, ok := farEnd.(*UnixEndPoint)
+	if !ok || unixFarEnd == nil {
+		return nil, NotUnixEndPoint
+	}
+	ep2, _ := unixFarEnd.Clone()
+	return &UnixConnector{ep2.(*UnixEndPoint)}, nil
+}
+
+// Connect to the far end, binding the near end to nearEnd if it is not
+// nil.
+func (c *UnixConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
+	var laddr *net.UnixAddr
+	if nearEnd != nil {
+		unixNearEnd, ok := nearEnd.(*UnixEndPoint)
+		if !ok {
+			return nil, NotUnixEndPoint
+		}
+		laddr = unixNearEnd.GetUnixAddr()
+	}
+	finish := observeDial(nearEnd, c.farEnd)
+	start := time.Now()
+	conn, err := net.DialUnix("unix", laddr, c.farEnd.GetUnixAddr())
+	DefaultMetrics.transport("unix").countDial(err)
+	if err != nil {
+		err = wrapError("dial", "unix", nearEnd, c.farEnd, err)
+		finish(nil, err)
+		return nil, err
+	}
+	cnx, _ := NewUnixConnection(conn)
+	cnx.stats.setConnectLatency(time.Since(start))
+	finish(cnx, nil)
+	return cnx, nil
+}
+
+func (c *UnixConnector) GetFarEnd() EndPointI {
+	return c.farEnd
+}
+
+func (c *UnixConnector) String() string {
+	// farEnd serialization begins with "UnixEndPoint: "
+	return "UnixConnector: " + c.farEnd.String()[14:]
+}
@@ -0,0 +1,85 @@
+package transport
+
+// xlTransport_go/unix_endpoint.go
+
+import (
+	"net"
+)
+
+// A Unix domain socket end point, identified by a path in the file
+// system.  The near end of a connection dialed without binding has no
+// name; its path is empty.
+
+type UnixEndPoint struct {
+	unixAddr *net.UnixAddr
+}
+
+func NewUnixEndPoint(path string) (*UnixEndPoint, error) {
+	if path == "" {
+		return nil, EmptyAddrString
+	}
+	return &UnixEndPoint{&net.UnixAddr{Name: path, Net: "unix"}}, nil
+}
+
+// Wrap an address reported by the net package, which may be nil for an
+// unnamed socket, or on Linux "@".
+func unixEndPointFrom(addr net.Addr) *UnixEndPoint {
+	name := ""
+	if ua, ok := addr.(*net.UnixAddr); ok && ua != nil && ua.Name != "@" {
+		name = ua.Name
+	}
+	return &UnixEndPoint{&net.UnixAddr{Name: name, Net: "unix"}}
+}
+
+func (e *UnixEndPoint) Address() AddressI {
+	return &UnixAddress{e.unixAddr.Name}
+}
+
+func (e *UnixEndPoint) Clone() (EndPointI, error) {
+	return &UnixEndPoint{&net.UnixAddr{Name: e.unixAddr.Name, Net: "unix"}}, nil
+}
+
+func (e *UnixEndPoint) Equal(any interface{}) bool {
+	other, ok := any.(*UnixEndPoint)
+	return ok && other != nil && other.unixAddr.Name == e.unixAddr.Name
+}
+
+func (e *UnixEndPoint) String() string {
+	return "UnixEndPoint: " + e.unixAddr.Name
+}
+
+func (e *UnixEndPoint) Transport() string {
+	return "unix"
+}
+
+// net.Addr interface ///////////////////////////////////////////////
+
+// This is just an alias for Transport
+func (e *UnixEndPoint) Network() string {
+	return e.Transport()
+}
+
+// Shortcut for Go
+func (e *UnixEndPoint) GetUnixAddr() *net.UnixAddr {
+	return e.unixAddr
+}
+
+// UNIX ADDRESS /////////////////////////////////////////////////////
+
+// The path of a Unix domain socket.
+type UnixAddress struct {
+	Path string
+}
+
+func (a *UnixAddress) Clone() (AddressI, error) {
+	return &UnixAddress{a.Path}, nil
+}
+
+func (a *UnixAddress) Equal(any interface{}) bool {
+	other, ok := any.(*UnixAddress)
+	return ok && other != nil && other.Path == a.Path
+}
+
+func (a *UnixAddress) String() string {
+	return a.Path
+}
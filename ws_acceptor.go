@@ -0,0 +1,176 @@
+package transport
+
+// xlTransport_go/ws_acceptor.go
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Accepts WebSocket connections at a ws:// or wss:// URL.  The opening
+// handshakes are served by net/http; a request for any other path, or
+// which is not a well-formed upgrade to WebSocket version 13, is
+// answered with an HTTP error and counted as rejected.  A client whose
+// handshake succeeds waits, like one in a listen queue, until Accept
+// takes its connection.
+
+type WsAcceptor struct {
+	endPoint  *WsEndPoint
+	listener  net.Listener
+	server    *http.Server
+	cnxs      chan *WsConnection
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	stats     acceptorCounters
+}
+
+// Listen at rawURL, which may give port 0 to have one chosen.  A wss://
+// URL needs tlsConfig, which must carry the server's certificate.
+func NewWsAcceptor(rawURL string, tlsConfig *tls.Config) (*WsAcceptor, error) {
+	ep, err := NewWsEndPoint(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if ep.IsSecure() && tlsConfig == nil {
+		return nil, NilTlsConfig
+	}
+	l, err := net.Listen("tcp", ep.url.Host)
+	if err != nil {
+		return nil, wrapError("listen", ep.Transport(), ep, nil, err)
+	}
+	// the port actually bound
+	ep.url.Host = l.Addr().String()
+	if ep.IsSecure() {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	a := &WsAcceptor{
+		endPoint: ep,
+		listener: l,
+		cnxs:     make(chan *WsConnection),
+		done:     make(chan struct{}),
+	}
+	a.server = &http.Server{Handler: a, ReadHeaderTimeout: WS_HANDSHAKE_TIMEOUT}
+	go a.server.Serve(l)
+	return a, nil
+}
+
+// Whether any of the comma-separated values of header field name in h
+// is token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Serve an opening handshake.
+func (a *WsAcceptor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := 0
+	key := r.Header.Get("Sec-WebSocket-Key")
+	nonce, keyErr := base64.StdEncoding.DecodeString(key)
+	if r.URL.Path != a.endPoint.url.Path {
+		status = http.StatusNotFound
+	} else if r.Method != http.MethodGet {
+		status = http.StatusMethodNotAllowed
+	} else if !headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") ||
+		keyErr != nil || len(nonce) != 16 {
+		status = http.StatusBadRequest
+	} else if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		status = http.StatusUpgradeRequired
+	}
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		a.reject(BadWsHandshake)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot upgrade", http.StatusInternalServerError)
+		a.reject(BadWsHandshake)
+		return
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		a.reject(err)
+		return
+	}
+	conn.SetDeadline(time.Now().Add(WS_HANDSHAKE_TIMEOUT))
+	_, err = conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"))
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		a.reject(err)
+		return
+	}
+	cnx := newWsConnection(conn, brw.Reader, false, a.endPoint,
+		wsEndPointFrom(a.endPoint.Transport(), conn.RemoteAddr()))
+	select {
+	case a.cnxs <- cnx:
+	case <-a.done:
+		cnx.Close()
+		a.stats.countReject()
+	}
+}
+
+func (a *WsAcceptor) reject(err error) {
+	a.stats.countReject()
+	observeError(nil, "accept",
+		wrapError("accept", a.endPoint.Transport(), a.endPoint, nil, err))
+}
+
+func (a *WsAcceptor) Accept() (ConnectionI, error) {
+	select {
+	case cnx := <-a.cnxs:
+		a.stats.countAccept(&cnx.stats)
+		observeAccept(cnx)
+		return cnx, nil
+	case <-a.done:
+		return nil, AcceptorClosed
+	}
+}
+
+// Stop listening.  Connections already accepted are not affected.
+func (a *WsAcceptor) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.done)
+		a.closeErr = a.server.Close()
+	})
+	return a.closeErr
+}
+
+func (a *WsAcceptor) IsClosed() bool {
+	select {
+	case <-a.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a *WsAcceptor) GetEndPoint() EndPointI {
+	return a.endPoint
+}
+
+func (a *WsAcceptor) Stats() AcceptorStats {
+	return a.stats.snapshot()
+}
+
+func (a *WsAcceptor) String() string {
+	return "WsAcceptor: " + a.endPoint.String()
+}
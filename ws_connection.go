@@ -0,0 +1,351 @@
+package transport
+
+// xlTransport_go/ws_connection.go
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	xc "github.com/jddixon/xlCrypto_go"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// A connection carrying a byte stream over WebSocket (RFC 6455), so
+// that a node can be reached through firewalls which pass only HTTP.
+// Each Write is sent as one or more binary frames, and Read returns the
+// payloads of the data frames received, text or binary, one after
+// another; message boundaries are not preserved.  Pings are answered.
+//
+// Close sends a close frame and closes the socket without waiting for
+// the far end's answering close frame.  The close frame is a courtesy:
+// it is skipped if a Write is under way, perhaps blocked on a far end
+// which has stopped reading, and given WS_CLOSE_WAIT to go out.  A close frame received makes
+// Read return io.EOF, after which nothing more may be written.
+
+const (
+	WS_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	WS_OP_CONTINUATION = 0x0
+	WS_OP_TEXT         = 0x1
+	WS_OP_BINARY       = 0x2
+	WS_OP_CLOSE        = 0x8
+	WS_OP_PING         = 0x9
+	WS_OP_PONG         = 0xa
+
+	WS_CLOSE_NORMAL         = 1000
+	WS_CLOSE_PROTOCOL_ERROR = 1002
+
+	// The most payload Write puts in a single frame.
+	WS_MAX_FRAME = 64 * 1024
+
+	// The most a control frame may carry.
+	WS_MAX_CONTROL = 125
+
+	// The most time either end may take over the opening handshake.
+	WS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+	// The most time Close spends sending its close frame.
+	WS_CLOSE_WAIT = time.Second
+)
+
+type WsConnection struct {
+	conn      net.Conn
+	br        *bufio.Reader // buffers conn, perhaps already holding frames
+	client    bool          // frames sent are masked, frames received not
+	near, far *WsEndPoint
+	state     int
+	stats     connCounters
+
+	wMu       sync.Mutex
+	closeSent bool
+
+	rMu       sync.Mutex
+	remaining int64 // payload of the current data frame not yet read
+	masked    bool
+	maskKey   [4]byte
+	maskPos   int
+	inMessage bool  // a fragmented message is being received
+	readErr   error // io.EOF once a close frame is received
+}
+
+func newWsConnection(conn net.Conn, br *bufio.Reader, client bool,
+	near, far *WsEndPoint) *WsConnection {
+
+	c := &WsConnection{conn: conn, br: br, client: client, near: near,
+		far: far, state: CNX_CONNECTED}
+	c.stats.init(c, near.Transport(), RealClock{})
+	return c
+}
+
+// The Sec-WebSocket-Accept value answering key.
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + WS_GUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Return the current state index.
+func (c *WsConnection) GetState() int {
+	return c.state
+}
+
+func (c *WsConnection) BindNearEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+func (c *WsConnection) BindFarEnd(e EndPointI) (err error) {
+	return NotImplemented
+}
+
+// Send a close frame and bring the connection to the DISCONNECTED
+// state.
+func (c *WsConnection) Close() (err error) {
+	observeState(c, c.state, CNX_DISCONNECTED)
+	c.state = CNX_DISCONNECTED
+	c.stats.countClose()
+	if c.wMu.TryLock() {
+		c.conn.SetWriteDeadline(time.Now().Add(WS_CLOSE_WAIT))
+		c.writeFrameLocked(WS_OP_CLOSE, closePayload(WS_CLOSE_NORMAL))
+		c.wMu.Unlock()
+	}
+	return c.wrap("close", c.conn.Close())
+}
+
+func (c *WsConnection) GetNearEnd() EndPointI {
+	return c.near
+}
+
+func (c *WsConnection) GetFarEnd() EndPointI {
+	return c.far
+}
+
+// Wrap an error from operation op in a TransportError.
+func (c *WsConnection) wrap(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return wrapError(op, c.near.Transport(), c.near, c.far, err)
+}
+
+// Read the payload of the data frames received.
+func (c *WsConnection) Read(b []byte) (count int, err error) {
+	c.rMu.Lock()
+	defer c.rMu.Unlock()
+	for c.remaining == 0 && err == nil {
+		if err = c.readErr; err == nil {
+			err = c.nextFrame()
+		}
+	}
+	if err == nil && len(b) > 0 {
+		if int64(len(b)) > c.remaining {
+			b = b[:c.remaining]
+		}
+		count, err = c.br.Read(b)
+		c.unmask(b[:count])
+		c.remaining -= int64(count)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	err = c.wrap("read", err)
+	c.stats.countRead(count, err)
+	return
+}
+
+func (c *WsConnection) unmask(b []byte) {
+	if c.masked {
+		for i := range b {
+			b[i] ^= c.maskKey[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+}
+
+// Read frame headers, handling any control frames, until a data frame
+// begins.  The caller holds rMu.
+func (c *WsConnection) nextFrame() (err error) {
+	var hdr [2]byte
+	if _, err = io.ReadFull(c.br, hdr[:]); err != nil {
+		return
+	}
+	fin, op := hdr[0]&0x80 != 0, hdr[0]&0x0f
+	masked := hdr[1]&0x80 != 0
+	length := int64(hdr[1] & 0x7f)
+	if hdr[0]&0x70 != 0 || masked == c.client {
+		return c.fail()
+	}
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		if length = int64(binary.BigEndian.Uint64(ext[:])); length < 0 {
+			return c.fail()
+		}
+	}
+	c.masked, c.maskPos = masked, 0
+	if masked {
+		if _, err = io.ReadFull(c.br, c.maskKey[:]); err != nil {
+			return
+		}
+	}
+
+	switch op {
+	case WS_OP_CONTINUATION, WS_OP_TEXT, WS_OP_BINARY:
+		if (op == WS_OP_CONTINUATION) != c.inMessage {
+			return c.fail()
+		}
+		c.inMessage = !fin
+		c.remaining = length
+		return
+	case WS_OP_CLOSE, WS_OP_PING, WS_OP_PONG:
+		if !fin || length > WS_MAX_CONTROL {
+			return c.fail()
+		}
+	default:
+		return c.fail()
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	c.unmask(payload)
+	switch op {
+	case WS_OP_PING:
+		err = c.writeFrame(WS_OP_PONG, payload)
+		if err == ClosedConnection {
+			err = nil // we are closing; the ping needs no answer
+		}
+	case WS_OP_CLOSE:
+		if length == 1 {
+			return c.fail()
+		}
+		code := WS_CLOSE_NORMAL
+		if length >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+		}
+		c.sendClose(code)
+		c.readErr = io.EOF
+	}
+	return
+}
+
+// Tell the far end it has broken the protocol; the connection can be
+// read no further.
+func (c *WsConnection) fail() error {
+	c.sendClose(WS_CLOSE_PROTOCOL_ERROR)
+	c.readErr = BadWsFrame
+	return c.readErr
+}
+
+// Send a close frame carrying code, unless one has been sent already.
+func (c *WsConnection) sendClose(code int) {
+	c.writeFrame(WS_OP_CLOSE, closePayload(code))
+}
+
+func closePayload(code int) []byte {
+	payload := make([]byte, 2)
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return payload
+}
+
+// Write a single frame, masked if this is the client's end.
+func (c *WsConnection) writeFrame(op byte, payload []byte) (err error) {
+	c.wMu.Lock()
+	defer c.wMu.Unlock()
+	return c.writeFrameLocked(op, payload)
+}
+
+// The caller holds wMu.
+func (c *WsConnection) writeFrameLocked(op byte, payload []byte) (err error) {
+	if c.closeSent {
+		return ClosedConnection
+	}
+	if op == WS_OP_CLOSE {
+		c.closeSent = true
+	}
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|op)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+	start := len(buf)
+	if c.client {
+		var key [4]byte
+		if _, err = rand.Read(key[:]); err != nil {
+			return
+		}
+		buf = append(buf, key[:]...)
+		start = len(buf)
+		buf = append(buf, payload...)
+		for i := range buf[start:] {
+			buf[start+i] ^= key[i&3]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+	_, err = c.conn.Write(buf)
+	return
+}
+
+// Send b as one or more binary frames.
+func (c *WsConnection) Write(b []byte) (count int, err error) {
+	for count < len(b) && err == nil {
+		chunk := b[count:]
+		if len(chunk) > WS_MAX_FRAME {
+			chunk = chunk[:WS_MAX_FRAME]
+		}
+		if err = c.writeFrame(WS_OP_BINARY, chunk); err == nil {
+			count += len(chunk)
+		}
+	}
+	err = c.wrap("write", err)
+	c.stats.countWrite(count, err)
+	return
+}
+
+func (c *WsConnection) Stats() ConnStats {
+	return c.stats.snapshot()
+}
+func (c *WsConnection) IsBlocking() bool {
+	return false
+}
+func (c *WsConnection) IsEncrypted() bool {
+	return c.near.IsSecure()
+}
+func (c *WsConnection) Negotiate(myKey xc.KeyI, hisKey xc.PublicKeyI) (
+	s xc.SecretI, e error) {
+
+	return nil, NotImplemented
+}
+
+func (c *WsConnection) Equal(any interface{}) bool {
+	return any == c
+}
+
+func (c *WsConnection) String() string {
+	return fmt.Sprintf("Ws: %s --> %s", c.near.String(), c.far.String())
+}
@@ -0,0 +1,266 @@
+package transport
+
+// xlTransport_go/ws_connection_test.go
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	. "gopkg.in/check.v1"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+)
+
+func (s *XLSuite) TestWsEndPoint(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_WS_END_POINT")
+	}
+	for raw, canonical := range map[string]string{
+		"ws://example.com/xl":       "ws://example.com:80/xl",
+		"wss://example.com":         "wss://example.com:443/",
+		"ws://127.0.0.1:8080/a/b":   "ws://127.0.0.1:8080/a/b",
+		"wss://[::1]:8443/xlattice": "wss://[::1]:8443/xlattice",
+	} {
+		ep, err := NewWsEndPoint(raw)
+		c.Assert(err, IsNil)
+		c.Assert(ep.String(), Equals, "WsEndPoint: "+canonical)
+		ep2, err := ParseEndPoint(ep.String())
+		c.Assert(err, IsNil)
+		c.Assert(ep2.Equal(ep), Equals, true)
+		c.Assert(ep.Transport(), Equals, ep.GetURL().Scheme)
+	}
+	for _, raw := range []string{"", "http://example.com/", "ws://",
+		"ws://example.com/?q=1", "ws://user@example.com/"} {
+		_, err := NewWsEndPoint(raw)
+		c.Assert(err, NotNil, Commentf("%q", raw))
+	}
+	_, err := NewWsConnector(ANY_TCP_END_POINT, nil)
+	c.Assert(err, Equals, NotWsEndPoint)
+	_, err = NewWsAcceptor("wss://127.0.0.1:0/", nil)
+	c.Assert(err, Equals, NilTlsConfig)
+}
+
+func (s *XLSuite) TestWsConnection(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_WS_CONNECTION")
+	}
+	// borrow httptest's certificate for wss
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certSrv.Close()
+	clientTLS := certSrv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	for _, scheme := range []string{"ws", "wss"} {
+		var serverTLS *tls.Config
+		if scheme == "wss" {
+			serverTLS = certSrv.TLS
+		}
+		acc, err := NewWsAcceptor(scheme+"://127.0.0.1:0/xlattice", serverTLS)
+		c.Assert(err, IsNil)
+		ep := acc.GetEndPoint()
+		c.Assert(acc.String(), Equals, "WsAcceptor: "+ep.String())
+		go func() {
+			for {
+				cnx, err := acc.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					echo(cnx)
+					cnx.Close()
+				}()
+			}
+		}()
+
+		ctor, err := NewWsConnector(ep, clientTLS)
+		c.Assert(err, IsNil)
+		if scheme == "ws" {
+			ctor2, err := ParseConnector(ctor.String())
+			c.Assert(err, IsNil)
+			c.Assert(ctor2.GetFarEnd().Equal(ep), Equals, true)
+		}
+		cnx, err := ctor.Connect(nil)
+		c.Assert(err, IsNil)
+		c.Assert(cnx.GetFarEnd().Equal(ep), Equals, true)
+		c.Assert(cnx.GetNearEnd().Transport(), Equals, scheme)
+		c.Assert(cnx.IsEncrypted(), Equals, scheme == "wss")
+
+		// more than one frame's worth
+		msg := make([]byte, 3*WS_MAX_FRAME+17)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		go cnx.Write(msg)
+		buf := make([]byte, len(msg))
+		_, err = io.ReadFull(cnx, buf)
+		c.Assert(err, IsNil)
+		c.Assert(buf, DeepEquals, msg)
+		c.Assert(cnx.Close(), IsNil)
+
+		// a request for another path is refused
+		wrong, _ := NewWsEndPoint(scheme + "://" + ep.Address().String() + "/other")
+		ctor, err = NewWsConnector(wrong, clientTLS)
+		c.Assert(err, IsNil)
+		_, err = ctor.Connect(nil)
+		c.Assert(errors.Is(err, BadWsHandshake), Equals, true)
+		c.Assert(acc.Stats().Rejected, Equals, uint64(1))
+
+		c.Assert(acc.Close(), IsNil)
+		c.Assert(acc.IsClosed(), Equals, true)
+		_, err = acc.Accept()
+		c.Assert(err, Equals, AcceptorClosed)
+	}
+}
+
+// A client frame, masked as RFC 6455 requires.
+func wsClientFrame(op byte, fin bool, payload []byte) []byte {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	key := []byte{1, 2, 3, 4}
+	frame := append([]byte{b0, 0x80 | byte(len(payload))}, key...)
+	for i, p := range payload {
+		frame = append(frame, p^key[i&3])
+	}
+	return frame
+}
+
+// Read a server frame, returning its opcode and payload.
+func wsServerFrame(r io.Reader) (op byte, payload []byte, err error) {
+	hdr := make([]byte, 2)
+	if _, err = io.ReadFull(r, hdr); err == nil {
+		op = hdr[0] & 0x0f
+		payload = make([]byte, hdr[1]&0x7f)
+		_, err = io.ReadFull(r, payload)
+	}
+	return
+}
+
+// Talk to a WsAcceptor frame by frame.
+func (s *XLSuite) TestWsFrames(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_WS_FRAMES")
+	}
+	acc, err := NewWsAcceptor("ws://127.0.0.1:0/", nil)
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	conn, err := net.Dial("tcp", acc.GetEndPoint().Address().String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	c.Assert(err, IsNil)
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	defer server.Close()
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusSwitchingProtocols)
+	// the example in RFC 6455
+	c.Assert(resp.Header.Get("Sec-WebSocket-Accept"), Equals,
+		"s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+
+	// a ping is answered while a fragmented text message is read
+	var frames []byte
+	frames = append(frames, wsClientFrame(WS_OP_TEXT, false, []byte("ab"))...)
+	frames = append(frames, wsClientFrame(WS_OP_PING, true, []byte("hi"))...)
+	frames = append(frames, wsClientFrame(WS_OP_CONTINUATION, true, []byte("cd"))...)
+	_, err = conn.Write(frames)
+	c.Assert(err, IsNil)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(server, buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "abcd")
+	op, payload, err := wsServerFrame(br)
+	c.Assert(err, IsNil)
+	c.Assert(op, Equals, byte(WS_OP_PONG))
+	c.Assert(string(payload), Equals, "hi")
+
+	// an unmasked frame from the client breaks the protocol
+	_, err = conn.Write([]byte{0x80 | WS_OP_BINARY, 1, 'x'})
+	c.Assert(err, IsNil)
+	_, err = server.Read(buf)
+	c.Assert(errors.Is(err, BadWsFrame), Equals, true)
+	op, payload, err = wsServerFrame(br)
+	c.Assert(err, IsNil)
+	c.Assert(op, Equals, byte(WS_OP_CLOSE))
+	c.Assert(int(binary.BigEndian.Uint16(payload)), Equals, WS_CLOSE_PROTOCOL_ERROR)
+	_, err = server.Write([]byte("too late"))
+	c.Assert(err, NotNil)
+}
+
+// Close does not wait on a Write blocked by a far end not reading.
+func (s *XLSuite) TestWsCloseWhileWriteBlocked(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_WS_CLOSE_WHILE_WRITE_BLOCKED")
+	}
+	acc, err := NewWsAcceptor("ws://127.0.0.1:0/xlattice", nil)
+	c.Assert(err, IsNil)
+	defer acc.Close()
+	ctor, err := NewWsConnector(acc.GetEndPoint(), nil)
+	c.Assert(err, IsNil)
+	client, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	server, err := acc.Accept()
+	c.Assert(err, IsNil)
+	defer server.Close()
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := client.Write(make([]byte, 64*1024*1024))
+		written <- err
+	}()
+	time.Sleep(100 * time.Millisecond) // for the Write to fill the socket
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		c.Fatal("Close blocked behind Write")
+	}
+	c.Assert(<-written, NotNil)
+}
+
+// Wait up to a few seconds for the named transport's active gauge to
+// read want, returning what it last read.
+func awaitActive(transport string, want int64) (got int64) {
+	tm := DefaultMetrics.transport(transport)
+	for i := 0; i < 200; i++ {
+		if got = atomic.LoadInt64(&tm.active); got == want {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return
+}
+
+// A connection handshaken as the acceptor closes is closed, and so no
+// longer counted as active.
+func (s *XLSuite) TestWsAcceptorClosedWithConnectionWaiting(c *C) {
+	if VERBOSITY > 0 {
+		fmt.Println("TEST_WS_ACCEPTOR_CLOSED_WITH_CONNECTION_WAITING")
+	}
+	active := atomic.LoadInt64(&DefaultMetrics.transport("ws").active)
+	acc, err := NewWsAcceptor("ws://127.0.0.1:0/xlattice", nil)
+	c.Assert(err, IsNil)
+	ctor, err := NewWsConnector(acc.GetEndPoint(), nil)
+	c.Assert(err, IsNil)
+	client, err := ctor.Connect(nil)
+	c.Assert(err, IsNil)
+	c.Assert(awaitActive("ws", active+2), Equals, active+2)
+	acc.Close()
+	client.Close()
+	c.Assert(awaitActive("ws", active), Equals, active)
+}
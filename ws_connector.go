@@ -0,0 +1,127 @@
+package transport
+
+// xlTransport_go/ws_connector.go
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Connects to a WsAcceptor, or any WebSocket server, at a ws:// or
+// wss:// URL.
+type WsConnector struct {
+	farEnd    *WsEndPoint
+	tlsConfig *tls.Config
+}
+
+// tlsConfig is used for wss:// URLs; if it is nil the system's roots
+// are trusted and the server name is taken from the URL.
+func NewWsConnector(farEnd EndPointI, tlsConfig *tls.Config) (*WsConnector, error) {
+	wsFarEnd, ok := farEnd.(*WsEndPoint)
+	if !ok || wsFarEnd == nil {
+		return nil, NotWsEndPoint
+	}
+	ep2, _ := wsFarEnd.Clone()
+	return &WsConnector{ep2.(*WsEndPoint), tlsConfig}, nil
+}
+
+// Connect to the far end, binding the socket to the address of nearEnd
+// if it is not nil, and perform the opening handshake.
+func (c *WsConnector) Connect(nearEnd EndPointI) (ConnectionI, error) {
+	transport := c.farEnd.Transport()
+	d := net.Dialer{Timeout: WS_HANDSHAKE_TIMEOUT}
+	if nearEnd != nil {
+		wsNearEnd, ok := nearEnd.(*WsEndPoint)
+		if !ok {
+			return nil, NotWsEndPoint
+		}
+		laddr, err := net.ResolveTCPAddr("tcp", wsNearEnd.url.Host)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalAddr = laddr
+	}
+	finish := observeDial(nearEnd, c.farEnd)
+	start := time.Now()
+	conn, err := d.Dial("tcp", c.farEnd.url.Host)
+	var br *bufio.Reader
+	if err == nil {
+		if c.farEnd.IsSecure() {
+			cfg := &tls.Config{}
+			if c.tlsConfig != nil {
+				cfg = c.tlsConfig.Clone()
+			}
+			if cfg.ServerName == "" {
+				cfg.ServerName = c.farEnd.url.Hostname()
+			}
+			conn = tls.Client(conn, cfg)
+		}
+		conn.SetDeadline(time.Now().Add(WS_HANDSHAKE_TIMEOUT))
+		br, err = c.handshake(conn)
+		if dErr := conn.SetDeadline(time.Time{}); err == nil {
+			err = dErr
+		}
+		if err != nil {
+			conn.Close()
+		}
+	}
+	DefaultMetrics.transport(transport).countDial(err)
+	if err != nil {
+		err = wrapError("dial", transport, nearEnd, c.farEnd, err)
+		finish(nil, err)
+		return nil, err
+	}
+	cnx := newWsConnection(conn, br, true,
+		wsEndPointFrom(transport, conn.LocalAddr()), c.farEnd)
+	cnx.stats.setConnectLatency(time.Since(start))
+	finish(cnx, nil)
+	return cnx, nil
+}
+
+// Send the opening handshake and check the server's answer, returning
+// a reader holding anything sent after it.
+func (c *WsConnector) handshake(rw io.ReadWriter) (br *bufio.Reader, err error) {
+	var nonce [16]byte
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := "GET " + c.farEnd.requestURI() + " HTTP/1.1\r\n" +
+		"Host: " + c.farEnd.url.Host + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err = io.WriteString(rw, req); err != nil {
+		return
+	}
+	br = bufio.NewReader(rw)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: %s", BadWsHandshake, resp.Status)
+	}
+	if !headerHasToken(resp.Header, "Upgrade", "websocket") ||
+		!headerHasToken(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return nil, BadWsHandshake
+	}
+	return
+}
+
+func (c *WsConnector) GetFarEnd() EndPointI {
+	return c.farEnd
+}
+
+func (c *WsConnector) String() string {
+	return "WsConnector: " + c.farEnd.url.String()
+}
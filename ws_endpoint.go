@@ -0,0 +1,95 @@
+package transport
+
+// xlTransport_go/ws_endpoint.go
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// A WebSocket end point, a ws:// or wss:// URL.  The port is always
+// explicit, 80 or 443 being filled in if the URL gives none, and the
+// path is "/" if it gives none, so that URLs naming the same end point
+// compare equal.  Query and fragment are not allowed.
+//
+// The near ends of WebSocket connections, and the far ends of those
+// accepted, have the scheme of the acceptor and the address of the
+// socket, as in "ws://127.0.0.1:54321/".
+
+type WsEndPoint struct {
+	url *url.URL
+}
+
+func NewWsEndPoint(rawURL string) (*WsEndPoint, error) {
+	if rawURL == "" {
+		return nil, EmptyAddrString
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" ||
+		u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, NotWsEndPoint
+	}
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return &WsEndPoint{u}, nil
+}
+
+// The end point of a socket at addr carrying WebSocket traffic of the
+// scheme given.
+func wsEndPointFrom(scheme string, addr net.Addr) *WsEndPoint {
+	return &WsEndPoint{&url.URL{Scheme: scheme, Host: addr.String(), Path: "/"}}
+}
+
+func (e *WsEndPoint) Address() AddressI {
+	return &HostAddress{e.url.Host}
+}
+
+func (e *WsEndPoint) Clone() (EndPointI, error) {
+	u := *e.url
+	return &WsEndPoint{&u}, nil
+}
+
+func (e *WsEndPoint) Equal(any interface{}) bool {
+	other, ok := any.(*WsEndPoint)
+	return ok && other != nil && other.url.String() == e.url.String()
+}
+
+func (e *WsEndPoint) String() string {
+	return "WsEndPoint: " + e.url.String()
+}
+
+// "ws" or "wss".
+func (e *WsEndPoint) Transport() string {
+	return e.url.Scheme
+}
+
+// Return a copy of the URL.
+func (e *WsEndPoint) GetURL() *url.URL {
+	u := *e.url
+	return &u
+}
+
+// Whether the end point is reached over TLS.
+func (e *WsEndPoint) IsSecure() bool {
+	return e.url.Scheme == "wss"
+}
+
+// The path requested in the handshake, with any escaping.
+func (e *WsEndPoint) requestURI() string {
+	if uri := e.url.RequestURI(); strings.HasPrefix(uri, "/") {
+		return uri
+	}
+	return "/"
+}